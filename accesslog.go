@@ -0,0 +1,119 @@
+package logapi
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// responseRecorder captures the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(p)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// AccessLog writes one Apache "combined" format line per request to out,
+// for use with standard log analyzers (GoAccess, fail2ban, etc)
+func AccessLog(out io.Writer, next http.Handler) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r)
+
+		status := rr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		line := formatCombined(r, start, status, rr.bytes)
+
+		mu.Lock()
+		_, _ = io.WriteString(out, line)
+		mu.Unlock()
+	})
+}
+
+// formatCombined renders a request as
+// host ident authuser [date] "request" status bytes "referer" "user-agent"
+func formatCombined(r *http.Request, start time.Time, status int, bytes int64) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		user = escapeCombined(username)
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprintf("%d", bytes)
+	}
+
+	return fmt.Sprintf(
+		"%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		host,
+		user,
+		start.Format(combinedTimeFormat),
+		escapeCombined(r.Method),
+		escapeCombined(r.RequestURI),
+		escapeCombined(r.Proto),
+		status,
+		size,
+		orDash(escapeCombined(r.Referer())),
+		orDash(escapeCombined(r.UserAgent())),
+	)
+}
+
+// escapeCombined keeps client-controlled values from breaking the line format
+func escapeCombined(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
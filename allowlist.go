@@ -0,0 +1,114 @@
+package logapi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// IPAllowlists limits the addresses listed users may log in from, however
+// they log in. Users who aren't listed may log in from anywhere. A nil
+// *IPAllowlists allows everyone.
+type IPAllowlists struct {
+	byUser map[string][]netip.Prefix
+}
+
+// LoadIPAllowlists reads a user, networks TSV, where networks are
+// comma-separated addresses and CIDRs
+func LoadIPAllowlists(f *os.File) (*IPAllowlists, error) {
+	lists := &IPAllowlists{byUser: make(map[string][]netip.Prefix)}
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 2 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		user := record[0]
+		for _, network := range strings.Split(record[1], ",") {
+			prefix, err := parseNetwork(strings.TrimSpace(network))
+			if err != nil {
+				return nil, fmt.Errorf("invalid network for %q in %q: %w", user, f.Name(), err)
+			}
+			lists.byUser[user] = append(lists.byUser[user], prefix)
+		}
+	}
+
+	return lists, nil
+}
+
+// parseNetwork parses a CIDR, or an address as a network of one
+func parseNetwork(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Allowed reports whether a user may log in from an address
+func (a *IPAllowlists) Allowed(username, remote string) bool {
+	if a == nil {
+		return true
+	}
+	prefixes, ok := a.byUser[username]
+	if !ok {
+		return true
+	}
+	addr, err := netip.ParseAddr(remote)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithIPAllowlists only lets listed users log in from their networks. A
+// password isn't checked from anywhere else.
+func WithIPAllowlists(lists *IPAllowlists) Option {
+	return func(s *Server) {
+		s.allowlists = lists
+	}
+}
+
+// addressAllowed writes a 403 unless the user may log in from the
+// request's address
+func (s *Server) addressAllowed(w http.ResponseWriter, r *http.Request, username string) bool {
+	remote := remoteIP(r)
+	if s.allowlists.Allowed(username, remote) {
+		return true
+	}
+	s.audit("login_address_refused",
+		slog.String("user", username),
+		slog.String("remote", remote),
+	)
+	s.jsonError(w, http.StatusForbidden, "address_not_allowed", "Forbidden", fmt.Sprintf("%q can't log in from %s", username, remote))
+	return false
+}
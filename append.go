@@ -0,0 +1,169 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrAppendTooLarge is returned by Appender.Append when the file would grow
+// past its limit. Nothing is appended.
+var ErrAppendTooLarge = errors.New("file would exceed the append size limit")
+
+// Appender is implemented by storage that can add lines to the end of a
+// live file in place
+type Appender interface {
+	// Append adds body to a live file, creating it if need be, and returns
+	// the file's new size. A newline is added if body doesn't end with one.
+	// Appends to the same file are serialized, and one that fails or would
+	// take the file past maxSize (if above zero) leaves the file as it was.
+	Append(user, date, name string, body io.Reader, maxSize int64) (int64, error)
+}
+
+var _ Appender = (*FSStorage)(nil)
+
+// WithMaxAppendFileBytes limits how large X-File-Append may grow a file;
+// appends that would pass it get a 413. Zero means no limit.
+func WithMaxAppendFileBytes(n int64) Option {
+	return func(s *Server) {
+		s.maxAppendFile = n
+	}
+}
+
+// appendLocks serializes appends (and uploads) to the same live file,
+// striped by path so the set of locks doesn't grow with the files
+var appendLocks [64]sync.Mutex
+
+func lockFile(path string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	lock := &appendLocks[h.Sum32()%uint32(len(appendLocks))]
+	lock.Lock()
+	return lock
+}
+
+// Append writes to the file with O_APPEND, truncating back to its old
+// size if the body fails part way or is too large
+func (fsys *FSStorage) Append(user, date, name string, body io.Reader, maxSize int64) (int64, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return 0, err
+	}
+	defer lockFile(filePath).Unlock()
+
+	if _, err := os.Stat(filePath); errors.Is(err, fs.ErrNotExist) {
+		if tfs, err := fsys.loadTarFS(user, date); err == nil {
+			if _, ok := tfs.EntrySize(filepath.Join(date, name)); ok {
+				return 0, ErrArchived
+			}
+		}
+	}
+	_, statErr := os.Stat(filepath.Dir(filePath))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return 0, err
+	}
+	if err := fsys.unshare(filePath); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	oldSize := info.Size()
+
+	lw := &lastByteWriter{w: f}
+	src := body
+	if maxSize > 0 {
+		// one byte more than fits, to tell a full file from an overfull one
+		src = io.LimitReader(body, max(maxSize-oldSize, 0)+1)
+	}
+	n, err := io.Copy(lw, src)
+	if err == nil && n > 0 && lw.last != '\n' {
+		_, err = f.Write([]byte{'\n'})
+		n++
+	}
+	if err == nil && maxSize > 0 && oldSize+n > maxSize {
+		err = ErrAppendTooLarge
+	}
+	if err == nil && fsys.sync {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Truncate(oldSize)
+		return oldSize, err
+	}
+	// the file may be new, or a copy made by unshare
+	if fsys.sync {
+		if err := fsys.syncDirs(filepath.Dir(filePath), statErr != nil); err != nil {
+			return oldSize + n, err
+		}
+	}
+	return oldSize + n, f.Close()
+}
+
+// lastByteWriter remembers the last byte written through it
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	if n > 0 {
+		lw.last = p[n-1]
+	}
+	return n, err
+}
+
+// isAppend reports whether a POST /api/logs asks to add to the end of the
+// file rather than replace it
+func isAppend(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-File-Append"), "true")
+}
+
+// writeAppend handles POST /api/logs with X-File-Append: true, adding the
+// (decoded) body's lines to the end of a live file
+func (s *Server) writeAppend(w http.ResponseWriter, r *http.Request, user, month, name string) {
+	appender, ok := s.store.(Appender)
+	if !ok {
+		s.jsonError(w, http.StatusNotImplemented, "append_unsupported", "Appends not supported", "This server's storage can't append to files")
+		return
+	}
+
+	size, err := appender.Append(user, month, name, r.Body, s.maxAppendFile)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrArchived):
+			s.jsonError(w, http.StatusConflict, "file_archived", "File is archived", "Archived files can't be appended to")
+		case errors.Is(err, ErrAppendTooLarge):
+			s.jsonError(w, http.StatusRequestEntityTooLarge, "upload_too_large", "Upload too large", fmt.Sprintf("Appends can't grow a file past %d bytes", s.maxAppendFile))
+		case isTooLarge(err):
+			s.uploadTooLarge(w)
+		default:
+			s.jsonError(w, http.StatusInternalServerError, "write_failed", "Failed to write file", err.Error())
+		}
+		return
+	}
+	s.notifyUpload(user, month, name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": fmt.Sprintf("File appended: %s", r.URL.Path),
+		"size":    size,
+	})
+}
@@ -0,0 +1,22 @@
+package logapi
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithAuditLog records data-changing events, such as retention deletions,
+// to logger
+func WithAuditLog(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.auditLog = logger
+	}
+}
+
+// audit writes one audit entry, if an audit log is configured
+func (s *Server) audit(event string, attrs ...slog.Attr) {
+	if s.auditLog == nil {
+		return
+	}
+	s.auditLog.LogAttrs(context.Background(), slog.LevelInfo, event, attrs...)
+}
@@ -0,0 +1,282 @@
+package logapi
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRealm   = "logapi"
+	digestNonceTTL = 5 * time.Minute
+)
+
+// DigestVerifier is implemented by credential stores that can produce
+// HA1 = MD5(username:realm:password) for HTTP Digest authentication
+type DigestVerifier interface {
+	DigestHA1(username, realm string) (string, bool)
+}
+
+// TokenVerifier maps bearer tokens to usernames
+type TokenVerifier interface {
+	VerifyToken(token string) (string, bool)
+}
+
+// AccountDisabler is implemented by credential stores whose users can be
+// disabled. Disabled users are refused however they log in, including with
+// a token or client certificate.
+type AccountDisabler interface {
+	Disabled(username string) bool
+}
+
+// authenticate checks the request's credentials and returns the username,
+// or writes a 401 with the appropriate WWW-Authenticate challenges, a 429
+// if the username or address has failed to log in too often, or a 403 if
+// the user may not log in from the address
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var keys []throttleKey
+	if s.throttle != nil && r.Header.Get("Authorization") != "" {
+		keys = throttleKeys(r)
+		if wait := s.throttle.wait(keys, time.Now()); wait > 0 {
+			s.tooManyAttempts(w, wait)
+			return "", false
+		}
+	}
+
+	if username := loginUsername(r); username != "" && !s.addressAllowed(w, r, username) {
+		return "", false
+	}
+
+	username, ok := s.checkCredentials(w, r)
+	if ok && !s.addressAllowed(w, r, username) {
+		return "", false
+	}
+	if ok && s.disabled(username) {
+		s.challenge(w, false)
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Account disabled")
+		ok = false
+	}
+	if !ok {
+		s.notifyAuthFailure(r)
+		if r.Header.Get("Authorization") != "" {
+			s.loginFailed(r)
+		}
+		if keys != nil {
+			now := time.Now()
+			s.auditLockouts(s.throttle.fail(keys, now), now)
+		}
+		return "", false
+	}
+	s.throttle.succeed(keys)
+	setRequestUser(r, username)
+	return username, true
+}
+
+// disabled reports whether the credential store has disabled a user
+func (s *Server) disabled(username string) bool {
+	ad, ok := s.auth.(AccountDisabler)
+	return ok && ad.Disabled(username)
+}
+
+func (s *Server) checkCredentials(w http.ResponseWriter, r *http.Request) (string, bool) {
+	for _, fn := range s.authFuncs {
+		if username, ok := fn(r); ok {
+			return username, true
+		}
+	}
+
+	authz := r.Header.Get("Authorization")
+	if len(s.tokens) > 0 && strings.HasPrefix(authz, "Bearer ") {
+		token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+		for _, tv := range s.tokens {
+			if username, ok := tv.VerifyToken(token); ok {
+				return username, true
+			}
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, s.realm))
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid token")
+		return "", false
+	}
+
+	if s.digest && strings.HasPrefix(authz, "Digest ") {
+		username, stale, ok := s.verifyDigest(r, strings.TrimPrefix(authz, "Digest "))
+		if ok {
+			return username, true
+		}
+		s.challenge(w, stale)
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+		return "", false
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || !s.auth.Verify(username, password) {
+		s.challenge(w, false)
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+		return "", false
+	}
+	return username, true
+}
+
+// challenge sets the WWW-Authenticate headers for a 401 response
+func (s *Server) challenge(w http.ResponseWriter, stale bool) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, s.realm))
+	if len(s.tokens) > 0 {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, s.realm))
+	}
+	if !s.digest {
+		return
+	}
+
+	staleParam := ""
+	if stale {
+		staleParam = ", stale=true"
+	}
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+		`Digest realm=%q, qop="auth", algorithm=MD5, nonce=%q, opaque=%q%s`,
+		s.realm, s.newDigestNonce(time.Now()), s.digestOpaque(), staleParam,
+	))
+}
+
+// newDigestNonce creates a stateless nonce: timestamp plus an HMAC over it
+func (s *Server) newDigestNonce(now time.Time) string {
+	buf := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(now.Unix()))
+	mac := hmac.New(sha256.New, s.digestKey)
+	_, _ = mac.Write(buf)
+	buf = mac.Sum(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// checkDigestNonce reports whether the nonce was issued by us, and if it has expired
+func (s *Server) checkDigestNonce(nonce string, now time.Time) (valid, stale bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 8+sha256.Size {
+		return false, false
+	}
+	mac := hmac.New(sha256.New, s.digestKey)
+	_, _ = mac.Write(buf[:8])
+	if !hmac.Equal(mac.Sum(nil), buf[8:]) {
+		return false, false
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(buf[:8])), 0)
+	if now.Sub(issued) > digestNonceTTL {
+		return false, true
+	}
+	return true, false
+}
+
+func (s *Server) digestOpaque() string {
+	h := sha256.Sum256(append([]byte("opaque:"), s.digestKey...))
+	return hex.EncodeToString(h[:16])
+}
+
+// verifyDigest validates an RFC 7616 (MD5, qop=auth) Authorization header
+func (s *Server) verifyDigest(r *http.Request, header string) (username string, stale bool, ok bool) {
+	params := parseDigestParams(header)
+	username = params["username"]
+	if username == "" || params["realm"] != s.realm {
+		return "", false, false
+	}
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", false, false
+	}
+	if params["uri"] != r.RequestURI {
+		return "", false, false
+	}
+
+	valid, stale := s.checkDigestNonce(params["nonce"], time.Now())
+	if !valid {
+		return "", stale, false
+	}
+
+	dv, _ := s.auth.(DigestVerifier)
+	if dv == nil {
+		return "", false, false
+	}
+	ha1, found := dv.DigestHA1(username, s.realm)
+	if !found {
+		return "", false, false
+	}
+
+	ha2 := md5Hex(r.Method + ":" + params["uri"])
+	var expected string
+	switch params["qop"] {
+	case "auth":
+		expected = md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	case "":
+		expected = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	default:
+		return "", false, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return "", false, false
+	}
+	return username, false, true
+}
+
+// parseDigestParams splits `key=value, key="quoted, value"` pairs
+func parseDigestParams(header string) map[string]string {
+	params := make(map[string]string)
+	for len(header) > 0 {
+		header = strings.TrimLeft(header, " \t,")
+		eq := strings.IndexByte(header, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(header[:eq]))
+		header = header[eq+1:]
+
+		var value string
+		if strings.HasPrefix(header, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(header); i++ {
+				c := header[i]
+				if c == '\\' && i+1 < len(header) {
+					i++
+					sb.WriteByte(header[i])
+					continue
+				}
+				if c == '"' {
+					break
+				}
+				sb.WriteByte(c)
+			}
+			value = sb.String()
+			if i < len(header) {
+				i++
+			}
+			header = header[i:]
+		} else {
+			end := strings.IndexByte(header, ',')
+			if end < 0 {
+				end = len(header)
+			}
+			value = strings.TrimSpace(header[:end])
+			header = header[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func newDigestKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
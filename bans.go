@@ -0,0 +1,242 @@
+package logapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPBan is an address refused for failing to log in too often
+type IPBan struct {
+	Address  string    `json:"address"`
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+// ipBans counts failed logins per client address, whatever usernames they
+// try, and bans addresses that fail too often
+type ipBans struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time // address -> failures within window
+	bans      map[string]IPBan
+	count     int64 // bans since start
+	lastPrune time.Time
+}
+
+// WithIPBans bans addresses with maxFailures failed logins within window,
+// across every username, for duration. A banned address gets a 403 for
+// every request, with Retry-After, until the ban ends or an admin lifts
+// it. Unlike WithLoginThrottle, a successful login doesn't clear the
+// count, so one working account doesn't cover guessing at others. Zero
+// maxFailures turns it off.
+func WithIPBans(maxFailures int, window, duration time.Duration) Option {
+	return func(s *Server) {
+		s.bans = nil
+		if maxFailures > 0 {
+			s.bans = &ipBans{
+				maxFailures: maxFailures,
+				window:      window,
+				duration:    duration,
+				failures:    make(map[string][]time.Time),
+				bans:        make(map[string]IPBan),
+			}
+		}
+	}
+}
+
+// banned returns an address's ban, if it has one
+func (b *ipBans) banned(remote string, now time.Time) (IPBan, bool) {
+	if b == nil {
+		return IPBan{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[remote]
+	if ok && !ban.Until.After(now) {
+		delete(b.bans, remote)
+		return IPBan{}, false
+	}
+	return ban, ok
+}
+
+// fail counts a failed login from an address, returning its ban if this
+// failure got it banned
+func (b *ipBans) fail(remote string, now time.Time) (IPBan, bool) {
+	if b == nil {
+		return IPBan{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+
+	if _, ok := b.bans[remote]; ok {
+		return IPBan{}, false
+	}
+	recent := slices.DeleteFunc(b.failures[remote], func(t time.Time) bool {
+		return now.Sub(t) > b.window
+	})
+	recent = append(recent, now)
+	if len(recent) < b.maxFailures {
+		b.failures[remote] = recent
+		return IPBan{}, false
+	}
+
+	delete(b.failures, remote)
+	ban := IPBan{Address: remote, Failures: len(recent), Since: now, Until: now.Add(b.duration)}
+	b.bans[remote] = ban
+	b.count++
+	return ban, true
+}
+
+// lift removes an address's ban and failures, reporting whether it was
+// banned
+func (b *ipBans) lift(remote string, now time.Time) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[remote]
+	delete(b.bans, remote)
+	delete(b.failures, remote)
+	return ok && ban.Until.After(now)
+}
+
+// list returns the bans in effect, by address
+func (b *ipBans) list(now time.Time) []IPBan {
+	results := []IPBan{}
+	if b == nil {
+		return results
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ban := range b.bans {
+		if ban.Until.After(now) {
+			results = append(results, ban)
+		}
+	}
+	slices.SortFunc(results, func(a, b IPBan) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return results
+}
+
+// total returns how many bans there have been since the server started
+func (b *ipBans) total() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// prune forgets expired bans and failures from more than a window ago.
+// The caller holds mu.
+func (b *ipBans) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < throttlePruneEvery {
+		return
+	}
+	b.lastPrune = now
+	for remote, times := range b.failures {
+		if now.Sub(times[len(times)-1]) > b.window {
+			delete(b.failures, remote)
+		}
+	}
+	for remote, ban := range b.bans {
+		if !ban.Until.After(now) {
+			delete(b.bans, remote)
+		}
+	}
+}
+
+// refuseBanned wraps a route's handler to refuse banned addresses
+func (s *Server) refuseBanned(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if ban, ok := s.bans.banned(remoteIP(r), now); ok {
+			seconds := int(math.Ceil(ban.Until.Sub(now).Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			s.jsonError(w, http.StatusForbidden, "address_banned", "Address banned", fmt.Sprintf("Too many failed logins from %s; try again in %d seconds", ban.Address, seconds))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loginFailed counts a rejected login toward its address's ban
+func (s *Server) loginFailed(r *http.Request) {
+	s.authFailures.Add(1)
+	if ban, ok := s.bans.fail(remoteIP(r), time.Now()); ok {
+		s.audit("ip_banned",
+			slog.String("remote", ban.Address),
+			slog.Int("failures", ban.Failures),
+			slog.Time("until", ban.Until),
+		)
+	}
+}
+
+// ListBans lists the addresses banned for failed logins (admin only)
+func (s *Server) ListBans(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results": s.bans.list(time.Now()),
+	})
+}
+
+// LiftBan unbans an address before its ban ends (admin only)
+func (s *Server) LiftBan(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	remote := r.PathValue("address")
+	if addr, err := netip.ParseAddr(remote); err == nil {
+		remote = addr.String()
+	}
+	if !s.bans.lift(remote, time.Now()) {
+		s.jsonError(w, http.StatusNotFound, "ban_not_found", "Ban not found", fmt.Sprintf("%s isn't banned", remote))
+		return
+	}
+	s.audit("ip_unbanned",
+		slog.String("remote", remote),
+		slog.String("by", username),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"message": fmt.Sprintf("Ban lifted: %s", remote),
+	})
+}
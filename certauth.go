@@ -0,0 +1,64 @@
+package logapi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// AuthFunc identifies the user making a request from something other than
+// the Authorization header (e.g. the TLS connection). It returns false when
+// the request carries nothing it recognizes, so the next method is tried.
+type AuthFunc func(r *http.Request) (username string, ok bool)
+
+// ClientCertAuth authenticates requests by their verified TLS client
+// certificate. The certificate's CN is looked up in cnToUser, or used as the
+// username directly when cnToUser is nil.
+func ClientCertAuth(cnToUser map[string]string) AuthFunc {
+	return func(r *http.Request) (string, bool) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return "", false
+		}
+
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if cn == "" {
+			return "", false
+		}
+		if cnToUser == nil {
+			return cn, true
+		}
+		username, ok := cnToUser[cn]
+		return username, ok
+	}
+}
+
+// LoadCertUsers reads a cn, user TSV mapping certificate CNs to usernames
+func LoadCertUsers(f *os.File) (map[string]string, error) {
+	cnToUser := make(map[string]string)
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 2 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		cnToUser[record[0]] = record[1]
+	}
+
+	return cnToUser, nil
+}
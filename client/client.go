@@ -0,0 +1,374 @@
+// Package client is a Go client for logapi servers: uploads, listings and
+// downloads, with the server's error codes as comparable errors
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls a logapi server. With Username and Password set it uses
+// basic auth, with BearerToken a bearer token (such as a JWT).
+type Client struct {
+	BaseURL     string // e.g. https://logs.example.com
+	Username    string
+	Password    string
+	BearerToken string
+	HTTPClient  *http.Client // defaults to http.DefaultClient
+}
+
+// New returns a client for baseURL using basic auth
+func New(baseURL, username, password string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		Username: username,
+		Password: password,
+	}
+}
+
+// UploadOptions are the optional headers of an upload
+type UploadOptions struct {
+	UploadID        string // stage the file in an open upload (X-Upload-ID)
+	ContentEncoding string // gzip or zstd, if body is already compressed
+	ContentType     string
+	Append          bool   // add body's lines to the end of the file (X-File-Append)
+	SHA256          string // hex, to skip sending a file that's stored already (X-Content-SHA256)
+}
+
+// Upload streams body to the server as date's (YYYY-MM or YYYY-MM-DD) file
+// name, which may contain subdirectories like web-01/access.log
+func (c *Client) Upload(ctx context.Context, date, name string, body io.Reader, opts *UploadOptions) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/logs", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-File-Date", date)
+	req.Header.Set("X-File-Name", name)
+	if opts != nil {
+		if opts.UploadID != "" {
+			req.Header.Set("X-Upload-ID", opts.UploadID)
+		}
+		if opts.ContentEncoding != "" {
+			req.Header.Set("Content-Encoding", opts.ContentEncoding)
+		}
+		if opts.ContentType != "" {
+			req.Header.Set("Content-Type", opts.ContentType)
+		}
+		if opts.Append {
+			req.Header.Set("X-File-Append", "true")
+		}
+		if opts.SHA256 != "" {
+			req.Header.Set("X-Content-SHA256", opts.SHA256)
+		}
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ListMonths returns the months (YYYY-MM) a user has files for, live or
+// archived
+func (c *Client) ListMonths(ctx context.Context, user string) ([]string, error) {
+	months, err := c.ListMonthInfo(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(months))
+	for i, month := range months {
+		names[i] = month.Month
+	}
+	return names, nil
+}
+
+// Month is an entry of ListMonthInfo. Archived months (Cold ones most of
+// all) are slower to read than live ones.
+type Month struct {
+	Month       string `json:"month"`
+	Live        bool   `json:"live"`
+	Archived    bool   `json:"archived"`
+	Cold        bool   `json:"cold"`
+	Files       int    `json:"files"`
+	Size        int64  `json:"size"`
+	ArchiveSize int64  `json:"archive_size"`
+}
+
+// ListMonthInfo returns a user's months, with whether each is live or
+// archived, and its file count and size
+func (c *Client) ListMonthInfo(ctx context.Context, user string) ([]Month, error) {
+	path := "/api/logs/" + url.PathEscape(user)
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Results []Month `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return result.Results, nil
+}
+
+// ListFiles returns the names in a month or day, with subdirectories (such
+// as a month's days) listed as "DD/"
+func (c *Client) ListFiles(ctx context.Context, user, date string) ([]string, error) {
+	return c.list(ctx, "/api/logs/"+url.PathEscape(user)+"/"+url.PathEscape(date))
+}
+
+// File is an entry of ListAll and ListRange
+type File struct {
+	Month    string `json:"month"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Archived bool   `json:"archived"`
+}
+
+// ListAll returns every file a user has, live and archived, following the
+// recursive listing's pages
+func (c *Client) ListAll(ctx context.Context, user string) ([]File, error) {
+	return c.listFiles(ctx, user, url.Values{"recursive": {"true"}})
+}
+
+// ListRange returns every file, live and archived, of the months from
+// through to (YYYY-MM, inclusive; either may be empty for no bound)
+func (c *Client) ListRange(ctx context.Context, user, from, to string) ([]File, error) {
+	q := url.Values{"recursive": {"true"}}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	return c.listFiles(ctx, user, q)
+}
+
+// listFiles follows the pages of a recursive listing
+func (c *Client) listFiles(ctx context.Context, user string, params url.Values) ([]File, error) {
+	var files []File
+	cursor := ""
+	for {
+		q := maps.Clone(params)
+		q.Set("limit", "10000")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		req, err := c.newRequest(ctx, http.MethodGet, "/api/logs/"+url.PathEscape(user)+"?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Results []File `json:"results"`
+			Next    string `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode listing: %w", err)
+		}
+		files = append(files, page.Results...)
+		if page.Next == "" {
+			return files, nil
+		}
+		cursor = page.Next
+	}
+}
+
+// Match is a line found by Search
+type Match struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Line   string `json:"line"`
+}
+
+// Search returns up to limit lines (0 for the server's default) of a
+// month's or day's files that contain query, and whether there were more
+func (c *Client) Search(ctx context.Context, user, date, query string, limit int) ([]Match, bool, error) {
+	q := url.Values{"q": {query}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/logs/"+url.PathEscape(user)+"/"+url.PathEscape(date)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Results   []Match `json:"results"`
+		Truncated bool    `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, fmt.Errorf("decode search: %w", err)
+	}
+	return result.Results, result.Truncated, nil
+}
+
+// FileStat is what HEAD reports about a file
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
+	SHA256  string // hex
+}
+
+// Stat returns a file's size, modification time and SHA-256 without
+// downloading it. HEAD responses have no body, so errors carry only the
+// status.
+func (c *Client) Stat(ctx context.Context, user, date, name string) (*FileStat, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, filePath(user, date, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &FileStat{
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+		SHA256:  resp.Header.Get("X-Content-SHA256"),
+	}, nil
+}
+
+// Get opens a file for reading; the caller must close it. The body is
+// streamed, not buffered.
+func (c *Client) Get(ctx context.Context, user, date, name string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, filePath(user, date, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Version is a prior version of a file, kept by a server with
+// --overwrite version
+type Version struct {
+	Version int       `json:"version"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Versions returns a file's kept versions, oldest first
+func (c *Client) Versions(ctx context.Context, user, date, name string) ([]Version, error) {
+	path := filePath(user, date, name) + "?versions=true"
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Results []Version `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return result.Results, nil
+}
+
+// GetVersion opens a kept version of a file for reading; the caller must
+// close it
+func (c *Client) GetVersion(ctx context.Context, user, date, name string, version int) (io.ReadCloser, error) {
+	path := filePath(user, date, name) + "?version=" + strconv.Itoa(version)
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) list(ctx context.Context, path string) ([]string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Results []string `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return result.Results, nil
+}
+
+// filePath escapes each segment of name, keeping its slashes
+func filePath(user, date, name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/api/logs/" + url.PathEscape(user) + "/" + url.PathEscape(date) + "/" + strings.Join(segments, "/")
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return req, nil
+}
+
+// do sends req, turning non-2xx responses into an *Error
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return nil, readError(resp)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Error is a failed request, with the server's JSON error if it sent one.
+// Compare it with the Err values by code:
+//
+//	if errors.Is(err, client.ErrFileNotFound) { ... }
+type Error struct {
+	Status    int    `json:"-"`
+	Message   string `json:"error"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("logapi: %d", e.Status)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+// Is matches an *Error with the same Code, such as ErrFileNotFound
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// The server's error codes, for errors.Is
+var (
+	ErrUnauthorized         = &Error{Code: "unauthorized"}
+	ErrTooManyAttempts      = &Error{Code: "too_many_attempts"}
+	ErrAddressBanned        = &Error{Code: "address_banned"}
+	ErrMissingRole          = &Error{Code: "missing_role"}
+	ErrForbidden            = &Error{Code: "forbidden"}
+	ErrMissingHeaders       = &Error{Code: "missing_headers"}
+	ErrDateOutOfRange       = &Error{Code: "date_out_of_range"}
+	ErrInvalidDate          = &Error{Code: "invalid_date"}
+	ErrInvalidLimit         = &Error{Code: "invalid_limit"}
+	ErrInvalidBody          = &Error{Code: "invalid_body"}
+	ErrInvalidMultipart     = &Error{Code: "invalid_multipart"}
+	ErrInvalidName          = &Error{Code: "invalid_name"}
+	ErrNameTooLong          = &Error{Code: "name_too_long"}
+	ErrInvalidNameChars     = &Error{Code: "invalid_name_chars"}
+	ErrExtensionNotAllowed  = &Error{Code: "extension_not_allowed"}
+	ErrSuffixRequired       = &Error{Code: "suffix_required"}
+	ErrInvalidUser          = &Error{Code: "invalid_user"}
+	ErrInvalidEncoding      = &Error{Code: "invalid_encoding"}
+	ErrInvalidExpiresIn     = &Error{Code: "invalid_expires_in"}
+	ErrInvalidAppend        = &Error{Code: "invalid_append"}
+	ErrInvalidQuery         = &Error{Code: "invalid_query"}
+	ErrInvalidFilter        = &Error{Code: "invalid_filter"}
+	ErrNoFiles              = &Error{Code: "no_files"}
+	ErrFileNotFound         = &Error{Code: "file_not_found"}
+	ErrMonthNotFound        = &Error{Code: "month_not_found"}
+	ErrShareNotFound        = &Error{Code: "share_not_found"}
+	ErrUploadNotFound       = &Error{Code: "upload_not_found"}
+	ErrVersionNotFound      = &Error{Code: "version_not_found"}
+	ErrJobNotFound          = &Error{Code: "job_not_found"}
+	ErrFileArchived         = &Error{Code: "file_archived"}
+	ErrFileExists           = &Error{Code: "file_exists"}
+	ErrMonthExists          = &Error{Code: "month_exists"}
+	ErrRestoreRequired      = &Error{Code: "restore_required"}
+	ErrUploadTooLarge       = &Error{Code: "upload_too_large"}
+	ErrTooManyUploads       = &Error{Code: "too_many_uploads"}
+	ErrUnsupportedEncoding  = &Error{Code: "unsupported_encoding"}
+	ErrConfirmationRequired = &Error{Code: "confirmation_required"}
+	ErrServerError          = &Error{Code: "server_error"}
+	ErrWriteFailed          = &Error{Code: "write_failed"}
+	ErrCommitFailed         = &Error{Code: "commit_failed"}
+	ErrAppendUnsupported    = &Error{Code: "append_unsupported"}
+	ErrSearchUnsupported    = &Error{Code: "search_unsupported"}
+)
+
+// readError reads a non-2xx response's JSON error; other bodies (from a
+// proxy, say) leave just the status
+func readError(resp *http.Response) error {
+	e := &Error{Status: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, e) != nil || e.Code == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -2,34 +2,29 @@ package main
 
 import (
 	"bufio"
-	"crypto/rand"
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"hash"
+	"io"
 	"maps"
 	"os"
+	"path/filepath"
+	"runtime"
 	"slices"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/paperos-labs/logapi/csvpass"
-	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/crypto/pbkdf2"
-)
-
-const (
-	defaultIters      = 4096
-	defaultSize       = 16
-	defaultHash       = "SHA-256"
-	defaultBcryptCost = 12
+	"github.com/paperos-labs/logapi/csvpass/sqlitestore"
 )
 
 var (
-	tsvFile = "credentials.tsv"
+	tsvFile    = "credentials.tsv"
+	sqliteFile = ""
+	tokensFile = "tokens.tsv"
+	resetsFile = "reset-tokens.tsv"
 )
 
 func main() {
@@ -43,18 +38,60 @@ func main() {
 		handleSet(os.Args[2:])
 	case "check":
 		handleCheck(os.Args[2:])
+	case "list":
+		handleList(os.Args[2:])
+	case "delete":
+		handleDelete(os.Args[2:])
+	case "rename":
+		handleRename(os.Args[2:])
+	case "disable":
+		handleDisable(os.Args[2:], true)
+	case "enable":
+		handleDisable(os.Args[2:], false)
+	case "import":
+		handleImport(os.Args[2:])
+	case "import-htpasswd":
+		handleImportHtpasswd(os.Args[2:])
+	case "export-htpasswd":
+		handleExportHtpasswd(os.Args[2:])
+	case "export-phc":
+		handleExportPHC(os.Args[2:])
+	case "token":
+		handleToken(os.Args[2:])
+	case "reset-token":
+		handleResetToken(os.Args[2:])
 	default:
-		fmt.Fprintf(os.Stderr, "USAGE\n\tcsvpass [set|check] [--algorithm <plain|pbkdf2[,iters[,size[,hash]]]|bcrypt[,cost]] [--password] [--password-file <filepath>] <username>\n")
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass [set|check] [--algorithm <plain|pbkdf2[,iters[,size[,hash]]]|bcrypt[,cost]|scrypt[,N[,r[,p[,size]]]]|argon2id[,m[,t[,p[,size]]]]] [--roles <upload,read,admin>] [--password] [--password-file <filepath>] <username>\n")
+		fmt.Fprintf(os.Stderr, "\t\tset: [--length <n>] [--charset <base64url|alnum|printable|chars>] [--group <n>] [--wordlist <filepath>] [--min-length <n>] [--min-score <0-4>] [--phc]\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass list [--tsv <filepath> | --sqlite <filepath>]\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass delete [--tsv <filepath>] <username>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass rename [--tsv <filepath>] <old-username> <new-username>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass [disable|enable] [--tsv <filepath>] <username>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass import [--tsv <filepath>] [--algorithm <algo>] [--overwrite] --file <users.csv>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass import-htpasswd [--tsv <filepath>] [--overwrite] <htpasswd-file>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass export-htpasswd [--tsv <filepath>] [htpasswd-file]\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass export-phc [--tsv <filepath>] [file]\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass token [create|list|revoke] [--tokens <filepath>] <username|token-id>\n")
+		fmt.Fprintf(os.Stderr, "\tcsvpass reset-token [create|list|revoke] [--reset-tokens <filepath>] [--expires-in <duration>] <username|token-id>\n")
 		os.Exit(1)
 	}
 }
 
 func handleSet(args []string) {
 	setFlags := flag.NewFlagSet("csvpass-set", flag.ExitOnError)
-	algorithm := setFlags.String("algorithm", "pbkdf2", "Hash algorithm: plain, pbkdf2[,iters[,size[,hash]]], or bcrypt[,cost]")
+	algorithm := setFlags.String("algorithm", csvpass.DefaultAlgorithm, "Hash algorithm: plain, pbkdf2[,iters[,size[,hash]]], bcrypt[,cost], scrypt[,N[,r[,p[,size]]]], or argon2id[,m[,t[,p[,size]]]]")
 	askPassword := setFlags.Bool("password", false, "Read password from stdin")
 	passwordFile := setFlags.String("password-file", "", "Read password from file")
-	setFlags.StringVar(&tsvFile, "tsv", tsvFile, "Credentials file to use")
+	rolesList := setFlags.String("roles", "", "Comma-separated roles: upload, read, admin (default: keep existing, or upload,read)")
+	length := setFlags.Int("length", 0, "Generated password length, in characters or --wordlist words (default 16, or 6 words)")
+	charset := setFlags.String("charset", "base64url", "Characters for generated passwords: base64url, alnum, printable, or the characters themselves")
+	group := setFlags.Int("group", 4, "Put a - between every this many generated characters (0 for none)")
+	wordlist := setFlags.String("wordlist", "", "Generate a passphrase of words from this file (e.g. a diceware list)")
+	minLength := setFlags.Int("min-length", 0, "Reject given passwords shorter than this")
+	minScore := setFlags.Int("min-score", 0, "Reject given passwords with a lower zxcvbn score, from 0 to 4")
+	phc := setFlags.Bool("phc", false, "Store the hash as a PHC string (kept for users stored that way already)")
+	addStoreFlags(setFlags)
 	_ = setFlags.Parse(args)
 	username := setFlags.Arg(0)
 	if username == "id" {
@@ -80,126 +117,46 @@ func handleSet(args []string) {
 		}
 		pass = strings.TrimSpace(data)
 	} else {
-		pass = generatePassword()
+		pass = generatePassword(*length, *charset, *group, *wordlist)
 		fmt.Println(pass)
 	}
-
-	var challenge csvpass.Challenge
-	algoParts := strings.Split(*algorithm, ",")
-	switch algoParts[0] {
-	case "plain":
-		if len(algoParts) != 1 {
-			fmt.Fprintf(os.Stderr, "invalid plain algorithm format: %q\n", *algorithm)
-			os.Exit(1)
-		}
-		challenge.Params = []string{"plain"}
-		challenge.Plain = pass
-		h := sha256.Sum256([]byte(pass))
-		challenge.Digest = h[:]
-	case "pbkdf2":
-		if len(algoParts) > 4 {
-			fmt.Fprintf(os.Stderr, "invalid pbkdf2 algorithm format: %q\n", *algorithm)
-			os.Exit(1)
-		}
-		iters := defaultIters
-		if len(algoParts) > 1 {
-			var err error
-			iters, err = strconv.Atoi(algoParts[1])
-			if err != nil || iters <= 0 {
-				fmt.Fprintf(os.Stderr, "invalid iterations %q in %q\n", algoParts[1], *algorithm)
-				os.Exit(1)
-			}
-		}
-		size := defaultSize
-		if len(algoParts) > 2 {
-			var err error
-			size, err = strconv.Atoi(algoParts[2])
-			if err != nil || size < 8 || size > 32 {
-				fmt.Fprintf(os.Stderr, "invalid size %q in %q\n", algoParts[2], *algorithm)
-				os.Exit(1)
-			}
-		}
-		hashName := defaultHash
-		if len(algoParts) > 3 {
-			if !slices.Contains([]string{"SHA-256", "SHA-1"}, algoParts[3]) {
-				fmt.Fprintf(os.Stderr, "invalid hash %q in %q\n", algoParts[3], *algorithm)
-				os.Exit(1)
-			}
-			hashName = algoParts[3]
-		}
-		challenge.Params = []string{"pbkdf2", strconv.Itoa(iters), strconv.Itoa(size), hashName}
-		saltBytes := make([]byte, 16)
-		_, _ = rand.Read(saltBytes)
-		challenge.Salt = saltBytes
-		var hasher func() hash.Hash
-		switch hashName {
-		case "SHA-1":
-			hasher = sha1.New
-		case "SHA-256":
-			hasher = sha256.New
-		default:
-			fmt.Fprintf(os.Stderr, "invalid hash %q\n", hashName)
-			os.Exit(1)
-		}
-		challenge.Digest = pbkdf2.Key([]byte(pass), saltBytes, iters, size, hasher)
-	case "bcrypt":
-		if len(algoParts) > 2 {
-			fmt.Fprintf(os.Stderr, "invalid bcrypt algorithm format: %q\n", *algorithm)
-			os.Exit(1)
-		}
-		cost := defaultBcryptCost
-		if len(algoParts) > 1 {
-			var err error
-			cost, err = strconv.Atoi(algoParts[1])
-			if err != nil || cost < 4 || cost > 31 {
-				fmt.Fprintf(os.Stderr, "invalid bcrypt cost %q in %q\n", algoParts[1], *algorithm)
-				os.Exit(1)
-			}
-		}
-		challenge.Params = []string{"bcrypt"}
-		digest, err := bcrypt.GenerateFromPassword([]byte(pass), cost)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating bcrypt hash: %v\n", err)
-			os.Exit(1)
-		}
-		challenge.Digest = digest
-	default:
-		fmt.Fprintf(os.Stderr, "invalid algorithm %q\n", algoParts[0])
-		os.Exit(1)
-	}
-
-	f, err := os.Open(tsvFile)
-	if err != nil {
-		f, err = os.Create(tsvFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening/creating CSV: %v\n", err)
+	if len(*passwordFile) > 0 || *askPassword {
+		policy := csvpass.PasswordPolicy{MinLength: *minLength, MinScore: *minScore}
+		if err := policy.Check(pass, username); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 	}
-	defer func() { _ = f.Close() }()
 
-	auth, err := csvpass.Load(f)
+	challenge, err := csvpass.NewChallenge(*algorithm, pass)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading CSV: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	_, exists := auth.Credentials[username]
-	auth.Credentials[username] = challenge
-
-	var records [][]string
-	keys := slices.Sorted(maps.Keys(auth.Credentials))
-	for _, id := range keys {
-		c := auth.Credentials[id]
-		record := c.ToRecord(id)
-		records = append(records, record)
+	store, save := openStore(true)
+	existing, exists := getChallenge(store, username)
+	// keep the rest of an existing user's row
+	challenge.Roles = existing.Roles
+	challenge.Disabled = existing.Disabled
+	challenge.Extra = existing.Extra
+	challenge.Comments = existing.Comments
+	challenge.PHC = existing.PHC || *phc
+	if len(*rolesList) > 0 {
+		roles, err := csvpass.ParseRoles(*rolesList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v in %q\n", err, *rolesList)
+			os.Exit(1)
+		}
+		challenge.Roles = roles
 	}
+	putChallenge(store, username, challenge)
 
-	writeCSV(records)
+	save()
 	if exists {
-		fmt.Fprintf(os.Stderr, "Wrote %q with new password for %q\n", tsvFile, username)
+		fmt.Fprintf(os.Stderr, "Wrote %q with new password for %q\n", storeName(), username)
 	} else {
-		fmt.Fprintf(os.Stderr, "Added password for %q to %q\n", username, tsvFile)
+		fmt.Fprintf(os.Stderr, "Added password for %q to %q\n", username, storeName())
 	}
 }
 
@@ -207,7 +164,7 @@ func handleCheck(args []string) {
 	checkFlags := flag.NewFlagSet("csvpass-check", flag.ExitOnError)
 	_ = checkFlags.Bool("password", true, "Read password from stdin")
 	passwordFile := checkFlags.String("password-file", "", "Read password from file")
-	checkFlags.StringVar(&tsvFile, "tsv", tsvFile, "Password file to use")
+	addStoreFlags(checkFlags)
 	_ = checkFlags.Parse(args)
 	username := checkFlags.Arg(0)
 	if username == "id" {
@@ -234,59 +191,718 @@ func handleCheck(args []string) {
 		pass = strings.TrimSpace(data)
 	}
 
-	f, err := os.Open(tsvFile)
+	store, _ := openStore(false)
+	if challenge, ok := getChallenge(store, username); ok && challenge.Verify(pass) {
+		fmt.Println("verified")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "user '%s' not found or incorrect password\n", username)
+	os.Exit(1)
+}
+
+func handleList(args []string) {
+	listFlags := flag.NewFlagSet("csvpass-list", flag.ExitOnError)
+	addStoreFlags(listFlags)
+	_ = listFlags.Parse(args)
+
+	store, _ := openStore(false)
+	keys, err := store.List()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening CSV: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error listing users: %v\n", err)
+		os.Exit(1)
+	}
+	for _, id := range keys {
+		c, _ := getChallenge(store, id)
+		var disabled string
+		if c.Disabled {
+			disabled = "\tdisabled"
+		}
+		fmt.Printf("%s\t%s\t%s%s\n", id, strings.Join(c.Params, ","), strings.Join(c.EffectiveRoles(), ","), disabled)
+	}
+}
+
+func handleDelete(args []string) {
+	deleteFlags := flag.NewFlagSet("csvpass-delete", flag.ExitOnError)
+	addStoreFlags(deleteFlags)
+	_ = deleteFlags.Parse(args)
+	username := deleteFlags.Arg(0)
+	if len(username) == 0 {
+		fmt.Fprintf(os.Stderr, "username is required\n")
+		os.Exit(1)
+	}
+
+	store, save := openStore(false)
+	if _, exists := getChallenge(store, username); !exists {
+		fmt.Fprintf(os.Stderr, "user %q not found in %q\n", username, storeName())
+		os.Exit(1)
+	}
+	deleteChallenge(store, username)
+
+	save()
+	fmt.Fprintf(os.Stderr, "Deleted %q from %q\n", username, storeName())
+}
+
+func handleRename(args []string) {
+	renameFlags := flag.NewFlagSet("csvpass-rename", flag.ExitOnError)
+	addStoreFlags(renameFlags)
+	_ = renameFlags.Parse(args)
+	oldname, newname := renameFlags.Arg(0), renameFlags.Arg(1)
+	if len(oldname) == 0 || len(newname) == 0 {
+		fmt.Fprintf(os.Stderr, "old and new usernames are required\n")
+		os.Exit(1)
+	}
+	if newname == "id" {
+		fmt.Fprintf(os.Stderr, "invalid username %q\n", newname)
+		os.Exit(1)
+	}
+
+	store, save := openStore(false)
+	challenge, exists := getChallenge(store, oldname)
+	if !exists {
+		fmt.Fprintf(os.Stderr, "user %q not found in %q\n", oldname, storeName())
+		os.Exit(1)
+	}
+	if _, exists := getChallenge(store, newname); exists {
+		fmt.Fprintf(os.Stderr, "user %q already exists in %q\n", newname, storeName())
+		os.Exit(1)
+	}
+	putChallenge(store, newname, challenge)
+	deleteChallenge(store, oldname)
+
+	save()
+	fmt.Fprintf(os.Stderr, "Renamed %q to %q in %q\n", oldname, newname, storeName())
+}
+
+func handleDisable(args []string, disable bool) {
+	name, done := "enable", "Enabled"
+	if disable {
+		name, done = "disable", "Disabled"
+	}
+	disableFlags := flag.NewFlagSet("csvpass-"+name, flag.ExitOnError)
+	addStoreFlags(disableFlags)
+	_ = disableFlags.Parse(args)
+	username := disableFlags.Arg(0)
+	if len(username) == 0 {
+		fmt.Fprintf(os.Stderr, "username is required\n")
 		os.Exit(1)
 	}
+
+	store, save := openStore(false)
+	challenge, exists := getChallenge(store, username)
+	if !exists {
+		fmt.Fprintf(os.Stderr, "user %q not found in %q\n", username, storeName())
+		os.Exit(1)
+	}
+	challenge.Disabled = disable
+	putChallenge(store, username, challenge)
+
+	save()
+	fmt.Fprintf(os.Stderr, "%s %q in %q\n", done, username, storeName())
+}
+
+// importRow is a user to import: a plaintext password to hash, or a
+// challenge that's hashed already
+type importRow struct {
+	line      int
+	username  string
+	password  string
+	challenge csvpass.Challenge
+	roles     []string
+	err       error
+}
+
+func handleImport(args []string) {
+	importFlags := flag.NewFlagSet("csvpass-import", flag.ExitOnError)
+	file := importFlags.String("file", "", "CSV (or .tsv) of users, with a header: username, then password, hash (a PHC string) or algo, salt and digest, and optionally roles")
+	algorithm := importFlags.String("algorithm", csvpass.DefaultAlgorithm, "Hash algorithm for plaintext passwords: plain, pbkdf2[,iters[,size[,hash]]], bcrypt[,cost], scrypt[,N[,r[,p[,size]]]], or argon2id[,m[,t[,p[,size]]]]")
+	overwrite := importFlags.Bool("overwrite", false, "Replace existing users with the imported entry")
+	addStoreFlags(importFlags)
+	_ = importFlags.Parse(args)
+	if len(*file) == 0 {
+		fmt.Fprintf(os.Stderr, "--file is required\n")
+		os.Exit(1)
+	}
+	if _, err := csvpass.NewChallenge(*algorithm, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	rows, err := readImport(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %q: %v\n", *file, err)
+		os.Exit(1)
+	}
+	hashImport(rows, *algorithm)
+	var failed bool
+	for _, row := range rows {
+		if row.err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", *file, row.line, row.err)
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Fprintf(os.Stderr, "Nothing imported from %q\n", *file)
+		os.Exit(1)
+	}
+
+	store, save := openStore(true)
+	var added, replaced int
+	for _, row := range rows {
+		existing, exists := getChallenge(store, row.username)
+		if exists {
+			if !*overwrite {
+				fmt.Fprintf(os.Stderr, "skipping existing user %q\n", row.username)
+				continue
+			}
+			replaced++
+		} else {
+			added++
+		}
+		challenge := row.challenge
+		challenge.Roles = row.roles
+		if row.roles == nil {
+			challenge.Roles = existing.Roles
+		}
+		challenge.Disabled = existing.Disabled
+		challenge.Extra = existing.Extra
+		challenge.Comments = existing.Comments
+		putChallenge(store, row.username, challenge)
+	}
+
+	save()
+	fmt.Fprintf(os.Stderr, "Imported %d new and %d replaced users from %q to %q\n", added, replaced, *file, storeName())
+}
+
+// readImport reads the users of a CSV file, or a TSV file if its name ends
+// in .tsv, by the column names in its header
+func readImport(path string) ([]*importRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 	defer func() { _ = f.Close() }()
 
-	auth, err := csvpass.Load(f)
+	csvr := csv.NewReader(f)
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		csvr.Comma = '\t'
+	}
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = -1
+
+	header, err := csvr.Read()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading CSV: %v\n", err)
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "id" || name == "user" {
+			name = "username"
+		}
+		columns[name] = i
+	}
+	_, hasPassword := columns["password"]
+	_, hasHash := columns["hash"]
+	_, hasDigest := columns["digest"]
+	if _, ok := columns["username"]; !ok || (!hasPassword && !hasHash && !hasDigest) {
+		return nil, fmt.Errorf("header %q needs a username column, and password, hash or algo, salt and digest", strings.Join(header, ","))
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []*importRow
+	seen := make(map[string]int)
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := csvr.FieldPos(0)
+		row := &importRow{line: line, username: strings.TrimSpace(cell(record, "username")), password: cell(record, "password")}
+		rows = append(rows, row)
+
+		switch {
+		case row.username == "" || row.username == "id":
+			row.err = fmt.Errorf("invalid username %q", row.username)
+		case seen[row.username] > 0:
+			row.err = fmt.Errorf("%q is on line %d too", row.username, seen[row.username])
+		case row.password == "" && cell(record, "hash") == "" && cell(record, "digest") == "":
+			row.err = fmt.Errorf("no password, hash or digest for %q", row.username)
+		case row.password == "" && cell(record, "hash") != "":
+			row.challenge, row.err = csvpass.ParsePHC(cell(record, "hash"))
+		case row.password == "":
+			_, row.challenge, row.err = csvpass.ParseRecord([]string{row.username, cell(record, "algo"), cell(record, "salt"), cell(record, "digest")})
+		}
+		seen[row.username] = line
+		if roles := cell(record, "roles"); row.err == nil && len(roles) > 0 {
+			if row.roles, err = csvpass.ParseRoles(roles); err != nil {
+				row.err = fmt.Errorf("%w for %q", err, row.username)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// hashImport hashes the plaintext passwords of rows, on every CPU
+func hashImport(rows []*importRow, algorithm string) {
+	next := make(chan *importRow)
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range next {
+				row.challenge, row.err = csvpass.NewChallenge(algorithm, row.password)
+			}
+		}()
+	}
+	for _, row := range rows {
+		if row.err == nil && row.password != "" {
+			next <- row
+		}
+	}
+	close(next)
+	wg.Wait()
+}
+
+func handleImportHtpasswd(args []string) {
+	importFlags := flag.NewFlagSet("csvpass-import-htpasswd", flag.ExitOnError)
+	overwrite := importFlags.Bool("overwrite", false, "Replace existing users with the htpasswd entry")
+	addStoreFlags(importFlags)
+	_ = importFlags.Parse(args)
+	htpasswdFile := importFlags.Arg(0)
+	if len(htpasswdFile) == 0 {
+		fmt.Fprintf(os.Stderr, "htpasswd file is required\n")
 		os.Exit(1)
 	}
 
-	if auth.Verify(username, pass) {
-		fmt.Println("verified")
-		return
+	hf, err := os.Open(htpasswdFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening htpasswd: %v\n", err)
+		os.Exit(1)
 	}
+	defer func() { _ = hf.Close() }()
 
-	fmt.Fprintf(os.Stderr, "user '%s' not found or incorrect password\n", username)
-	os.Exit(1)
+	imported, err := csvpass.LoadHtpasswd(hf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading htpasswd: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, save := openStore(true)
+	var added, replaced int
+	keys := slices.Sorted(maps.Keys(imported.Credentials))
+	for _, username := range keys {
+		if username == "id" {
+			fmt.Fprintf(os.Stderr, "invalid username %q\n", username)
+			continue
+		}
+		if _, exists := getChallenge(store, username); exists {
+			if !*overwrite {
+				fmt.Fprintf(os.Stderr, "skipping existing user %q\n", username)
+				continue
+			}
+			replaced++
+		} else {
+			added++
+		}
+		putChallenge(store, username, imported.Credentials[username])
+	}
+
+	save()
+	fmt.Fprintf(os.Stderr, "Imported %d new and %d replaced users from %q to %q\n", added, replaced, htpasswdFile, storeName())
+}
+
+func handleExportHtpasswd(args []string) {
+	exportFlags := flag.NewFlagSet("csvpass-export-htpasswd", flag.ExitOnError)
+	addStoreFlags(exportFlags)
+	_ = exportFlags.Parse(args)
+	htpasswdFile := exportFlags.Arg(0)
+
+	store, _ := openStore(false)
+	auth, err := csvpass.Collect(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading credentials: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if len(htpasswdFile) > 0 {
+		out, err = os.OpenFile(htpasswdFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating htpasswd: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = out.Close() }()
+	}
+
+	if err := auth.SaveHtpasswd(out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing htpasswd: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func handleExportPHC(args []string) {
+	exportFlags := flag.NewFlagSet("csvpass-export-phc", flag.ExitOnError)
+	addStoreFlags(exportFlags)
+	_ = exportFlags.Parse(args)
+	phcFile := exportFlags.Arg(0)
+
+	store, _ := openStore(false)
+	auth, err := csvpass.Collect(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading credentials: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if len(phcFile) > 0 {
+		out, err = os.OpenFile(phcFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %q: %v\n", phcFile, err)
+			os.Exit(1)
+		}
+		defer func() { _ = out.Close() }()
+	}
+
+	for _, username := range slices.Sorted(maps.Keys(auth.Credentials)) {
+		phc, err := auth.Credentials[username].PHCString()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %q: %v\n", username, err)
+			continue
+		}
+		if _, err := fmt.Fprintf(out, "%s:%s\n", username, phc); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %q: %v\n", phcFile, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func handleToken(args []string) {
+	var subcmd string
+	if len(args) > 0 {
+		subcmd = args[0]
+		args = args[1:]
+	}
+
+	tokenFlags := flag.NewFlagSet("csvpass-token-"+subcmd, flag.ExitOnError)
+	tokenFlags.StringVar(&tokensFile, "tokens", tokensFile, "Tokens file to use")
+	addStoreFlags(tokenFlags)
+	_ = tokenFlags.Parse(args)
+	arg := tokenFlags.Arg(0)
+
+	switch subcmd {
+	case "create":
+		if len(arg) == 0 {
+			fmt.Fprintf(os.Stderr, "username is required\n")
+			os.Exit(1)
+		}
+		store, _ := openStore(false)
+		if _, exists := getChallenge(store, arg); !exists {
+			fmt.Fprintf(os.Stderr, "user %q not found in %q\n", arg, storeName())
+			os.Exit(1)
+		}
+
+		defer lock(tokensFile)()
+		tokens := loadTokens()
+		plain, token := csvpass.NewToken(arg)
+		tokens.ByDigest[hex.EncodeToString(token.Digest)] = token
+		writeTokens(tokens)
+		fmt.Println(plain)
+		fmt.Fprintf(os.Stderr, "Added token %s for %q to %q\n", token.ID, arg, tokensFile)
+	case "list":
+		tokens := loadTokens()
+		for _, token := range tokens.List(arg) {
+			fmt.Printf("%s\t%s\t%s\n", token.ID, token.User, token.Created.Format(time.RFC3339))
+		}
+	case "revoke":
+		if len(arg) == 0 {
+			fmt.Fprintf(os.Stderr, "token id is required\n")
+			os.Exit(1)
+		}
+		defer lock(tokensFile)()
+		tokens := loadTokens()
+		if !tokens.Revoke(arg) {
+			fmt.Fprintf(os.Stderr, "token %q not found in %q\n", arg, tokensFile)
+			os.Exit(1)
+		}
+		writeTokens(tokens)
+		fmt.Fprintf(os.Stderr, "Revoked token %s in %q\n", arg, tokensFile)
+	default:
+		fmt.Fprintf(os.Stderr, "USAGE\n\tcsvpass token [create|list|revoke] [--tokens <filepath>] <username|token-id>\n")
+		os.Exit(1)
+	}
+}
+
+func handleResetToken(args []string) {
+	var subcmd string
+	if len(args) > 0 {
+		subcmd = args[0]
+		args = args[1:]
+	}
+
+	resetFlags := flag.NewFlagSet("csvpass-reset-token-"+subcmd, flag.ExitOnError)
+	resetFlags.StringVar(&resetsFile, "reset-tokens", resetsFile, "Reset tokens file to use (logapid --reset-tokens)")
+	expiresIn := resetFlags.Duration("expires-in", 24*time.Hour, "How long the token can be used")
+	addStoreFlags(resetFlags)
+	_ = resetFlags.Parse(args)
+	arg := resetFlags.Arg(0)
+
+	resets := csvpass.NewResetTokenFile(resetsFile)
+	switch subcmd {
+	case "create":
+		if len(arg) == 0 {
+			fmt.Fprintf(os.Stderr, "username is required\n")
+			os.Exit(1)
+		}
+		if *expiresIn <= 0 {
+			fmt.Fprintf(os.Stderr, "--expires-in must be positive\n")
+			os.Exit(1)
+		}
+		store, _ := openStore(false)
+		if _, exists := getChallenge(store, arg); !exists {
+			fmt.Fprintf(os.Stderr, "user %q not found in %q\n", arg, storeName())
+			os.Exit(1)
+		}
+
+		plain, expires, err := resets.IssueResetToken(arg, *expiresIn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing reset tokens: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(plain)
+		fmt.Fprintf(os.Stderr, "Added reset token for %q, valid until %s, to %q\n", arg, expires.Format(time.RFC3339), resetsFile)
+	case "list":
+		tokens, err := resets.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading reset tokens: %v\n", err)
+			os.Exit(1)
+		}
+		now := time.Now()
+		for _, token := range tokens.List(arg) {
+			if !now.Before(token.Expires) {
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", token.ID, token.User, token.Created.Format(time.RFC3339), token.Expires.Format(time.RFC3339))
+		}
+	case "revoke":
+		if len(arg) == 0 {
+			fmt.Fprintf(os.Stderr, "reset token id is required\n")
+			os.Exit(1)
+		}
+		found := false
+		err := resets.Update(func(tokens *csvpass.ResetTokens) error {
+			found = tokens.Revoke(arg)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing reset tokens: %v\n", err)
+			os.Exit(1)
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "reset token %q not found in %q\n", arg, resetsFile)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Revoked reset token %s in %q\n", arg, resetsFile)
+	default:
+		fmt.Fprintf(os.Stderr, "USAGE\n\tcsvpass reset-token [create|list|revoke] [--reset-tokens <filepath>] [--expires-in <duration>] <username|token-id>\n")
+		os.Exit(1)
+	}
 }
 
-func writeCSV(records [][]string) {
-	f, err := os.Create(tsvFile)
+// loadTokens reads tokensFile, treating a missing file as empty
+func loadTokens() *csvpass.Tokens {
+	f, err := os.Open(tokensFile)
+	if os.IsNotExist(err) {
+		return &csvpass.Tokens{ByDigest: make(map[string]csvpass.Token)}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating CSV: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error opening tokens: %v\n", err)
 		os.Exit(1)
 	}
 	defer func() { _ = f.Close() }()
 
-	writer := csv.NewWriter(f)
-	writer.Comma = '\t'
+	tokens, err := csvpass.LoadTokens(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading tokens: %v\n", err)
+		os.Exit(1)
+	}
+	return tokens
+}
+
+// writeTokens replaces tokensFile via a temporary file
+func writeTokens(tokens *csvpass.Tokens) {
+	tmpFile := tokensFile + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating tokens: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := tokens.Save(f); err != nil {
+		_ = os.Remove(tmpFile)
+		fmt.Fprintf(os.Stderr, "Error writing tokens: %v\n", err)
+		os.Exit(1)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpFile)
+		fmt.Fprintf(os.Stderr, "Error writing tokens: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.Rename(tmpFile, tokensFile); err != nil {
+		_ = os.Remove(tmpFile)
+		fmt.Fprintf(os.Stderr, "Error replacing tokens: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// addStoreFlags registers the flags that select the credential backend
+func addStoreFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tsvFile, "tsv", tsvFile, "Credentials file to use")
+	fs.StringVar(&sqliteFile, "sqlite", sqliteFile, "SQLite credentials database to use instead of --tsv")
+}
+
+// storeName is the credential backend's path, for messages
+func storeName() string {
+	if len(sqliteFile) > 0 {
+		return sqliteFile
+	}
+	return tsvFile
+}
+
+// openStore opens the backend selected by --sqlite or --tsv. The returned
+// save func persists changes (TSV) or closes the database (SQLite).
+func openStore(create bool) (csvpass.CredentialStore, func()) {
+	if len(sqliteFile) > 0 {
+		if !create {
+			if _, err := os.Stat(sqliteFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening SQLite: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		store, err := sqlitestore.Open(sqliteFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening SQLite: %v\n", err)
+			os.Exit(1)
+		}
+		return store, func() { _ = store.Close() }
+	}
+
+	unlock := lock(tsvFile)
+	auth := loadAuth(create)
+	return auth, func() {
+		writeAuth(auth)
+		unlock()
+	}
+}
 
-	_ = writer.Write([]string{"id", "algo", "salt", "digest"})
-	for _, record := range records {
-		_ = writer.Write(record)
+func getChallenge(store csvpass.CredentialStore, username string) (csvpass.Challenge, bool) {
+	challenge, ok, err := store.Get(username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %q: %v\n", username, err)
+		os.Exit(1)
+	}
+	return challenge, ok
+}
+
+func putChallenge(store csvpass.CredentialStore, username string, challenge csvpass.Challenge) {
+	if err := store.Put(username, challenge); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %q: %v\n", username, err)
+		os.Exit(1)
+	}
+}
+
+func deleteChallenge(store csvpass.CredentialStore, username string) {
+	if err := store.Delete(username); err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting %q: %v\n", username, err)
+		os.Exit(1)
 	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
+}
+
+// loadAuth reads tsvFile, optionally creating it if it doesn't exist
+func loadAuth(create bool) *csvpass.Auth {
+	f, err := os.Open(tsvFile)
+	if err != nil {
+		if !create {
+			fmt.Fprintf(os.Stderr, "Error opening CSV: %v\n", err)
+			os.Exit(1)
+		}
+		f, err = os.Create(tsvFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening/creating CSV: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	defer func() { _ = f.Close() }()
+
+	auth, err := csvpass.Load(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading CSV: %v\n", err)
+		os.Exit(1)
+	}
+	return auth
+}
+
+// writeAuth replaces tsvFile with the credentials sorted by username
+func writeAuth(auth *csvpass.Auth) {
+	if err := csvpass.Save(tsvFile, auth); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func generatePassword() string {
-	bytes := make([]byte, 12)
-	_, _ = rand.Read(bytes)
-	encoded := base64.RawURLEncoding.EncodeToString(bytes)
-	parts := make([]string, 4)
-	start := 0
-	for i := range 4 {
-		parts[i] = encoded[start : start+4]
-		start += 4
-	}
-	return strings.Join(parts, "-")
+// lock takes csvpass.Lock on path, so concurrent runs don't lose each
+// other's edits, waiting for any other run editing it
+func lock(path string) func() {
+	unlock, err := csvpass.Lock(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error locking %q: %v\n", path, err)
+		os.Exit(1)
+	}
+	return unlock
+}
+
+// generatePassword makes a password from the set flags: length characters
+// of charset, grouped, or length words from wordlist
+func generatePassword(length int, charset string, group int, wordlist string) string {
+	generator := csvpass.Generator{Length: length, Group: group}
+	switch charset {
+	case "base64url":
+		generator.Charset = csvpass.CharsetBase64URL
+	case "alnum":
+		generator.Charset = csvpass.CharsetAlnum
+	case "printable":
+		generator.Charset = csvpass.CharsetPrintable
+	default:
+		generator.Charset = charset
+	}
+	if len(wordlist) > 0 {
+		f, err := os.Open(wordlist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening wordlist: %v\n", err)
+			os.Exit(1)
+		}
+		generator.Words, err = csvpass.LoadWordlist(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading wordlist: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	pass, err := generator.Generate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating password: %v\n", err)
+		os.Exit(1)
+	}
+	return pass
 }
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paperos-labs/logapi/objstore"
+)
+
+// migrateSkipDirs aren't copied: uploads in progress, and the blobs
+// --dedup-blobs hardlinks month files to (copied as plain files instead)
+var migrateSkipDirs = []string{".staging", ".blobs"}
+
+// migrateFile is a file to copy, by its slash-separated path under the
+// storage root
+type migrateFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// migrateBackend is a storage root: a directory, or a bucket prefix
+type migrateBackend interface {
+	walk(fn func(migrateFile) error) error
+	// fetch returns a file with name's content, rewound, and its hex
+	// SHA-256; done closes (and for objects, removes) it
+	fetch(name string) (f *os.File, sum string, done func(), err error)
+	// put stores size bytes of f as name, failing unless they hash to sum
+	put(name string, f *os.File, size int64, sum string) error
+}
+
+// migrateMain copies one storage root to another, e.g. from a directory to
+// an S3 bucket, for `logapid migrate --from <backend> --to <backend>`
+func migrateMain(args []string) {
+	migrateFlags := flag.NewFlagSet("logapid-migrate", flag.ExitOnError)
+	from := migrateFlags.String("from", "", "Storage to copy: a directory (like --storage), or s3://bucket/prefix or gs://bucket/prefix")
+	to := migrateFlags.String("to", "", "Storage to copy to, the same way")
+	region := migrateFlags.String("region", "", "Region of s3:// buckets (default: $AWS_REGION)")
+	endpoint := migrateFlags.String("endpoint", "", "S3-compatible server with the buckets, e.g. http://minio:9000")
+	stateFile := migrateFlags.String("state", "logapid-migrate.state", "File recording what's been copied, so an interrupted migration resumes where it stopped")
+	workers := migrateFlags.Int("workers", 4, "How many files to copy at once")
+	_ = migrateFlags.Parse(args)
+	if len(*from) == 0 || len(*to) == 0 {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "\tlogapid migrate --from <dir|s3://bucket/prefix|gs://bucket/prefix> --to <dir|s3://...|gs://...> [--state <filepath>] [--workers <n>]\n")
+		os.Exit(1)
+	}
+
+	src, err := newMigrateBackend(*from, *region, *endpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--from: %v\n", err)
+		os.Exit(1)
+	}
+	dst, err := newMigrateBackend(*to, *region, *endpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--to: %v\n", err)
+		os.Exit(1)
+	}
+	if err := migrate(src, dst, *stateFile, *workers); err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Run it again to resume\n")
+		os.Exit(1)
+	}
+}
+
+// newMigrateBackend opens a directory, which must exist, or a bucket
+func newMigrateBackend(spec, region, endpoint string) (migrateBackend, error) {
+	if !strings.HasPrefix(spec, "s3://") && !strings.HasPrefix(spec, "gs://") {
+		root := strings.TrimPrefix(spec, "file://")
+		if info, err := os.Stat(root); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", root)
+		}
+		return dirBackend{root}, nil
+	}
+
+	creds, err := objstore.CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(region) == 0 {
+		region = os.Getenv("AWS_REGION")
+	}
+	bucket, err := objstore.New(spec, region, endpoint, creds)
+	if err != nil {
+		return nil, err
+	}
+	return bucketBackend{bucket}, nil
+}
+
+// migrate copies every file of src that the state file doesn't record as
+// copied already, verifying each by SHA-256, and logs its progress
+func migrate(src, dst migrateBackend, stateFile string, workers int) error {
+	done, err := loadMigrateState(stateFile)
+	if err != nil {
+		return err
+	}
+	state, err := os.OpenFile(stateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = state.Close() }()
+
+	var (
+		files      []migrateFile
+		totalBytes int64
+		skipped    int
+	)
+	err = src.walk(func(file migrateFile) error {
+		if done[file.stateKey()] {
+			skipped++
+			return nil
+		}
+		files = append(files, file)
+		totalBytes += file.size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("Skipping %d files copied by an earlier run", skipped)
+	}
+	log.Printf("Copying %d files, %d MiB", len(files), totalBytes>>20)
+
+	queue := make(chan migrateFile)
+	var (
+		wg          sync.WaitGroup
+		copied      atomic.Int64
+		copiedBytes atomic.Int64
+		stateLock   sync.Mutex
+		errLock     sync.Mutex
+		errs        []error
+	)
+	failed := func(err error) {
+		errLock.Lock()
+		errs = append(errs, err)
+		errLock.Unlock()
+	}
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				if err := migrateOne(src, dst, file); err != nil {
+					failed(fmt.Errorf("%s: %w", file.name, err))
+					continue
+				}
+				stateLock.Lock()
+				_, err := fmt.Fprintln(state, file.stateKey())
+				stateLock.Unlock()
+				if err != nil {
+					failed(err)
+					continue
+				}
+				n := copied.Add(1)
+				bytes := copiedBytes.Add(file.size)
+				if n%100 == 0 || int(n) == len(files) {
+					log.Printf("Copied %d/%d files, %d/%d MiB", n, len(files), bytes>>20, totalBytes>>20)
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, err := range errs {
+		log.Printf("%v", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d files weren't copied", len(errs), len(files))
+	}
+	log.Printf("Migration done: %d files copied, %d skipped", len(files), skipped)
+	return nil
+}
+
+// migrateOne copies a file, checking the copy against the original's hash
+func migrateOne(src, dst migrateBackend, file migrateFile) error {
+	f, sum, done, err := src.fetch(file.name)
+	if err != nil {
+		return err
+	}
+	defer done()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != file.size {
+		return fmt.Errorf("changed while being copied (%d bytes, was %d); stop logapid first", info.Size(), file.size)
+	}
+	return dst.put(file.name, f, file.size, sum)
+}
+
+// stateKey identifies a file's content well enough to skip it on resume
+func (file migrateFile) stateKey() string {
+	return file.name + "\t" + strconv.FormatInt(file.size, 10) + "\t" + strconv.FormatInt(file.modTime.UnixNano(), 10)
+}
+
+// loadMigrateState reads the files a previous run copied
+func loadMigrateState(stateFile string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		done[scanner.Text()] = true
+	}
+	return done, scanner.Err()
+}
+
+// skipMigrate reports whether a file under the storage root is left out:
+// temp files and what's under migrateSkipDirs
+func skipMigrate(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		for _, dir := range migrateSkipDirs {
+			if segment == dir {
+				return true
+			}
+		}
+	}
+	ext := path.Ext(name)
+	return ext == ".tmp" || ext == ".thaw"
+}
+
+// dirBackend is a storage directory
+type dirBackend struct {
+	root string
+}
+
+func (d dirBackend) walk(fn func(migrateFile) error) error {
+	return filepath.WalkDir(d.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if name != "." && skipMigrate(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || skipMigrate(name) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(migrateFile{name: name, size: info.Size(), modTime: info.ModTime()})
+	})
+}
+
+func (d dirBackend) fetch(name string) (*os.File, string, func(), error) {
+	f, err := os.Open(filepath.Join(d.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	done := func() { _ = f.Close() }
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		done()
+		return nil, "", nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		done()
+		return nil, "", nil, err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), done, nil
+}
+
+func (d dirBackend) put(name string, f *os.File, size int64, sum string) error {
+	dstPath := filepath.Join(d.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := dstPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), f)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("copy has sha256 %s, want %s", got, sum)
+	}
+	// keep mod times, which listings and ETags are made of
+	if info, err := f.Stat(); err == nil {
+		_ = os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+// bucketBackend is a bucket prefix, with a file's path as its key
+type bucketBackend struct {
+	bucket *objstore.Bucket
+}
+
+func (b bucketBackend) walk(fn func(migrateFile) error) error {
+	return b.bucket.List(context.Background(), func(object objstore.Object) error {
+		if strings.HasSuffix(object.Key, "/") || skipMigrate(object.Key) {
+			return nil
+		}
+		return fn(migrateFile{name: object.Key, size: object.Size, modTime: object.ModTime})
+	})
+}
+
+func (b bucketBackend) fetch(name string) (*os.File, string, func(), error) {
+	body, err := b.bucket.Get(context.Background(), name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	f, err := os.CreateTemp("", "logapid-migrate-*")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	done := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), body); err != nil {
+		done()
+		return nil, "", nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		done()
+		return nil, "", nil, err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), done, nil
+}
+
+// put relies on the store rejecting a body that doesn't match
+// x-amz-content-sha256
+func (b bucketBackend) put(name string, f *os.File, size int64, sum string) error {
+	return b.bucket.Put(context.Background(), name, f, size, sum)
+}
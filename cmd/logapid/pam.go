@@ -0,0 +1,15 @@
+//go:build pam
+
+package main
+
+import (
+	"github.com/paperos-labs/logapi"
+	"github.com/paperos-labs/logapi/pamauth"
+)
+
+// newPAMVerifier checks passwords with a PAM service, for --pam-service
+func newPAMVerifier(service, group string) (logapi.BasicAuthVerifier, error) {
+	v := pamauth.New(service)
+	v.Group = group
+	return v, nil
+}
@@ -0,0 +1,14 @@
+//go:build !pam
+
+package main
+
+import (
+	"errors"
+
+	"github.com/paperos-labs/logapi"
+)
+
+// newPAMVerifier fails in builds without the pam tag
+func newPAMVerifier(service, group string) (logapi.BasicAuthVerifier, error) {
+	return nil, errors.New("--pam-service needs logapid built with -tags pam")
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// systemdListeners returns no sockets, as this OS has no systemd
+func systemdListeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// sdNotify does nothing, as this OS has no systemd
+func sdNotify(state string) error {
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/paperos-labs/logapi/client"
+)
+
+// journalEntry is one entry of journalctl's export format
+type journalEntry struct {
+	fields map[string]string
+	time   time.Time
+}
+
+// journalBatch is the JSON lines of one hour's entries
+type journalBatch struct {
+	hour   time.Time
+	buf    bytes.Buffer
+	cursor string // of the last entry in buf
+	part   int    // uploads already made for this hour
+}
+
+func handleJournal(args []string) {
+	hostname, _ := os.Hostname()
+
+	journalFlags := flag.NewFlagSet("logcli-journal", flag.ExitOnError)
+	baseURL := journalFlags.String("url", os.Getenv("LOG_BASEURL"), "Server URL")
+	username := journalFlags.String("user", os.Getenv("LOG_USER"), "Username")
+	token := journalFlags.String("token", os.Getenv("LOG_TOKEN"), "Password or API token")
+	prefix := journalFlags.String("prefix", hostname, "Directory to upload files under (empty for none)")
+	unit := journalFlags.String("unit", "", "Only export this systemd unit's entries")
+	stateFile := journalFlags.String("state", "logcli-journal.cursor", "File recording the journal cursor of the last upload")
+	grace := journalFlags.Duration("grace", time.Minute, "How long after an hour ends to wait for its last entries")
+	_ = journalFlags.Parse(args)
+	if len(*baseURL) == 0 || len(*username) == 0 {
+		fmt.Fprintf(os.Stderr, "--url and --user (or $LOG_BASEURL and $LOG_USER) are required\n")
+		os.Exit(1)
+	}
+
+	cursor, err := os.ReadFile(*stateFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Error reading state %s: %v\n", *stateFile, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := client.New(*baseURL, *username, *token)
+
+	// Resume after the last uploaded entry, or start from now; anything
+	// older than last month would be refused anyway
+	journalArgs := []string{"--output=export", "--follow", "--no-tail"}
+	if len(cursor) > 0 {
+		journalArgs = append(journalArgs, "--after-cursor="+strings.TrimSpace(string(cursor)))
+	} else {
+		journalArgs = append(journalArgs, "--since=now")
+	}
+	if len(*unit) > 0 {
+		journalArgs = append(journalArgs, "--unit="+*unit)
+	}
+	cmd := exec.CommandContext(ctx, "journalctl", journalArgs...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running journalctl: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running journalctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries := make(chan journalEntry)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readJournalExport(ctx, stdout, entries)
+		close(entries)
+	}()
+
+	// Entries are uploaded an hour at a time, as <prefix>/journal-HH.jsonl
+	// under the day; entries arriving after their hour was uploaded go in
+	// journal-HH.1.jsonl and so on, rather than replacing it
+	flush := func(batch *journalBatch) error {
+		if batch.buf.Len() == 0 {
+			return nil
+		}
+		date := batch.hour.Format("2006-01-02")
+		base := "journal"
+		if len(*unit) > 0 {
+			base = *unit
+		}
+		name := base + "-" + batch.hour.Format("15")
+		if batch.part > 0 {
+			name += "." + strconv.Itoa(batch.part)
+		}
+		name += ".jsonl"
+		if *prefix != "" {
+			name = path.Join(*prefix, name)
+		}
+		body := bytes.NewReader(batch.buf.Bytes())
+		err := withRetry(ctx, -1, func() error {
+			_, _ = body.Seek(0, io.SeekStart)
+			return c.Upload(ctx, date, name, body, &client.UploadOptions{ContentType: "application/x-ndjson"})
+		})
+		if err != nil && !rejected(err) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error uploading %s/%s, skipping it: %v\n", date, name, err)
+		} else {
+			fmt.Printf("%s/%s\n", date, name)
+		}
+		if err := writeCursor(*stateFile, batch.cursor); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing state %s: %v\n", *stateFile, err)
+		}
+		batch.buf.Reset()
+		batch.part++
+		return nil
+	}
+	// A failed upload stops the export; the next run starts again from the
+	// last uploaded entry
+	flushOrExit := func(batch *journalBatch) {
+		if err := flush(batch); err != nil {
+			if ctx.Err() != nil {
+				os.Exit(0)
+			}
+			fmt.Fprintf(os.Stderr, "Error uploading journal: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	batch := &journalBatch{}
+	ticker := time.NewTicker(*grace)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				// journalctl exited or was stopped; the partial hour is read
+				// again by the next run
+				_ = cmd.Wait()
+				if err := <-readErr; err != nil && ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "Error reading journal: %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+			hour := entry.time.UTC().Truncate(time.Hour)
+			if !hour.Equal(batch.hour) {
+				flushOrExit(batch)
+				batch.hour = hour
+				batch.part = 0
+			}
+			line, _ := json.Marshal(entry.fields)
+			batch.buf.Write(line)
+			batch.buf.WriteByte('\n')
+			batch.cursor = entry.fields["__CURSOR"]
+		case <-ticker.C:
+			if time.Since(batch.hour.Add(time.Hour)) > *grace {
+				flushOrExit(batch)
+			}
+		}
+	}
+}
+
+// readJournalExport parses journalctl's export format: entries of
+// KEY=value lines, or for binary values KEY, a little-endian uint64 length
+// and the data, separated by blank lines
+func readJournalExport(ctx context.Context, r io.Reader, entries chan<- journalEntry) error {
+	br := bufio.NewReader(r)
+	fields := map[string]string{}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			if len(fields) == 0 {
+				continue
+			}
+			entry := journalEntry{fields: fields}
+			if usec, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+				entry.time = time.UnixMicro(usec)
+			} else {
+				entry.time = time.Now()
+			}
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			fields = map[string]string{}
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+			continue
+		}
+		var size uint64
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		value := make([]byte, size+1) // and its trailing newline
+		if _, err := io.ReadFull(br, value); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		fields[line] = string(value[:size])
+	}
+}
+
+// writeCursor replaces stateFile via a temporary file
+func writeCursor(stateFile, cursor string) error {
+	tmpFile := stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(cursor+"\n"), 0600); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
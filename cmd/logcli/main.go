@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/paperos-labs/logapi/client"
+)
+
+func main() {
+	var subcmd string
+	if len(os.Args) > 1 {
+		subcmd = os.Args[1]
+	}
+
+	switch subcmd {
+	case "sync":
+		handleSync(os.Args[2:])
+	case "watch":
+		handleWatch(os.Args[2:])
+	case "journal":
+		handleJournal(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "\tlogcli sync [--url <base-url>] [--user <username>] [--token <token>] [--jobs 4] [--retries 3] [--checksum] [--dry-run] <dir>\n")
+		fmt.Fprintf(os.Stderr, "\tlogcli watch --glob <pattern> [--url <base-url>] [--user <username>] [--token <token>] [--prefix <hostname>] [--interval 30s] [--settle 5m] [--state logcli-watch.json]\n")
+		fmt.Fprintf(os.Stderr, "\tlogcli journal [--url <base-url>] [--user <username>] [--token <token>] [--prefix <hostname>] [--unit <unit>] [--grace 1m] [--state logcli-journal.cursor]\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "\t--url, --user and --token default to $LOG_BASEURL, $LOG_USER and $LOG_TOKEN\n")
+		os.Exit(1)
+	}
+}
+
+// localFile is a file under the synced directory: <dir>/<YYYY-MM>/<name>
+type localFile struct {
+	month string
+	name  string // slash-separated, may have subdirectories
+	path  string
+	size  int64
+}
+
+func handleSync(args []string) {
+	syncFlags := flag.NewFlagSet("logcli-sync", flag.ExitOnError)
+	baseURL := syncFlags.String("url", os.Getenv("LOG_BASEURL"), "Server URL")
+	username := syncFlags.String("user", os.Getenv("LOG_USER"), "Username")
+	token := syncFlags.String("token", os.Getenv("LOG_TOKEN"), "Password or API token")
+	jobs := syncFlags.Int("jobs", 4, "Files to upload at once")
+	retries := syncFlags.Int("retries", 3, "Retries for each file after network and server errors")
+	checksum := syncFlags.Bool("checksum", false, "Also compare the SHA-256 of files whose size matches (one HEAD each)")
+	dryRun := syncFlags.Bool("dry-run", false, "Only print what would be uploaded")
+	_ = syncFlags.Parse(args)
+	dir := syncFlags.Arg(0)
+	if len(dir) == 0 {
+		fmt.Fprintf(os.Stderr, "a directory to sync is required\n")
+		os.Exit(1)
+	}
+	if len(*baseURL) == 0 || len(*username) == 0 {
+		fmt.Fprintf(os.Stderr, "--url and --user (or $LOG_BASEURL and $LOG_USER) are required\n")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := client.New(*baseURL, *username, *token)
+
+	local, err := walkMonths(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", dir, err)
+		os.Exit(1)
+	}
+	remote, err := c.ListAll(ctx, *username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing remote files: %v\n", err)
+		os.Exit(1)
+	}
+	remoteSizes := make(map[string]int64, len(remote))
+	for _, file := range remote {
+		remoteSizes[file.Month+"/"+file.Name] = file.Size
+	}
+
+	var pending []localFile
+	for _, file := range local {
+		size, ok := remoteSizes[file.month+"/"+file.name]
+		switch {
+		case !ok || size != file.size:
+			pending = append(pending, file)
+		case *checksum:
+			same, err := sameSHA256(ctx, c, *username, file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error comparing %s/%s: %v\n", file.month, file.name, err)
+				same = false
+			}
+			if !same {
+				pending = append(pending, file)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d local files, %d to upload\n", len(local), len(pending))
+	if *dryRun {
+		for _, file := range pending {
+			fmt.Printf("%s/%s\n", file.month, file.name)
+		}
+		return
+	}
+
+	files := make(chan localFile)
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Int64
+	)
+	for range max(*jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				if err := uploadWithRetry(ctx, c, file, *retries); err != nil {
+					failed.Add(1)
+					fmt.Fprintf(os.Stderr, "Error uploading %s/%s: %v\n", file.month, file.name, err)
+					continue
+				}
+				fmt.Printf("%s/%s\n", file.month, file.name)
+			}
+		}()
+	}
+	for _, file := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		files <- file
+	}
+	close(files)
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 || ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "%d of %d uploads failed\n", n, len(pending))
+		os.Exit(1)
+	}
+}
+
+// walkMonths lists the regular files in dir's YYYY-MM subdirectories,
+// skipping anything else at the top level
+func walkMonths(dir string) ([]localFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []localFile
+	for _, entry := range entries {
+		month := entry.Name()
+		if _, err := time.Parse("2006-01", month); err != nil || !entry.IsDir() {
+			fmt.Fprintf(os.Stderr, "Skipping %s: not a YYYY-MM directory\n", filepath.Join(dir, month))
+			continue
+		}
+		monthDir := filepath.Join(dir, month)
+		err := filepath.WalkDir(monthDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(monthDir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, localFile{month: month, name: filepath.ToSlash(rel), path: path, size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func sameSHA256(ctx context.Context, c *client.Client, user string, file localFile) (bool, error) {
+	stat, err := c.Stat(ctx, user, file.month, file.name)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(file.path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return stat.SHA256 == hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadWithRetry uploads a file, retrying with backoff after network
+// errors, 429s and 5xxs; other errors (a date out of range, say) are final
+func uploadWithRetry(ctx context.Context, c *client.Client, file localFile, retries int) error {
+	return withRetry(ctx, retries, func() error {
+		return upload(ctx, c, file)
+	})
+}
+
+const maxBackoff = 30 * time.Minute
+
+// withRetry calls fn until it succeeds, fails with an error that isn't
+// retryable, or has been retried retries times (forever if negative),
+// doubling the wait each time up to maxBackoff
+func withRetry(ctx context.Context, retries int, fn func() error) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || (retries >= 0 && attempt >= retries) || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func upload(ctx context.Context, c *client.Client, file localFile) error {
+	return uploadFile(ctx, c, file.path, file.month, file.name)
+}
+
+func retryable(err error) bool {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500
+	}
+	var pathErr *fs.PathError
+	return !errors.As(err, &pathErr) // local files don't get better
+}
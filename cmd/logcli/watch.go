@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/paperos-labs/logapi/client"
+)
+
+// watchState is the state file: every file already uploaded, keyed by its
+// size and modification time so that a rename by logrotate (app.log.1 to
+// app.log.2) isn't uploaded again
+type watchState struct {
+	Uploaded map[string]uploadedFile `json:"uploaded"`
+}
+
+type uploadedFile struct {
+	Path    string    `json:"path"`
+	Date    string    `json:"date"`
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// retryState is an in-memory backoff for a file whose upload failed
+type retryState struct {
+	next    time.Time
+	backoff time.Duration
+}
+
+func handleWatch(args []string) {
+	hostname, _ := os.Hostname()
+
+	watchFlags := flag.NewFlagSet("logcli-watch", flag.ExitOnError)
+	baseURL := watchFlags.String("url", os.Getenv("LOG_BASEURL"), "Server URL")
+	username := watchFlags.String("user", os.Getenv("LOG_USER"), "Username")
+	token := watchFlags.String("token", os.Getenv("LOG_TOKEN"), "Password or API token")
+	glob := watchFlags.String("glob", "", "Files to watch, e.g. '/var/log/app/*.log.*'")
+	prefix := watchFlags.String("prefix", hostname, "Directory to upload files under (empty for none)")
+	interval := watchFlags.Duration("interval", 30*time.Second, "How often to look for files")
+	settle := watchFlags.Duration("settle", 5*time.Minute, "How long a file must go unmodified to count as closed")
+	stateFile := watchFlags.String("state", "logcli-watch.json", "File recording what has been uploaded")
+	_ = watchFlags.Parse(args)
+	if len(*glob) == 0 {
+		fmt.Fprintf(os.Stderr, "--glob is required\n")
+		os.Exit(1)
+	}
+	if _, err := filepath.Match(*glob, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --glob %q: %v\n", *glob, err)
+		os.Exit(1)
+	}
+	if len(*baseURL) == 0 || len(*username) == 0 {
+		fmt.Fprintf(os.Stderr, "--url and --user (or $LOG_BASEURL and $LOG_USER) are required\n")
+		os.Exit(1)
+	}
+
+	state, err := loadWatchState(*stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading state %s: %v\n", *stateFile, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := client.New(*baseURL, *username, *token)
+
+	retries := map[string]*retryState{}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if pollWatch(ctx, c, state, retries, *glob, *prefix, *settle) {
+			if err := saveWatchState(*stateFile, state); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing state %s: %v\n", *stateFile, err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollWatch uploads every closed file matching glob that isn't in state,
+// reporting whether state changed
+func pollWatch(ctx context.Context, c *client.Client, state *watchState, retries map[string]*retryState, glob, prefix string, settle time.Duration) bool {
+	paths, _ := filepath.Glob(glob) // the pattern was checked at startup
+	sort.Strings(paths)
+
+	changed := pruneWatchState(state)
+	now := time.Now()
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if now.Sub(info.ModTime()) < settle {
+			continue // still being written
+		}
+		key := fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+		if _, ok := state.Uploaded[key]; ok {
+			continue
+		}
+		retry := retries[key]
+		if retry != nil && now.Before(retry.next) {
+			continue
+		}
+
+		// The server takes last month through tomorrow, so older files
+		// can never be uploaded
+		if info.ModTime().Before(firstOfLastMonth(now)) {
+			continue
+		}
+		date := info.ModTime().UTC().Format("2006-01-02")
+
+		name := filepath.Base(p)
+		if prefix != "" {
+			name = path.Join(prefix, name)
+		}
+		if err := uploadFile(ctx, c, p, date, name); err != nil {
+			if rejected(err) {
+				// a bad name or a date out of range won't get better
+				fmt.Fprintf(os.Stderr, "Error uploading %s, not retrying: %v\n", p, err)
+				state.Uploaded[key] = uploadedFile{Path: p, ModTime: info.ModTime()}
+				changed = true
+				continue
+			}
+			if retry == nil {
+				retry = &retryState{backoff: time.Second}
+				retries[key] = retry
+			}
+			fmt.Fprintf(os.Stderr, "Error uploading %s, retrying in %s: %v\n", p, retry.backoff, err)
+			retry.next = time.Now().Add(retry.backoff)
+			retry.backoff = min(retry.backoff*2, maxBackoff)
+			continue
+		}
+		delete(retries, key)
+		state.Uploaded[key] = uploadedFile{Path: p, Date: date, Name: name, ModTime: info.ModTime()}
+		changed = true
+		fmt.Printf("%s -> %s/%s\n", p, date, name)
+	}
+	return changed
+}
+
+// pruneWatchState forgets files too old to be uploaded again, so the state
+// file doesn't grow forever
+func pruneWatchState(state *watchState) bool {
+	cutoff := firstOfLastMonth(time.Now())
+	changed := false
+	for key, file := range state.Uploaded {
+		if file.ModTime.Before(cutoff) {
+			delete(state.Uploaded, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rejected reports whether the server refused the file itself, as opposed
+// to failing, throttling or not accepting the credentials, which may all
+// be fixed by the next try
+func rejected(err error) bool {
+	var apiErr *client.Error
+	return errors.As(err, &apiErr) &&
+		(apiErr.Status == http.StatusBadRequest || apiErr.Status == http.StatusRequestEntityTooLarge)
+}
+
+func firstOfLastMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+}
+
+func uploadFile(ctx context.Context, c *client.Client, p, date, name string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return c.Upload(ctx, date, name, f, nil)
+}
+
+func loadWatchState(stateFile string) (*watchState, error) {
+	state := &watchState{Uploaded: map[string]uploadedFile{}}
+	data, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Uploaded == nil {
+		state.Uploaded = map[string]uploadedFile{}
+	}
+	return state, nil
+}
+
+// saveWatchState replaces stateFile via a temporary file
+func saveWatchState(stateFile string, state *watchState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package logapi
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paperos-labs/logapi/tarfs"
+)
+
+// CompressResult is a month CompressEach archived, or failed to. Files and
+// Bytes count its live files, ArchiveBytes is how much its tarball grew by.
+// In a dry run (WithCompressDryRun) it's a month that would be archived,
+// and Tarball is empty.
+type CompressResult struct {
+	User         string
+	Month        string
+	Tarball      string
+	Err          error
+	DryRun       bool
+	Files        int
+	Bytes        int64
+	ArchiveBytes int64
+	Duration     time.Duration
+	// SavedBytes estimates what compressing the month would free, by the
+	// compression ratio of the existing archives (0 if there are none)
+	SavedBytes int64
+}
+
+// WithCompressWorkers archives up to n months at once (default 1)
+func WithCompressWorkers(n int) Option {
+	return func(s *Server) {
+		s.compressors = n
+	}
+}
+
+// WithCompressOptions sets the tarfs compression options (level, zstd
+// concurrency and window) of the default storage. With WithStorage, set
+// them on the FSStorage instead.
+func WithCompressOptions(opts ...tarfs.Option) Option {
+	return func(s *Server) {
+		s.compressOpts = opts
+	}
+}
+
+// WithCompressDryRun makes CompressEach (and so the schedule) only report
+// which months it would archive and roughly how much space that would save
+func WithCompressDryRun(dryRun bool) Option {
+	return func(s *Server) {
+		s.compressDryRun = dryRun
+	}
+}
+
+// CompressAll archives every live month older than stale and returns the
+// tarballs, sorted. In a dry run it returns the months it would archive,
+// as "user/YYYY-MM".
+func (s *Server) CompressAll(now time.Time, stale time.Duration) ([]string, error) {
+	var tarballs []string
+	err := s.CompressEach(now, stale, func(result CompressResult) {
+		switch {
+		case result.DryRun:
+			tarballs = append(tarballs, result.User+"/"+result.Month)
+		case result.Err == nil:
+			tarballs = append(tarballs, result.Tarball)
+		}
+	})
+	slices.Sort(tarballs)
+	return tarballs, err
+}
+
+// CompressEach archives every live month older than stale, with up to
+// WithCompressWorkers at once, calling fn (never concurrently) as each
+// finishes. After the first error no more months are started; it returns
+// that error once the ones in progress are done. Each run with months to
+// archive is recorded as a CompressJob.
+func (s *Server) CompressEach(now time.Time, stale time.Duration, fn func(CompressResult)) error {
+	thenName := now.Add(-stale).Format("2006-01")
+
+	users, err := s.store.Users()
+	if err != nil {
+		return err
+	}
+	var pending []CompressResult
+	for _, user := range users {
+		months, err := s.store.Months(user)
+		if err != nil {
+			continue
+		}
+		for _, month := range months {
+			if month.Live && month.Name < thenName {
+				pending = append(pending, CompressResult{User: user, Month: month.Name})
+			}
+		}
+	}
+	if s.compressDryRun {
+		return s.planCompression(pending, fn)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	job := s.startCompressJob(len(pending))
+	sizer, _ := s.store.(ArchiveSizer)
+
+	jobs := make(chan CompressResult)
+	results := make(chan CompressResult)
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Bool
+	)
+	for range max(s.compressors, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				start := time.Now()
+				job.Files, job.Bytes, job.Err = s.liveSize(job.User, job.Month)
+				var before int64
+				if job.Err == nil && sizer != nil {
+					before, _ = sizer.ArchiveSize(job.User, job.Month)
+				}
+				if job.Err == nil {
+					job.Tarball, job.Err = s.store.Archive(job.User, job.Month)
+				}
+				if job.Err == nil && sizer != nil {
+					after, _ := sizer.ArchiveSize(job.User, job.Month)
+					job.ArchiveBytes = after - before
+				}
+				job.Duration = time.Since(start)
+				if job.Err != nil {
+					failed.Store(true)
+				}
+				results <- job
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, job := range pending {
+			if failed.Load() {
+				return
+			}
+			jobs <- job
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	for result := range results {
+		if result.Err != nil && firstErr == nil {
+			firstErr = result.Err
+		}
+		if result.Err == nil {
+			s.notify(Event{Event: EventCompress, User: result.User, Date: result.Month, Tarball: result.Tarball})
+		}
+		s.addCompressResult(job, result)
+		if fn != nil {
+			fn(result)
+		}
+	}
+	s.finishCompressJob(job, firstErr)
+	return firstErr
+}
+
+// liveSize counts a month's live files and their bytes
+func (s *Server) liveSize(user, date string) (int, int64, error) {
+	files, err := s.store.List(user, date)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, file := range files {
+		if !file.Archived {
+			count++
+			size += file.Size
+		}
+	}
+	return count, size, nil
+}
+
+// planCompression reports the months CompressEach would archive, with
+// their live files' size
+func (s *Server) planCompression(pending []CompressResult, fn func(CompressResult)) error {
+	ratio, err := s.archiveRatio()
+	if err != nil {
+		return err
+	}
+	for _, result := range pending {
+		result.DryRun = true
+		if result.Files, result.Bytes, err = s.liveSize(result.User, result.Month); err != nil {
+			return err
+		}
+		if ratio > 0 {
+			result.SavedBytes = result.Bytes - int64(float64(result.Bytes)/ratio)
+		}
+		if fn != nil {
+			fn(result)
+		}
+	}
+	return nil
+}
+
+// archiveRatio is the compression ratio of every local archive, or 0 if
+// there are none (or the storage can't tell their size). Cold months are
+// left out, as listing them would fetch them back.
+func (s *Server) archiveRatio() (float64, error) {
+	sizer, ok := s.store.(ArchiveSizer)
+	if !ok {
+		return 0, nil
+	}
+	var st Stats
+	users, err := s.store.Users()
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		months, err := s.store.Months(user)
+		if err != nil {
+			continue
+		}
+		for _, month := range months {
+			if !month.Archived || month.Cold {
+				continue
+			}
+			files, err := s.store.List(user, month.Name)
+			if err != nil {
+				return 0, err
+			}
+			size, err := sizer.ArchiveSize(user, month.Name)
+			if err != nil {
+				return 0, err
+			}
+			for _, file := range files {
+				if file.Archived {
+					st.ArchivedBytes += file.Size
+				}
+			}
+			st.ArchiveBytes += size
+		}
+	}
+	st.setRatio()
+	return st.CompressionRatio, nil
+}
@@ -0,0 +1,200 @@
+package logapi
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the smallest response, when its length is known, that
+// CompressResponses compresses
+const minCompressSize = 1024
+
+// compressedExts are files that are compressed already, and so are sent
+// as they are
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".zst": true, ".zstd": true, ".xz": true,
+	".bz2": true, ".br": true, ".lz4": true, ".zip": true, ".7z": true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return zw
+	}}
+)
+
+// CompressResponses gzip- or zstd-compresses JSON and text responses, such
+// as listings and log downloads, for clients that send Accept-Encoding.
+// Files that are compressed already, HEAD requests and small responses
+// are sent as they are.
+func CompressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || compressedExts[strings.ToLower(path.Ext(r.URL.Path))] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks zstd or gzip from an Accept-Encoding header, by
+// q-value and then preferring zstd, or "" for neither
+func acceptedEncoding(header string) string {
+	var best string
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "zstd" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ || (q == bestQ && name == "zstd") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter decides on the first write whether the response is worth
+// compressing, from its status and headers, and then compresses it or
+// passes it through
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.decide(p)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sniffs the Content-Type, as net/http would, and starts an encoder
+// if the response is compressible
+func (cw *compressWriter) decide(p []byte) {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(p) > 0 {
+		h.Set("Content-Type", http.DetectContentType(p))
+	}
+
+	if cw.compressible(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Encoder)
+			zw.Reset(cw.ResponseWriter)
+			cw.enc = zw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) compressible(h http.Header) bool {
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n < minCompressSize {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/x-ndjson" ||
+		mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// Flush sends what has been compressed so far
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(nil)
+	}
+	if flusher, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the compressed stream, and sends the status of a
+// response that had no body
+func (cw *compressWriter) Close() {
+	if !cw.decided {
+		cw.decided = true
+		if cw.status != 0 {
+			cw.ResponseWriter.WriteHeader(cw.status)
+		}
+		return
+	}
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		_ = enc.Close()
+		enc.Reset(io.Discard)
+		gzipWriters.Put(enc)
+	case *zstd.Encoder:
+		_ = enc.Close()
+		enc.Reset(nil)
+		zstdWriters.Put(enc)
+	}
+	cw.enc = nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
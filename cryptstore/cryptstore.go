@@ -0,0 +1,242 @@
+// Package cryptstore encrypts log files at rest. It wraps a logapi.Storage
+// and stores each file as {name}.enc, sealed with its own random key, which
+// is in turn sealed with a 256-bit master key (AES-256-GCM both times).
+// Files written before encryption was enabled are still readable.
+package cryptstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/paperos-labs/logapi"
+	"github.com/paperos-labs/logapi/internal/aeadstream"
+)
+
+const (
+	suffix = ".enc"
+	magic  = "LAE1"
+)
+
+// Store encrypts files on Put and decrypts them on Open. Sizes in Stat and
+// List are of the plaintext. Archived tarballs hold the encrypted files, so
+// only file names, sizes and dates are visible on disk.
+type Store struct {
+	logapi.Storage
+	master *aeadstream.Sealer
+}
+
+var (
+	_ logapi.Storage = (*Store)(nil)
+	_ logapi.Creator = (*Store)(nil)
+)
+
+// New wraps storage with a 32-byte master key
+func New(storage logapi.Storage, key []byte) (*Store, error) {
+	if len(key) != aeadstream.KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, not %d", aeadstream.KeySize, len(key))
+	}
+	master, err := aeadstream.New(magic, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Storage: storage, master: master}, nil
+}
+
+// LoadKey reads a master key file of 64 hex characters, e.g. from
+// `openssl rand -hex 32`
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(key) != aeadstream.KeySize {
+		return nil, fmt.Errorf("%s: master key must be %d bytes, not %d", path, aeadstream.KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Put encrypts body as name.enc, and removes any plaintext copy
+func (s *Store) Put(user, date, name string, body io.Reader) error {
+	r, err := s.master.NewReader(body)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Put(user, date, name+suffix, r); err != nil {
+		return err
+	}
+	if err := s.Storage.Remove(user, date, name); err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, logapi.ErrArchived) {
+		return err
+	}
+	return nil
+}
+
+// Create is Put for a file that mustn't exist, plaintext or encrypted. The
+// encrypted name is checked and stored in one step when the wrapped
+// storage is a logapi.Creator.
+func (s *Store) Create(user, date, name string, body io.Reader) error {
+	if _, err := s.Storage.Stat(user, date, name); err == nil {
+		return fmt.Errorf("%w: %s/%s", fs.ErrExist, date, name)
+	}
+	creator, ok := s.Storage.(logapi.Creator)
+	if !ok {
+		if _, err := s.Storage.Stat(user, date, name+suffix); err == nil {
+			return fmt.Errorf("%w: %s/%s", fs.ErrExist, date, name)
+		}
+		return s.Put(user, date, name, body)
+	}
+	r, err := s.master.NewReader(body)
+	if err != nil {
+		return err
+	}
+	return creator.Create(user, date, name+suffix, r)
+}
+
+func (s *Store) Open(user, date, name string) (io.ReadCloser, error) {
+	f, err := s.Storage.Open(user, date, name+suffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Storage.Open(user, date, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.decrypter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Store) Stat(user, date, name string) (logapi.FileInfo, error) {
+	info, err := s.Storage.Stat(user, date, name+suffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Storage.Stat(user, date, name)
+	}
+	if err != nil {
+		return logapi.FileInfo{}, err
+	}
+	return plainInfo(info), nil
+}
+
+func (s *Store) List(user, date string) ([]logapi.FileInfo, error) {
+	files, err := s.Storage.List(user, date)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]logapi.FileInfo, len(files))
+	for _, file := range files {
+		if strings.HasSuffix(file.Name, suffix) {
+			file = plainInfo(file)
+		} else if _, ok := byName[file.Name]; ok {
+			continue // the encrypted copy wins
+		}
+		byName[file.Name] = file
+	}
+
+	results := make([]logapi.FileInfo, 0, len(byName))
+	for _, file := range byName {
+		results = append(results, file)
+	}
+	slices.SortFunc(results, func(a, b logapi.FileInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return results, nil
+}
+
+func (s *Store) Remove(user, date, name string) error {
+	err := s.Storage.Remove(user, date, name+suffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Storage.Remove(user, date, name)
+	}
+	return err
+}
+
+// Warmup passes through to the wrapped storage; archive indexes don't
+// depend on file contents
+func (s *Store) Warmup(workers int, progress func(done, total int)) error {
+	if warmer, ok := s.Storage.(logapi.Warmer); ok {
+		return warmer.Warmup(workers, progress)
+	}
+	return nil
+}
+
+// VerifyArchive passes through to the wrapped storage
+func (s *Store) VerifyArchive(user, date string) error {
+	if verifier, ok := s.Storage.(logapi.ArchiveVerifier); ok {
+		return verifier.VerifyArchive(user, date)
+	}
+	return errors.ErrUnsupported
+}
+
+// Repack passes through to the wrapped storage
+func (s *Store) Repack(user, date string, maxVolume int64) ([]string, error) {
+	if repacker, ok := s.Storage.(logapi.Repacker); ok {
+		return repacker.Repack(user, date, maxVolume)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// Tier passes through to the wrapped storage; tarballs move as they are
+func (s *Store) Tier(user, date string) (bool, error) {
+	if tierer, ok := s.Storage.(logapi.Tierer); ok {
+		return tierer.Tier(user, date)
+	}
+	return false, errors.ErrUnsupported
+}
+
+// RestoreStatus passes through to the wrapped storage
+func (s *Store) RestoreStatus(user, date string) (string, error) {
+	if restorer, ok := s.Storage.(logapi.Restorer); ok {
+		return restorer.RestoreStatus(user, date)
+	}
+	return "", errors.ErrUnsupported
+}
+
+// Restore passes through to the wrapped storage
+func (s *Store) Restore(user, date string) (string, error) {
+	if restorer, ok := s.Storage.(logapi.Restorer); ok {
+		return restorer.Restore(user, date)
+	}
+	return "", errors.ErrUnsupported
+}
+
+// ConvertArchives passes through to the wrapped storage
+func (s *Store) ConvertArchives(user string) ([]string, error) {
+	if converter, ok := s.Storage.(logapi.Converter); ok {
+		return converter.ConvertArchives(user)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// plainInfo strips .enc and reports the plaintext size
+func plainInfo(info logapi.FileInfo) logapi.FileInfo {
+	info.Name = strings.TrimSuffix(info.Name, suffix)
+	info.Size = aeadstream.PlainSize(info.Size)
+	return info
+}
+
+type plainReader struct {
+	io.Reader
+	io.Closer
+}
+
+// decrypter reads the header from src and returns a reader of the plaintext
+func (s *Store) decrypter(src io.ReadCloser) (io.ReadCloser, error) {
+	r, err := s.master.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	return plainReader{Reader: r, Closer: src}, nil
+}
@@ -0,0 +1,175 @@
+package csvpass
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// DefaultAlgorithm is what new passwords are hashed with
+	DefaultAlgorithm = "pbkdf2"
+
+	defaultIters      = 4096
+	defaultSize       = 16
+	defaultHash       = "SHA-256"
+	defaultBcryptCost = 12
+	defaultScryptN    = 32768
+	defaultScryptR    = 8
+	defaultScryptP    = 1
+	defaultScryptSize = 32
+	defaultArgonM     = 19456 // KiB
+	defaultArgonT     = 2
+	defaultArgonP     = 1
+	defaultArgonSize  = 32
+)
+
+// NewChallenge hashes a password with an algorithm: plain,
+// pbkdf2[,iters[,size[,hash]]], bcrypt[,cost], scrypt[,N[,r[,p[,size]]]]
+// or argon2id[,m[,t[,p[,size]]]]. The result has no roles.
+func NewChallenge(algorithm, password string) (Challenge, error) {
+	var challenge Challenge
+	algoParts := strings.Split(algorithm, ",")
+	switch algoParts[0] {
+	case "plain":
+		if len(algoParts) != 1 {
+			return Challenge{}, fmt.Errorf("invalid plain algorithm format: %q", algorithm)
+		}
+		challenge.Params = []string{"plain"}
+		challenge.Plain = password
+		h := sha256.Sum256([]byte(password))
+		challenge.Digest = h[:]
+	case "pbkdf2":
+		if len(algoParts) > 4 {
+			return Challenge{}, fmt.Errorf("invalid pbkdf2 algorithm format: %q", algorithm)
+		}
+		iters := defaultIters
+		if len(algoParts) > 1 {
+			var err error
+			iters, err = strconv.Atoi(algoParts[1])
+			if err != nil || iters <= 0 {
+				return Challenge{}, fmt.Errorf("invalid iterations %q in %q", algoParts[1], algorithm)
+			}
+		}
+		size := defaultSize
+		if len(algoParts) > 2 {
+			var err error
+			size, err = strconv.Atoi(algoParts[2])
+			if err != nil || size < 8 || size > 32 {
+				return Challenge{}, fmt.Errorf("invalid size %q in %q", algoParts[2], algorithm)
+			}
+		}
+		hashName := defaultHash
+		if len(algoParts) > 3 {
+			if !slices.Contains([]string{"SHA-256", "SHA-1"}, algoParts[3]) {
+				return Challenge{}, fmt.Errorf("invalid hash %q in %q", algoParts[3], algorithm)
+			}
+			hashName = algoParts[3]
+		}
+		challenge.Params = []string{"pbkdf2", strconv.Itoa(iters), strconv.Itoa(size), hashName}
+		saltBytes := make([]byte, 16)
+		_, _ = rand.Read(saltBytes)
+		challenge.Salt = saltBytes
+		var hasher func() hash.Hash
+		switch hashName {
+		case "SHA-1":
+			hasher = sha1.New
+		case "SHA-256":
+			hasher = sha256.New
+		}
+		challenge.Digest = pbkdf2.Key([]byte(password), saltBytes, iters, size, hasher)
+	case "bcrypt":
+		if len(algoParts) > 2 {
+			return Challenge{}, fmt.Errorf("invalid bcrypt algorithm format: %q", algorithm)
+		}
+		cost := defaultBcryptCost
+		if len(algoParts) > 1 {
+			var err error
+			cost, err = strconv.Atoi(algoParts[1])
+			if err != nil || cost < 4 || cost > 31 {
+				return Challenge{}, fmt.Errorf("invalid bcrypt cost %q in %q", algoParts[1], algorithm)
+			}
+		}
+		challenge.Params = []string{"bcrypt"}
+		digest, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		if err != nil {
+			return Challenge{}, fmt.Errorf("generating bcrypt hash: %w", err)
+		}
+		challenge.Digest = digest
+	case "scrypt":
+		if len(algoParts) > 5 {
+			return Challenge{}, fmt.Errorf("invalid scrypt algorithm format: %q", algorithm)
+		}
+		params := []string{"scrypt", strconv.Itoa(defaultScryptN), strconv.Itoa(defaultScryptR), strconv.Itoa(defaultScryptP), strconv.Itoa(defaultScryptSize)}
+		copy(params[1:], algoParts[1:])
+		if err := ValidateScrypt(params[1], params[2], params[3], params[4]); err != nil {
+			return Challenge{}, fmt.Errorf("%w in %q", err, algorithm)
+		}
+		challenge.Params = params
+		saltBytes := make([]byte, 16)
+		_, _ = rand.Read(saltBytes)
+		challenge.Salt = saltBytes
+		n, _ := strconv.Atoi(params[1])
+		r, _ := strconv.Atoi(params[2])
+		p, _ := strconv.Atoi(params[3])
+		size, _ := strconv.Atoi(params[4])
+		digest, err := scrypt.Key([]byte(password), saltBytes, n, r, p, size)
+		if err != nil {
+			return Challenge{}, fmt.Errorf("generating scrypt hash: %w", err)
+		}
+		challenge.Digest = digest
+	case "argon2id":
+		if len(algoParts) > 5 {
+			return Challenge{}, fmt.Errorf("invalid argon2id algorithm format: %q", algorithm)
+		}
+		params := []string{"argon2id", strconv.Itoa(defaultArgonM), strconv.Itoa(defaultArgonT), strconv.Itoa(defaultArgonP), strconv.Itoa(defaultArgonSize)}
+		copy(params[1:], algoParts[1:])
+		if err := ValidateArgon2id(params[1], params[2], params[3], params[4]); err != nil {
+			return Challenge{}, fmt.Errorf("%w in %q", err, algorithm)
+		}
+		challenge.Params = params
+		saltBytes := make([]byte, 16)
+		_, _ = rand.Read(saltBytes)
+		challenge.Salt = saltBytes
+		challenge.Digest = challenge.argon2id(password)
+	default:
+		return Challenge{}, fmt.Errorf("invalid algorithm %q", algoParts[0])
+	}
+	return challenge, nil
+}
+
+// Algorithm returns the algorithm string that NewChallenge takes to hash a
+// new password the same way as c
+func (c Challenge) Algorithm() string {
+	if c.Params[0] == "bcrypt" {
+		if cost, err := bcrypt.Cost(c.Digest); err == nil {
+			return "bcrypt," + strconv.Itoa(cost)
+		}
+	}
+	return strings.Join(c.Params, ",")
+}
+
+// WithPassword hashes a new password with an algorithm, as NewChallenge
+// does, keeping c's roles, disabled state, extra columns, comments and
+// PHC format
+func (c Challenge) WithPassword(algorithm, password string) (Challenge, error) {
+	updated, err := NewChallenge(algorithm, password)
+	if err != nil {
+		return Challenge{}, err
+	}
+	updated.Roles = c.Roles
+	updated.Disabled = c.Disabled
+	updated.Extra = c.Extra
+	updated.Comments = c.Comments
+	updated.PHC = c.PHC
+	return updated, nil
+}
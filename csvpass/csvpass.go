@@ -1,11 +1,13 @@
 package csvpass
 
 import (
-	"bytes"
+	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
@@ -13,9 +15,12 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
 )
 
 type Username = string
@@ -26,8 +31,22 @@ type Challenge struct {
 	Params []string
 	Salt   []byte
 	Digest []byte
+	Roles  []string // empty means DefaultRoles
+	// Disabled users fail Verify whatever their password, but keep their
+	// row, so their username isn't reused while their logs remain
+	Disabled bool
+	// Extra holds the row's values for columns csvpass doesn't use, by
+	// column name, so other tools can annotate users (owner, team, notes)
+	Extra map[string]string
+	// Comments are the # lines above the row
+	Comments []string
+	// PHC writes the row as AlgorithmPHC, with its PHCString as the digest
+	PHC bool
 }
 
+// columns are the columns csvpass uses, in the order it writes them
+var columns = []string{"id", "algo", "salt", "digest", "roles", "disabled"}
+
 func (c Challenge) ToRecord(id string) []string {
 	var paramList, salt, digest string
 
@@ -35,28 +54,67 @@ func (c Challenge) ToRecord(id string) []string {
 	switch c.Params[0] {
 	case "plain":
 		digest = c.Plain
-	case "pbkdf2":
+	case "pbkdf2", "scrypt", "argon2id":
 		salt = base64.RawURLEncoding.EncodeToString(c.Salt)
 		digest = base64.RawURLEncoding.EncodeToString(c.Digest)
 	case "bcrypt":
 		digest = string(c.Digest)
 	}
+	if phc, err := c.PHCString(); c.PHC && err == nil {
+		paramList, salt, digest = AlgorithmPHC, "", phc
+	}
+
+	var disabled string
+	if c.Disabled {
+		disabled = "true"
+	}
 
-	return []string{id, paramList, salt, digest}
+	return []string{id, paramList, salt, digest, strings.Join(c.Roles, ","), disabled}
 }
 
 // Auth holds user credentials
 type Auth struct {
 	Credentials map[Username]Challenge
+	// Columns are the file's columns that csvpass doesn't use, in order
+	Columns []string
+	// Comments are the # lines above the header row, and TrailingComments
+	// those below the last row
+	Comments         []string
+	TrailingComments []string
 }
 
-// Load reads credentials from the given path
+// Load reads credentials from the given path. Columns other than id, algo,
+// salt, digest, roles and disabled, and # comment lines, are kept to be
+// written back by Save.
 func Load(f *os.File) (*Auth, error) {
 	auth := &Auth{Credentials: make(map[Username]Challenge)}
 
-	csvr := csv.NewReader(f)
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	// comments returns the # lines numbered from up to, but not including, to
+	comments := func(from, to int) []string {
+		var found []string
+		for _, line := range lines[min(from, len(lines))-1 : min(to-1, len(lines))] {
+			if line = strings.TrimRight(line, "\r"); strings.HasPrefix(line, "#") {
+				found = append(found, line)
+			}
+		}
+		return found
+	}
+
+	csvr := csv.NewReader(strings.NewReader(string(data)))
 	csvr.Comma = '\t'
-	_, _ = csvr.Read() // strip header row
+	csvr.Comment = '#'
+	// the roles and disabled columns are optional
+	csvr.FieldsPerRecord = -1
+
+	// names are the header's column names. The first four are positional,
+	// and roles and disabled may be left out of the header.
+	var names []string
+	nextLine := 1
 	for {
 		record, err := csvr.Read()
 		if err == io.EOF {
@@ -65,8 +123,24 @@ func Load(f *os.File) (*Auth, error) {
 		if err != nil {
 			return nil, err
 		}
+		startLine, _ := csvr.FieldPos(0)
+		leading := comments(nextLine, startLine)
+		nextLine, _ = csvr.FieldPos(len(record) - 1)
+		nextLine++
 
-		if len(record) == 0 {
+		if names == nil {
+			auth.Comments = leading
+			names = slices.Clone(columns)
+			for i, name := range record[min(4, len(record)):] {
+				if i+4 < len(names) {
+					names[i+4] = name
+				} else {
+					names = append(names, name)
+				}
+				if !slices.Contains(columns, name) {
+					auth.Columns = append(auth.Columns, name)
+				}
+			}
 			continue
 		}
 
@@ -76,79 +150,290 @@ func Load(f *os.File) (*Auth, error) {
 			}
 		}
 
-		if len(record) != 4 {
+		if len(record) < 4 || len(record) > len(names) {
 			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
 		}
 
-		username, paramList, salt64, secret := record[0], record[1], record[2], record[3]
+		known := make([]string, len(columns))
+		var extra map[string]string
+		for i, value := range record {
+			if j := slices.Index(columns, names[i]); j >= 0 {
+				known[j] = value
+				continue
+			}
+			if extra == nil {
+				extra = make(map[string]string)
+			}
+			extra[names[i]] = value
+		}
 
-		var challenge Challenge
-		challenge.Params = strings.Split(paramList, ",")
-		if len(challenge.Params) == 0 {
-			fmt.Fprintf(os.Stderr, "no algorithm parameters for %q\n", username)
+		username, challenge, err := ParseRecord(known)
+		if err != nil {
+			return nil, err
 		}
+		challenge.Extra = extra
+		challenge.Comments = leading
 
-		switch challenge.Params[0] {
-		case "plain":
-			if len(challenge.Params) > 1 {
-				return nil, fmt.Errorf("invalid plain parameters %#v", challenge.Params)
-			}
+		auth.Credentials[username] = challenge
+	}
+	auth.TrailingComments = comments(nextLine, len(lines)+1)
 
-			challenge.Plain = secret
-			h := sha256.Sum256([]byte(secret))
-			challenge.Digest = h[:]
-		case "pbkdf2":
-			var err error
+	return auth, nil
+}
 
-			challenge.Salt, err = base64.RawURLEncoding.DecodeString(salt64)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not decode salt %q for %q\n", salt64, username)
-			}
+// ParseRecord decodes and validates an id, algo, salt, digest[, roles
+// [, disabled]] row. With algo AlgorithmPHC, the digest is a PHC string.
+func ParseRecord(record []string) (Username, Challenge, error) {
+	if len(record) < 4 || len(record) > 6 {
+		return "", Challenge{}, fmt.Errorf("invalid record: %#v (%d)", record, len(record))
+	}
 
-			challenge.Digest, err = base64.RawURLEncoding.DecodeString(secret)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not decode digest %q for %q\n", secret, username)
-			}
+	username, paramList, salt64, secret := record[0], record[1], record[2], record[3]
 
-			iters, err := strconv.Atoi(challenge.Params[1])
-			if err != nil {
-				return nil, err
-			}
-			if iters <= 0 {
-				return nil, fmt.Errorf("invalid iterations %s", challenge.Params[1])
-			}
+	var challenge Challenge
+	challenge.Params = strings.Split(paramList, ",")
+	if len(challenge.Params) == 0 {
+		fmt.Fprintf(os.Stderr, "no algorithm parameters for %q\n", username)
+	}
 
-			size, err := strconv.Atoi(challenge.Params[2])
-			if err != nil {
-				return nil, err
-			}
-			if size < 8 || size > 32 {
-				return nil, fmt.Errorf("invalid size %s", challenge.Params[2])
-			}
+	switch challenge.Params[0] {
+	case AlgorithmPHC:
+		if len(challenge.Params) > 1 {
+			return "", Challenge{}, fmt.Errorf("invalid phc parameters %#v", challenge.Params)
+		}
 
-			if !slices.Contains([]string{"SHA-256", "SHA-1"}, challenge.Params[3]) {
-				return nil, fmt.Errorf("invalid hash %s", challenge.Params[3])
-			}
-		case "bcrypt":
-			if len(challenge.Params) > 1 {
-				return nil, fmt.Errorf("invalid bcrypt parameters %#v", challenge.Params)
-			}
+		var err error
+		challenge, err = ParsePHC(secret)
+		if err != nil {
+			return "", Challenge{}, fmt.Errorf("%w for %q", err, username)
+		}
+		challenge.PHC = true
+	case "plain":
+		if len(challenge.Params) > 1 {
+			return "", Challenge{}, fmt.Errorf("invalid plain parameters %#v", challenge.Params)
+		}
 
-			challenge.Digest = []byte(secret)
-		default:
-			return nil, fmt.Errorf("invalid algorithm %s", challenge.Params[0])
+		challenge.Plain = secret
+		h := sha256.Sum256([]byte(secret))
+		challenge.Digest = h[:]
+	case "pbkdf2":
+		var err error
+
+		challenge.Salt, err = base64.RawURLEncoding.DecodeString(salt64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode salt %q for %q\n", salt64, username)
 		}
 
-		auth.Credentials[username] = challenge
+		challenge.Digest, err = base64.RawURLEncoding.DecodeString(secret)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode digest %q for %q\n", secret, username)
+		}
+
+		iters, err := strconv.Atoi(challenge.Params[1])
+		if err != nil {
+			return "", Challenge{}, err
+		}
+		if iters <= 0 {
+			return "", Challenge{}, fmt.Errorf("invalid iterations %s", challenge.Params[1])
+		}
+
+		size, err := strconv.Atoi(challenge.Params[2])
+		if err != nil {
+			return "", Challenge{}, err
+		}
+		if size < 8 || size > 32 {
+			return "", Challenge{}, fmt.Errorf("invalid size %s", challenge.Params[2])
+		}
+
+		if !slices.Contains([]string{"SHA-256", "SHA-1"}, challenge.Params[3]) {
+			return "", Challenge{}, fmt.Errorf("invalid hash %s", challenge.Params[3])
+		}
+	case "scrypt":
+		if len(challenge.Params) != 5 {
+			return "", Challenge{}, fmt.Errorf("invalid scrypt parameters %#v", challenge.Params)
+		}
+
+		var err error
+
+		challenge.Salt, err = base64.RawURLEncoding.DecodeString(salt64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode salt %q for %q\n", salt64, username)
+		}
+
+		challenge.Digest, err = base64.RawURLEncoding.DecodeString(secret)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode digest %q for %q\n", secret, username)
+		}
+
+		if err := ValidateScrypt(challenge.Params[1], challenge.Params[2], challenge.Params[3], challenge.Params[4]); err != nil {
+			return "", Challenge{}, err
+		}
+	case "argon2id":
+		if len(challenge.Params) != 5 {
+			return "", Challenge{}, fmt.Errorf("invalid argon2id parameters %#v", challenge.Params)
+		}
+
+		var err error
+
+		challenge.Salt, err = base64.RawURLEncoding.DecodeString(salt64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode salt %q for %q\n", salt64, username)
+		}
+
+		challenge.Digest, err = base64.RawURLEncoding.DecodeString(secret)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not decode digest %q for %q\n", secret, username)
+		}
+
+		if err := ValidateArgon2id(challenge.Params[1], challenge.Params[2], challenge.Params[3], challenge.Params[4]); err != nil {
+			return "", Challenge{}, err
+		}
+	case "bcrypt":
+		if len(challenge.Params) > 1 {
+			return "", Challenge{}, fmt.Errorf("invalid bcrypt parameters %#v", challenge.Params)
+		}
+
+		challenge.Digest = []byte(secret)
+	default:
+		return "", Challenge{}, fmt.Errorf("invalid algorithm %s", challenge.Params[0])
 	}
 
-	return auth, nil
+	if len(record) >= 5 && len(record[4]) > 0 {
+		roles, err := ParseRoles(record[4])
+		if err != nil {
+			return "", Challenge{}, fmt.Errorf("%w for %q", err, username)
+		}
+		challenge.Roles = roles
+	}
+
+	if len(record) == 6 && len(record[5]) > 0 {
+		disabled, err := strconv.ParseBool(record[5])
+		if err != nil {
+			return "", Challenge{}, fmt.Errorf("invalid disabled %q for %q", record[5], username)
+		}
+		challenge.Disabled = disabled
+	}
+
+	return username, challenge, nil
+}
+
+// ValidateScrypt checks scrypt N (cost), r (block size), p (parallelism)
+// and key size parameters
+func ValidateScrypt(nParam, rParam, pParam, sizeParam string) error {
+	n, err := strconv.Atoi(nParam)
+	if err != nil {
+		return err
+	}
+	if n <= 1 || n&(n-1) != 0 {
+		return fmt.Errorf("invalid scrypt N %s: must be a power of 2 greater than 1", nParam)
+	}
+
+	r, err := strconv.Atoi(rParam)
+	if err != nil {
+		return err
+	}
+	if r <= 0 {
+		return fmt.Errorf("invalid scrypt r %s", rParam)
+	}
+
+	p, err := strconv.Atoi(pParam)
+	if err != nil {
+		return err
+	}
+	if p <= 0 || uint64(r)*uint64(p) >= 1<<30 {
+		return fmt.Errorf("invalid scrypt p %s", pParam)
+	}
+
+	size, err := strconv.Atoi(sizeParam)
+	if err != nil {
+		return err
+	}
+	if size < 8 || size > 64 {
+		return fmt.Errorf("invalid size %s", sizeParam)
+	}
+
+	return nil
+}
+
+// ValidateArgon2id checks argon2id m (memory in KiB), t (passes), p
+// (parallelism) and key size parameters
+func ValidateArgon2id(mParam, tParam, pParam, sizeParam string) error {
+	p, err := strconv.Atoi(pParam)
+	if err != nil {
+		return err
+	}
+	if p < 1 || p > 255 {
+		return fmt.Errorf("invalid argon2id p %s", pParam)
+	}
+
+	m, err := strconv.ParseUint(mParam, 10, 32)
+	if err != nil {
+		return err
+	}
+	if m < 8*uint64(p) {
+		return fmt.Errorf("invalid argon2id m %s: must be at least 8 KiB per thread", mParam)
+	}
+
+	t, err := strconv.ParseUint(tParam, 10, 32)
+	if err != nil {
+		return err
+	}
+	if t < 1 {
+		return fmt.Errorf("invalid argon2id t %s", tParam)
+	}
+
+	size, err := strconv.Atoi(sizeParam)
+	if err != nil {
+		return err
+	}
+	if size < 8 || size > 64 {
+		return fmt.Errorf("invalid size %s", sizeParam)
+	}
+
+	return nil
 }
 
+// argon2id hashes a password with the challenge's argon2id parameters and
+// salt, which are checked on load
+func (challenge Challenge) argon2id(password string) []byte {
+	m, _ := strconv.ParseUint(challenge.Params[1], 10, 32)
+	t, _ := strconv.ParseUint(challenge.Params[2], 10, 32)
+	p, _ := strconv.Atoi(challenge.Params[3])
+	size, _ := strconv.Atoi(challenge.Params[4])
+	return argon2.IDKey([]byte(password), challenge.Salt, uint32(t), uint32(m), uint8(p), uint32(size))
+}
+
+// dummyChallenge is checked for unknown users, so they take as long to
+// fail as users whose passwords were hashed with DefaultAlgorithm, and
+// timing doesn't tell which usernames exist
+var dummyChallenge = sync.OnceValue(func() Challenge {
+	challenge, _ := NewChallenge(DefaultAlgorithm, "")
+	return challenge
+})
+
 // Verify checks Basic Auth credentials
 func (a Auth) Verify(username, password string) bool {
 	challenge, ok := a.Credentials[username]
 	if !ok {
+		_ = dummyChallenge().Verify(password)
+		return false
+	}
+
+	return challenge.Verify(password)
+}
+
+// Disabled reports whether a user's account is disabled
+func (a Auth) Disabled(username string) bool {
+	return a.Credentials[username].Disabled
+}
+
+// Verify checks a password against the challenge. It always fails for a
+// disabled user, after hashing the password all the same.
+func (challenge Challenge) Verify(password string) bool {
+	if challenge.Disabled {
+		enabled := challenge
+		enabled.Disabled = false
+		_ = enabled.Verify(password)
 		return false
 	}
 
@@ -172,10 +457,45 @@ func (a Auth) Verify(username, password string) bool {
 		}
 		h := pbkdf2.Key([]byte(password), challenge.Salt, iters, size, hasher)
 		digest = h
+	case "scrypt":
+		// these are checked on load
+		n, _ := strconv.Atoi(challenge.Params[1])
+		r, _ := strconv.Atoi(challenge.Params[2])
+		p, _ := strconv.Atoi(challenge.Params[3])
+		size, _ := strconv.Atoi(challenge.Params[4])
+		h, err := scrypt.Key([]byte(password), challenge.Salt, n, r, p, size)
+		if err != nil {
+			return false
+		}
+		digest = h
+	case "argon2id":
+		digest = challenge.argon2id(password)
 	case "bcrypt":
 		err := bcrypt.CompareHashAndPassword(challenge.Digest, []byte(password))
 		return err == nil
 	}
 
-	return bytes.Equal(challenge.Digest, digest)
+	return subtle.ConstantTimeCompare(challenge.Digest, digest) == 1
+}
+
+// DigestHA1 returns MD5(username:realm:password) for HTTP Digest auth,
+// which is only possible for "plain" credentials
+func (a Auth) DigestHA1(username, realm string) (string, bool) {
+	challenge, ok := a.Credentials[username]
+	if !ok {
+		return "", false
+	}
+
+	return challenge.DigestHA1(username, realm)
+}
+
+// DigestHA1 returns MD5(username:realm:password) for "plain" challenges
+// of users who aren't disabled
+func (challenge Challenge) DigestHA1(username, realm string) (string, bool) {
+	if challenge.Params[0] != "plain" || challenge.Disabled {
+		return "", false
+	}
+
+	h := md5.Sum([]byte(username + ":" + realm + ":" + challenge.Plain))
+	return hex.EncodeToString(h[:]), true
 }
@@ -0,0 +1,209 @@
+package csvpass
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cryptBase64 is the alphabet of crypt(3)'s base64
+const cryptBase64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// isBcryptHash reports whether s looks like a $2a$, $2b$ or $2y$ bcrypt hash
+func isBcryptHash(s string) bool {
+	return len(s) == 60 && strings.HasPrefix(s, "$2") && s[3] == '$'
+}
+
+// LoadHtpasswd reads bcrypt entries from an Apache htpasswd file.
+// Entries in other formats (apr1, {SHA}, crypt) are skipped with a warning.
+func LoadHtpasswd(r io.Reader) (*Auth, error) {
+	auth := &Auth{Credentials: make(map[Username]Challenge)}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || len(username) == 0 {
+			return nil, fmt.Errorf("invalid htpasswd line %d: %q", lineNo, line)
+		}
+
+		if !isBcryptHash(hash) {
+			fmt.Fprintf(os.Stderr, "skipping non-bcrypt htpasswd entry for %q\n", username)
+			continue
+		}
+
+		auth.Credentials[username] = Challenge{
+			Params: []string{"bcrypt"},
+			Digest: []byte(hash),
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// SaveHtpasswd writes the bcrypt credentials in Apache htpasswd format.
+// Other algorithms can't be represented and are skipped with a warning.
+func (a Auth) SaveHtpasswd(w io.Writer) error {
+	keys := slices.Sorted(maps.Keys(a.Credentials))
+	for _, username := range keys {
+		challenge := a.Credentials[username]
+		if challenge.Params[0] != "bcrypt" {
+			fmt.Fprintf(os.Stderr, "skipping %s entry for %q\n", challenge.Params[0], username)
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s:%s\n", username, challenge.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Htpasswd is an Apache htpasswd file, checked as it is: bcrypt, apr1 (and
+// $1$ MD5-crypt) and {SHA} entries verify; others never do.
+type Htpasswd struct {
+	Hashes map[Username]string
+}
+
+// LoadHtpasswdFile reads every entry of an htpasswd file, warning about
+// those in formats that can't be verified
+func LoadHtpasswdFile(f *os.File) (*Htpasswd, error) {
+	h := &Htpasswd{Hashes: make(map[Username]string)}
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || len(username) == 0 {
+			return nil, fmt.Errorf("invalid htpasswd line %d: %q", lineNo, line)
+		}
+		if !isHtpasswdHash(hash) {
+			fmt.Fprintf(os.Stderr, "%s: %q's entry isn't bcrypt, apr1 or {SHA}, so it can't log in\n", f.Name(), username)
+		}
+		h.Hashes[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Verify checks Basic Auth credentials
+func (h *Htpasswd) Verify(username, password string) bool {
+	hash, ok := h.Hashes[username]
+	if !ok {
+		_ = dummyChallenge().Verify(password)
+		return false
+	}
+	return verifyHtpasswd(hash, password)
+}
+
+// isHtpasswdHash reports whether an htpasswd hash is in a format
+// verifyHtpasswd knows
+func isHtpasswdHash(hash string) bool {
+	return isBcryptHash(hash) || strings.HasPrefix(hash, "$apr1$") || strings.HasPrefix(hash, "$1$") || strings.HasPrefix(hash, "{SHA}")
+}
+
+// verifyHtpasswd checks a password against an htpasswd hash
+func verifyHtpasswd(hash, password string) bool {
+	switch {
+	case isBcryptHash(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		magic := hash[:strings.Index(hash[1:], "$")+2]
+		salt, _, _ := strings.Cut(hash[len(magic):], "$")
+		return subtle.ConstantTimeCompare([]byte(md5Crypt(password, salt, magic)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[len("{SHA}"):])) == 1
+	default:
+		return false
+	}
+}
+
+// md5Crypt is the MD5-based crypt(3) of FreeBSD ($1$), which Apache uses
+// with its own magic ($apr1$)
+func md5Crypt(password, salt, magic string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= md5.Size {
+		d.Write(altSum[:min(i, md5.Size)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	sum := d.Sum(nil)
+
+	for i := range 1000 {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(sum)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(sum)
+		} else {
+			d.Write(pw)
+		}
+		sum = d.Sum(nil)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(magic + salt + "$")
+	encode := func(v uint32, n int) {
+		for range n {
+			sb.WriteByte(cryptBase64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[i[0]])<<16|uint32(sum[i[1]])<<8|uint32(sum[i[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return sb.String()
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package csvpass
+
+import "sync"
+
+var (
+	locksMu sync.Mutex
+	locks   = map[string]*sync.Mutex{}
+)
+
+// lockFile serializes edits within this process only, as this OS has no
+// flock
+func lockFile(lockPath string) (func(), error) {
+	locksMu.Lock()
+	mu, ok := locks[lockPath]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[lockPath] = mu
+	}
+	locksMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock, nil
+}
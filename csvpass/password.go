@@ -0,0 +1,155 @@
+package csvpass
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// Character sets for Generator.Charset
+const (
+	CharsetBase64URL = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	CharsetAlnum     = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	CharsetPrintable = CharsetAlnum + "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+)
+
+const (
+	defaultPasswordLength = 16
+	defaultPasswordWords  = 6
+	// maxScoredLength bounds the part of a password zxcvbn looks at, as it
+	// slows down a lot on long inputs
+	maxScoredLength = 64
+)
+
+// ErrWeakPassword is returned for passwords a PasswordPolicy rejects
+var ErrWeakPassword = errors.New("password too weak")
+
+// Generator makes random passwords, of characters from a charset or, with
+// a wordlist, of words, diceware style. The zero value makes 16 characters
+// of CharsetBase64URL.
+type Generator struct {
+	Length    int      // characters, or words with Words (default 16, or 6 words)
+	Charset   string   // characters to choose from (default CharsetBase64URL)
+	Group     int      // with a charset, put Separator between every Group characters
+	Words     []string // choose words from these instead of characters
+	Separator string   // between groups or words (default "-")
+}
+
+// Generate returns a new random password
+func (g Generator) Generate() (string, error) {
+	separator := g.Separator
+	if separator == "" {
+		separator = "-"
+	}
+
+	if len(g.Words) > 0 {
+		length := g.Length
+		if length <= 0 {
+			length = defaultPasswordWords
+		}
+		words := make([]string, length)
+		for i := range words {
+			n, err := randIndex(len(g.Words))
+			if err != nil {
+				return "", err
+			}
+			words[i] = g.Words[n]
+		}
+		return strings.Join(words, separator), nil
+	}
+
+	length := g.Length
+	if length <= 0 {
+		length = defaultPasswordLength
+	}
+	charset := []rune(g.Charset)
+	if len(charset) == 0 {
+		charset = []rune(CharsetBase64URL)
+	}
+	if len(charset) < 2 {
+		return "", fmt.Errorf("charset %q needs at least 2 characters", g.Charset)
+	}
+
+	var sb strings.Builder
+	for i := range length {
+		if g.Group > 0 && i > 0 && i%g.Group == 0 {
+			sb.WriteString(separator)
+		}
+		n, err := randIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(charset[n])
+	}
+	return sb.String(), nil
+}
+
+// randIndex returns a uniformly random int in [0, n)
+func randIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// LoadWordlist reads words for Generator.Words, one per line. Diceware
+// lists, with the dice rolls before each word, work as they are. Blank
+// lines and # comments are skipped.
+func LoadWordlist(r io.Reader) ([]string, error) {
+	var words []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		word := fields[len(fields)-1]
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("wordlist needs at least 2 words, has %d", len(words))
+	}
+	return words, nil
+}
+
+// PasswordPolicy is the least a new password has to be
+type PasswordPolicy struct {
+	MinLength int // characters
+	MinScore  int // zxcvbn score, from 0 (too guessable) to 4 (very unguessable)
+}
+
+// Check returns an ErrWeakPassword saying why a password falls short of
+// the policy, if it does. userInputs, such as the username, count against
+// passwords that contain them.
+func (p PasswordPolicy) Check(password string, userInputs ...string) error {
+	if length := utf8.RuneCountInString(password); length < p.MinLength {
+		return fmt.Errorf("%w: %d characters, needs at least %d", ErrWeakPassword, length, p.MinLength)
+	}
+	if p.MinScore <= 0 {
+		return nil
+	}
+
+	scored := password
+	if runes := []rune(password); len(runes) > maxScoredLength {
+		scored = string(runes[:maxScoredLength])
+	}
+	if score := zxcvbn.PasswordStrength(scored, userInputs).Score; score < p.MinScore {
+		return fmt.Errorf("%w: too easy to guess (scores %d of 4, needs %d)", ErrWeakPassword, score, p.MinScore)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package csvpass
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AlgorithmPHC is the algo column of rows whose digest column holds a PHC
+// string (or bcrypt's modular crypt string) instead of a salt and digest
+const AlgorithmPHC = "phc"
+
+// ab64 is passlib's "adapted base64", used by its $pbkdf2...$ hashes
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// pbkdf2PHCHashes maps $pbkdf2-<hash>$ identifiers to pbkdf2 hash names,
+// and the digest size passlib writes for each
+var pbkdf2PHCHashes = map[string]struct {
+	hash string
+	size int
+}{
+	"pbkdf2":        {"SHA-1", 20},
+	"pbkdf2-sha1":   {"SHA-1", 20},
+	"pbkdf2-sha256": {"SHA-256", 32},
+}
+
+// ParsePHC decodes a hash from another system, in PHC string format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash, $scrypt$ln=...,r=...,p=...$salt$hash,
+// $pbkdf2-sha256$i=...,l=...$salt$hash, or passlib's $pbkdf2-sha256$rounds$salt$hash)
+// or as a $2a$, $2b$ or $2y$ bcrypt hash. The challenge is written back
+// the same way if PHC is set.
+func ParsePHC(s string) (Challenge, error) {
+	if isBcryptHash(s) {
+		if _, err := bcrypt.Cost([]byte(s)); err != nil {
+			return Challenge{}, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return Challenge{Params: []string{"bcrypt"}, Digest: []byte(s)}, nil
+	}
+
+	fields := strings.Split(s, "$")
+	if len(fields) < 5 || fields[0] != "" {
+		return Challenge{}, fmt.Errorf("invalid PHC string %q", s)
+	}
+	id, salt64, hash64 := fields[1], fields[len(fields)-2], fields[len(fields)-1]
+	params := phcParams(fields[len(fields)-3])
+
+	var record []string
+	switch {
+	case id == "argon2id":
+		if len(fields) != 6 || fields[2] != "v=19" {
+			return Challenge{}, fmt.Errorf("unsupported argon2id version in %q", s)
+		}
+		salt, hash, err := decodePHC(base64.RawStdEncoding, salt64, hash64)
+		if err != nil {
+			return Challenge{}, err
+		}
+		record = []string{"argon2id", params["m"], params["t"], params["p"], strconv.Itoa(len(hash)), b64url(salt), b64url(hash)}
+	case id == "scrypt":
+		ln, err := strconv.Atoi(params["ln"])
+		if err != nil || ln < 1 || ln > 62 {
+			return Challenge{}, fmt.Errorf("invalid scrypt ln %q", params["ln"])
+		}
+		salt, hash, err := decodePHC(base64.RawStdEncoding, salt64, hash64)
+		if err != nil {
+			return Challenge{}, err
+		}
+		record = []string{"scrypt", strconv.Itoa(1 << ln), params["r"], params["p"], strconv.Itoa(len(hash)), b64url(salt), b64url(hash)}
+	case pbkdf2PHCHashes[id].hash != "":
+		iters, encoding := params["i"], base64.RawStdEncoding
+		if _, err := strconv.Atoi(fields[2]); err == nil {
+			// passlib's $pbkdf2-sha256$rounds$salt$hash
+			iters, encoding = fields[2], ab64
+		}
+		salt, hash, err := decodePHC(encoding, salt64, hash64)
+		if err != nil {
+			return Challenge{}, err
+		}
+		record = []string{"pbkdf2", iters, strconv.Itoa(len(hash)), pbkdf2PHCHashes[id].hash, b64url(salt), b64url(hash)}
+	default:
+		return Challenge{}, fmt.Errorf("unsupported PHC algorithm %q", id)
+	}
+
+	n := len(record)
+	_, challenge, err := ParseRecord([]string{"", strings.Join(record[:n-2], ","), record[n-2], record[n-1]})
+	if err != nil {
+		return Challenge{}, fmt.Errorf("invalid PHC string %q: %w", s, err)
+	}
+	return challenge, nil
+}
+
+// phcParams splits a PHC string's a=1,b=2 parameters
+func phcParams(s string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(s, ",") {
+		if name, value, ok := strings.Cut(param, "="); ok {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// decodePHC decodes a PHC string's salt and hash
+func decodePHC(encoding *base64.Encoding, salt64, hash64 string) ([]byte, []byte, error) {
+	salt, err := encoding.DecodeString(salt64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PHC salt %q: %w", salt64, err)
+	}
+	hash, err := encoding.DecodeString(hash64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PHC hash %q: %w", hash64, err)
+	}
+	return salt, hash, nil
+}
+
+// b64url encodes a salt or digest the way the TSV has them
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PHCString encodes the challenge in PHC string format, or as its bcrypt
+// hash, for other systems to read. pbkdf2 is written the way passlib does
+// when it has passlib's digest size. plain passwords can't be encoded.
+func (c Challenge) PHCString() (string, error) {
+	salt := base64.RawStdEncoding.EncodeToString(c.Salt)
+	hash := base64.RawStdEncoding.EncodeToString(c.Digest)
+	switch c.Params[0] {
+	case "bcrypt":
+		return string(c.Digest), nil
+	case "argon2id":
+		return fmt.Sprintf("$argon2id$v=19$m=%s,t=%s,p=%s$%s$%s", c.Params[1], c.Params[2], c.Params[3], salt, hash), nil
+	case "scrypt":
+		// N is checked to be a power of 2 on load
+		n, _ := strconv.Atoi(c.Params[1])
+		ln := 0
+		for ; n > 1; n >>= 1 {
+			ln++
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%s,p=%s$%s$%s", ln, c.Params[2], c.Params[3], salt, hash), nil
+	case "pbkdf2":
+		id := "pbkdf2-sha256"
+		if c.Params[3] == "SHA-1" {
+			id = "pbkdf2-sha1"
+		}
+		if len(c.Digest) == pbkdf2PHCHashes[id].size {
+			return fmt.Sprintf("$%s$%s$%s$%s", id, c.Params[1], ab64.EncodeToString(c.Salt), ab64.EncodeToString(c.Digest)), nil
+		}
+		return fmt.Sprintf("$%s$i=%s,l=%d$%s$%s", id, c.Params[1], len(c.Digest), salt, hash), nil
+	default:
+		return "", fmt.Errorf("%s passwords have no PHC string", c.Params[0])
+	}
+}
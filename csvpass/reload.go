@@ -0,0 +1,177 @@
+package csvpass
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadable holds the parsed contents of a file that can be re-read while
+// in use. Reloads swap the whole value atomically, so a request never sees
+// a half-loaded file, and a file that fails to load keeps the old value.
+type reloadable[T any] struct {
+	path    string
+	load    func(*os.File) (*T, error)
+	current atomic.Pointer[T]
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// Reload re-reads the file
+func (rl *reloadable[T]) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	f, err := os.Open(rl.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	v, err := rl.load(f)
+	if err != nil {
+		return err
+	}
+
+	rl.current.Store(v)
+	rl.modTime = info.ModTime()
+	rl.size = info.Size()
+	return nil
+}
+
+// Changed reports whether the file differs from the last load
+func (rl *reloadable[T]) Changed() bool {
+	info, err := os.Stat(rl.path)
+	if err != nil {
+		return false
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return !info.ModTime().Equal(rl.modTime) || info.Size() != rl.size
+}
+
+// Path is the file being served
+func (rl *reloadable[T]) Path() string {
+	return rl.path
+}
+
+// ReloadableAuth serves credentials from a TSV file that can be re-read while in use
+type ReloadableAuth struct {
+	reloadable[Auth]
+}
+
+// NewReloadableAuth loads the credentials file at path
+func NewReloadableAuth(path string) (*ReloadableAuth, error) {
+	ra := &ReloadableAuth{reloadable: reloadable[Auth]{path: path, load: Load}}
+	if err := ra.Reload(); err != nil {
+		return nil, err
+	}
+	return ra, nil
+}
+
+// Auth returns the currently loaded credentials
+func (ra *ReloadableAuth) Auth() *Auth {
+	return ra.current.Load()
+}
+
+// Verify checks Basic Auth credentials
+func (ra *ReloadableAuth) Verify(username, password string) bool {
+	return ra.current.Load().Verify(username, password)
+}
+
+// DigestHA1 returns MD5(username:realm:password) for "plain" credentials
+func (ra *ReloadableAuth) DigestHA1(username, realm string) (string, bool) {
+	return ra.current.Load().DigestHA1(username, realm)
+}
+
+// Disabled reports whether a user's account is disabled
+func (ra *ReloadableAuth) Disabled(username string) bool {
+	return ra.current.Load().Disabled(username)
+}
+
+// SetPassword hashes a new password for an existing user, with the same
+// algorithm as their current one, keeping the rest of their row, and
+// rewrites the file. The file is re-read first, so edits made to it since
+// the last reload are kept.
+func (ra *ReloadableAuth) SetPassword(username, password string) error {
+	unlock, err := Lock(ra.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ra.Reload(); err != nil {
+		return err
+	}
+	current := ra.Auth()
+	if _, ok := current.Credentials[username]; !ok {
+		return fmt.Errorf("%w: user %q", fs.ErrNotExist, username)
+	}
+
+	auth := *current
+	auth.Credentials = maps.Clone(current.Credentials)
+	if err := auth.SetPassword(username, password, ""); err != nil {
+		return err
+	}
+
+	if err := Save(ra.path, &auth); err != nil {
+		return err
+	}
+	return ra.Reload()
+}
+
+// ReloadableTokens serves API tokens from a TSV file that can be re-read while in use
+type ReloadableTokens struct {
+	reloadable[Tokens]
+}
+
+// NewReloadableTokens loads the tokens file at path
+func NewReloadableTokens(path string) (*ReloadableTokens, error) {
+	rt := &ReloadableTokens{reloadable[Tokens]{path: path, load: LoadTokens}}
+	if err := rt.Reload(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// Tokens returns the currently loaded tokens
+func (rt *ReloadableTokens) Tokens() *Tokens {
+	return rt.current.Load()
+}
+
+// VerifyToken returns the user a bearer token belongs to
+func (rt *ReloadableTokens) VerifyToken(token string) (Username, bool) {
+	return rt.current.Load().VerifyToken(token)
+}
+
+// ReloadableHtpasswd serves credentials from an htpasswd file that can be
+// re-read while in use
+type ReloadableHtpasswd struct {
+	reloadable[Htpasswd]
+}
+
+// NewReloadableHtpasswd loads the htpasswd file at path
+func NewReloadableHtpasswd(path string) (*ReloadableHtpasswd, error) {
+	rh := &ReloadableHtpasswd{reloadable[Htpasswd]{path: path, load: LoadHtpasswdFile}}
+	if err := rh.Reload(); err != nil {
+		return nil, err
+	}
+	return rh, nil
+}
+
+// Verify checks Basic Auth credentials
+func (rh *ReloadableHtpasswd) Verify(username, password string) bool {
+	return rh.current.Load().Verify(username, password)
+}
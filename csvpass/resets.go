@@ -0,0 +1,288 @@
+package csvpass
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResetTokenPrefix marks password reset tokens, so they aren't mistaken
+// for API tokens
+const ResetTokenPrefix = "lrt_"
+
+// ResetToken lets its holder set a user's password once, until it
+// expires. Like Token, only its SHA-256 digest is stored.
+type ResetToken struct {
+	ID      string
+	User    Username
+	Created time.Time
+	Expires time.Time
+	Digest  []byte
+}
+
+// ToRecord encodes the token as an id, user, created, expires, digest row
+func (t ResetToken) ToRecord() []string {
+	return []string{
+		t.ID,
+		t.User,
+		t.Created.UTC().Format(time.RFC3339),
+		t.Expires.UTC().Format(time.RFC3339),
+		hex.EncodeToString(t.Digest),
+	}
+}
+
+// ResetTokens holds reset tokens keyed by hex digest
+type ResetTokens struct {
+	ByDigest map[string]ResetToken
+}
+
+// NewResetToken generates a reset token for a user that expires after
+// ttl, returning the plaintext (shown once) and the record to store
+func NewResetToken(user Username, ttl time.Duration) (string, ResetToken) {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	plain := ResetTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	digest := sha256.Sum256([]byte(plain))
+	now := time.Now().UTC().Truncate(time.Second)
+	return plain, ResetToken{
+		ID:      hex.EncodeToString(digest[:6]),
+		User:    user,
+		Created: now,
+		Expires: now.Add(ttl),
+		Digest:  digest[:],
+	}
+}
+
+// LoadResetTokens reads reset tokens from the given file
+func LoadResetTokens(f *os.File) (*ResetTokens, error) {
+	tokens := &ResetTokens{ByDigest: make(map[string]ResetToken)}
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 5 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+
+		created, err := time.Parse(time.RFC3339, record[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid created time %q for reset token %s", record[2], record[0])
+		}
+		expires, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry time %q for reset token %s", record[3], record[0])
+		}
+		digest, err := hex.DecodeString(record[4])
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid digest for reset token %s", record[0])
+		}
+
+		tokens.ByDigest[record[4]] = ResetToken{
+			ID:      record[0],
+			User:    record[1],
+			Created: created,
+			Expires: expires,
+			Digest:  digest,
+		}
+	}
+
+	return tokens, nil
+}
+
+// Save writes the tokens as TSV, sorted by user then creation time
+func (t ResetTokens) Save(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = '\t'
+
+	_ = writer.Write([]string{"id", "user", "created", "expires", "digest"})
+	for _, token := range t.List("") {
+		_ = writer.Write(token.ToRecord())
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// List returns the reset tokens for a user (or everyone, if user is empty)
+func (t ResetTokens) List(user Username) []ResetToken {
+	var list []ResetToken
+	for _, token := range t.ByDigest {
+		if user == "" || token.User == user {
+			list = append(list, token)
+		}
+	}
+	slices.SortFunc(list, func(a, b ResetToken) int {
+		if c := strings.Compare(a.User, b.User); c != 0 {
+			return c
+		}
+		return a.Created.Compare(b.Created)
+	})
+	return list
+}
+
+// Revoke removes the reset token with the given id, reporting whether it
+// existed
+func (t *ResetTokens) Revoke(id string) bool {
+	for key, token := range t.ByDigest {
+		if token.ID == id {
+			delete(t.ByDigest, key)
+			return true
+		}
+	}
+	return false
+}
+
+// Redeem removes a reset token and returns its user, if it exists and
+// hasn't expired
+func (t *ResetTokens) Redeem(token string, now time.Time) (Username, bool) {
+	if !strings.HasPrefix(token, ResetTokenPrefix) {
+		return "", false
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(digest[:])
+	found, ok := t.ByDigest[key]
+	if !ok || !now.Before(found.Expires) {
+		return "", false
+	}
+	delete(t.ByDigest, key)
+	return found.User, true
+}
+
+// prune removes expired tokens
+func (t *ResetTokens) prune(now time.Time) {
+	for key, token := range t.ByDigest {
+		if !now.Before(token.Expires) {
+			delete(t.ByDigest, key)
+		}
+	}
+}
+
+// ResetTokenFile keeps reset tokens in a TSV file, which it reads afresh
+// for each change, so tokens issued by the CLI and by the server both work
+type ResetTokenFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewResetTokenFile uses the reset tokens file at path, which is created
+// when the first token is issued
+func NewResetTokenFile(path string) *ResetTokenFile {
+	return &ResetTokenFile{path: path}
+}
+
+// Path is the reset tokens file
+func (rf *ResetTokenFile) Path() string {
+	return rf.path
+}
+
+// Load reads the file, treating a missing file as empty
+func (rf *ResetTokenFile) Load() (*ResetTokens, error) {
+	f, err := os.Open(rf.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ResetTokens{ByDigest: make(map[string]ResetToken)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return LoadResetTokens(f)
+}
+
+// errUnchanged has Update leave the file as it is
+var errUnchanged = errors.New("unchanged")
+
+// Update loads the tokens, lets fn change them, drops expired ones and
+// replaces the file via a temporary file. Nothing is written if fn fails.
+func (rf *ResetTokenFile) Update(fn func(*ResetTokens) error) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	unlock, err := Lock(rf.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tokens, err := rf.Load()
+	if err != nil {
+		return err
+	}
+	if err := fn(tokens); err != nil {
+		if err == errUnchanged {
+			return nil
+		}
+		return err
+	}
+	tokens.prune(time.Now())
+
+	tmpPath := rf.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := tokens.Save(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, rf.path)
+}
+
+// IssueResetToken adds a reset token for a user, returning the plaintext
+// and when it expires
+func (rf *ResetTokenFile) IssueResetToken(username string, ttl time.Duration) (string, time.Time, error) {
+	plain, token := NewResetToken(username, ttl)
+	err := rf.Update(func(tokens *ResetTokens) error {
+		tokens.ByDigest[hex.EncodeToString(token.Digest)] = token
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return plain, token.Expires, nil
+}
+
+// RedeemResetToken uses up a reset token, returning its user if it was
+// valid
+func (rf *ResetTokenFile) RedeemResetToken(token string) (string, bool, error) {
+	var user Username
+	var ok bool
+	err := rf.Update(func(tokens *ResetTokens) error {
+		user, ok = tokens.Redeem(token, time.Now())
+		if !ok {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return user, ok, nil
+}
@@ -0,0 +1,66 @@
+package csvpass
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	RoleUpload = "upload"
+	RoleRead   = "read"
+	RoleAdmin  = "admin"
+)
+
+// DefaultRoles apply to rows without a roles column, matching the
+// original behavior where any user could both read and write
+var DefaultRoles = []string{RoleUpload, RoleRead}
+
+// ParseRoles splits and validates a comma-separated roles list
+func ParseRoles(list string) ([]string, error) {
+	var roles []string
+	for _, role := range strings.Split(list, ",") {
+		role = strings.TrimSpace(role)
+		if len(role) == 0 {
+			continue
+		}
+		if !slices.Contains([]string{RoleUpload, RoleRead, RoleAdmin}, role) {
+			return nil, fmt.Errorf("invalid role %q", role)
+		}
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// EffectiveRoles returns the challenge's roles, or DefaultRoles if none are set
+func (c Challenge) EffectiveRoles() []string {
+	if len(c.Roles) == 0 {
+		return DefaultRoles
+	}
+	return c.Roles
+}
+
+// Roles returns a user's roles, or nil if the user is unknown
+func (a Auth) Roles(username string) []string {
+	challenge, ok := a.Credentials[username]
+	if !ok {
+		return nil
+	}
+	return challenge.EffectiveRoles()
+}
+
+// Roles returns a user's roles, or nil if the user is unknown
+func (ra *ReloadableAuth) Roles(username string) []string {
+	return ra.current.Load().Roles(username)
+}
+
+// Roles returns a user's roles, or nil if the user is unknown
+func (v StoreVerifier) Roles(username string) []string {
+	challenge, ok, err := v.Store.Get(username)
+	if err != nil || !ok {
+		return nil
+	}
+	return challenge.EffectiveRoles()
+}
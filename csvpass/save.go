@@ -0,0 +1,95 @@
+package csvpass
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Save replaces the credentials file at path with auth, sorted by
+// username, with the extra columns and comments it was loaded with. It
+// writes a temporary file that's renamed over it, so readers see the old
+// file or the new one and never a partial write. It doesn't lock: to edit
+// the file, hold Lock(path) from loading it until Save returns, so a
+// concurrent edit isn't lost.
+func Save(path string, auth *Auth) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	err = auth.Save(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Lock takes an exclusive lock for editing the file at path, waiting for
+// any other process (or goroutine) that holds it. The lock is on a
+// separate path + ".lock" file, as the file itself is replaced by each
+// save. Call the returned func to release it.
+func Lock(path string) (func(), error) {
+	return lockFile(path + ".lock")
+}
+
+// Save writes the credentials as TSV, sorted by username, with the extra
+// columns and comments they were loaded with. Load reads it back.
+func (a Auth) Save(w io.Writer) error {
+	extraColumns := slices.Clone(a.Columns)
+	var added []string
+	for _, challenge := range a.Credentials {
+		for name := range challenge.Extra {
+			if !slices.Contains(extraColumns, name) && !slices.Contains(added, name) {
+				added = append(added, name)
+			}
+		}
+	}
+	slices.Sort(added)
+	extraColumns = append(extraColumns, added...)
+
+	bw := bufio.NewWriter(w)
+	writer := csv.NewWriter(bw)
+	writer.Comma = '\t'
+	writeComments := func(comments []string) {
+		writer.Flush()
+		for _, comment := range comments {
+			_, _ = bw.WriteString(comment + "\n")
+		}
+	}
+
+	writeComments(a.Comments)
+	_ = writer.Write(append(slices.Clone(columns), extraColumns...))
+	for _, username := range slices.Sorted(maps.Keys(a.Credentials)) {
+		challenge := a.Credentials[username]
+		writeComments(challenge.Comments)
+		record := challenge.ToRecord(username)
+		for _, name := range extraColumns {
+			record = append(record, challenge.Extra[name])
+		}
+		_ = writer.Write(record)
+	}
+	writeComments(a.TrailingComments)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,141 @@
+// Package sqlitestore is a SQLite-backed csvpass.CredentialStore, for
+// deployments with too many users (or admins) for a single TSV file
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/paperos-labs/logapi/csvpass"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS credentials (
+	id TEXT PRIMARY KEY NOT NULL,
+	algo TEXT NOT NULL,
+	salt TEXT NOT NULL DEFAULT '',
+	digest TEXT NOT NULL,
+	roles TEXT NOT NULL DEFAULT '',
+	disabled TEXT NOT NULL DEFAULT ''
+)`
+
+// Store keeps one row per user, in the same columns as the TSV
+type Store struct {
+	db *sql.DB
+}
+
+var _ csvpass.CredentialStore = (*Store)(nil)
+
+// Open opens (or creates) the database at path. WAL mode and a busy
+// timeout let several admin sessions write concurrently.
+func Open(path string) (*Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// migrate adds columns introduced after a database was created
+func migrate(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('credentials')`)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !columns["roles"] {
+		if _, err := db.Exec(`ALTER TABLE credentials ADD COLUMN roles TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	if !columns["disabled"] {
+		if _, err := db.Exec(`ALTER TABLE credentials ADD COLUMN disabled TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the challenge for a user
+func (s *Store) Get(username csvpass.Username) (csvpass.Challenge, bool, error) {
+	var algo, salt, digest, roles, disabled string
+	row := s.db.QueryRow(`SELECT algo, salt, digest, roles, disabled FROM credentials WHERE id = ?`, username)
+	if err := row.Scan(&algo, &salt, &digest, &roles, &disabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return csvpass.Challenge{}, false, nil
+		}
+		return csvpass.Challenge{}, false, err
+	}
+
+	_, challenge, err := csvpass.ParseRecord([]string{username, algo, salt, digest, roles, disabled})
+	if err != nil {
+		return csvpass.Challenge{}, false, fmt.Errorf("invalid credentials for %q: %w", username, err)
+	}
+	return challenge, true, nil
+}
+
+// Put adds or replaces the challenge for a user
+func (s *Store) Put(username csvpass.Username, challenge csvpass.Challenge) error {
+	record := challenge.ToRecord(username)
+	_, err := s.db.Exec(
+		`INSERT INTO credentials (id, algo, salt, digest, roles, disabled) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET algo = excluded.algo, salt = excluded.salt, digest = excluded.digest, roles = excluded.roles, disabled = excluded.disabled`,
+		record[0], record[1], record[2], record[3], record[4], record[5],
+	)
+	return err
+}
+
+// Delete removes a user
+func (s *Store) Delete(username csvpass.Username) error {
+	_, err := s.db.Exec(`DELETE FROM credentials WHERE id = ?`, username)
+	return err
+}
+
+// List returns all usernames, sorted
+func (s *Store) List() ([]csvpass.Username, error) {
+	rows, err := s.db.Query(`SELECT id FROM credentials ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var usernames []csvpass.Username
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
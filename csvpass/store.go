@@ -0,0 +1,140 @@
+package csvpass
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+)
+
+// CredentialStore persists challenges by username
+type CredentialStore interface {
+	Get(username Username) (Challenge, bool, error)
+	Put(username Username, challenge Challenge) error
+	Delete(username Username) error
+	List() ([]Username, error)
+}
+
+var _ CredentialStore = (*Auth)(nil)
+
+// Get returns the challenge for a user
+func (a *Auth) Get(username Username) (Challenge, bool, error) {
+	challenge, ok := a.Credentials[username]
+	return challenge, ok, nil
+}
+
+// Put adds or replaces the challenge for a user (in memory only)
+func (a *Auth) Put(username Username, challenge Challenge) error {
+	a.Credentials[username] = challenge
+	return nil
+}
+
+// SetPassword hashes a password for a user with algorithm (as NewChallenge
+// takes it), adding them with DefaultRoles if they're new, and otherwise
+// keeping the rest of their row. An empty algorithm hashes it the same way
+// as the user's current password, or with DefaultAlgorithm for a new user.
+// Like Put, it only changes a; Save writes it.
+func (a *Auth) SetPassword(username Username, password, algorithm string) error {
+	if username == "" || username == "id" {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	existing, ok := a.Credentials[username]
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+		if ok {
+			algorithm = existing.Algorithm()
+		}
+	}
+
+	updated, err := existing.WithPassword(algorithm, password)
+	if err != nil {
+		return err
+	}
+	a.Credentials[username] = updated
+	return nil
+}
+
+// Delete removes a user (in memory only)
+func (a *Auth) Delete(username Username) error {
+	delete(a.Credentials, username)
+	return nil
+}
+
+// List returns all usernames, sorted
+func (a *Auth) List() ([]Username, error) {
+	return slices.Sorted(maps.Keys(a.Credentials)), nil
+}
+
+// StoreVerifier checks Basic Auth credentials against any CredentialStore
+type StoreVerifier struct {
+	Store CredentialStore
+}
+
+// Verify checks Basic Auth credentials
+func (v StoreVerifier) Verify(username, password string) bool {
+	challenge, ok, err := v.Store.Get(username)
+	if err != nil {
+		return false
+	}
+	if !ok {
+		_ = dummyChallenge().Verify(password)
+		return false
+	}
+
+	return challenge.Verify(password)
+}
+
+// Disabled reports whether a user's account is disabled
+func (v StoreVerifier) Disabled(username string) bool {
+	challenge, _, err := v.Store.Get(username)
+	return err == nil && challenge.Disabled
+}
+
+// DigestHA1 returns MD5(username:realm:password) for "plain" credentials
+func (v StoreVerifier) DigestHA1(username, realm string) (string, bool) {
+	challenge, ok, err := v.Store.Get(username)
+	if err != nil || !ok {
+		return "", false
+	}
+
+	return challenge.DigestHA1(username, realm)
+}
+
+// SetPassword hashes a new password for an existing user, with the same
+// algorithm as their current one, keeping the rest of their row
+func (v StoreVerifier) SetPassword(username, password string) error {
+	challenge, ok, err := v.Store.Get(username)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: user %q", fs.ErrNotExist, username)
+	}
+
+	updated, err := challenge.WithPassword(challenge.Algorithm(), password)
+	if err != nil {
+		return err
+	}
+	return v.Store.Put(username, updated)
+}
+
+// Collect copies every credential in a store into an in-memory Auth
+func Collect(store CredentialStore) (*Auth, error) {
+	auth := &Auth{Credentials: make(map[Username]Challenge)}
+
+	usernames, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range usernames {
+		challenge, ok, err := store.Get(username)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			auth.Credentials[username] = challenge
+		}
+	}
+
+	return auth, nil
+}
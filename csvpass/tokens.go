@@ -0,0 +1,152 @@
+package csvpass
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TokenPrefix marks logapi bearer tokens, so they are easy to spot in code and logs
+const TokenPrefix = "lat_"
+
+// Token is an opaque bearer credential, stored only as its SHA-256 digest
+type Token struct {
+	ID      string
+	User    Username
+	Created time.Time
+	Digest  []byte
+}
+
+// ToRecord encodes the token as an id, user, created, digest row
+func (t Token) ToRecord() []string {
+	return []string{t.ID, t.User, t.Created.UTC().Format(time.RFC3339), hex.EncodeToString(t.Digest)}
+}
+
+// Tokens holds API tokens keyed by hex digest
+type Tokens struct {
+	ByDigest map[string]Token
+}
+
+// NewToken generates a random token for a user, returning the plaintext
+// (shown once) and the record to store
+func NewToken(user Username) (string, Token) {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	plain := TokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	digest := sha256.Sum256([]byte(plain))
+	return plain, Token{
+		ID:      hex.EncodeToString(digest[:6]),
+		User:    user,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Digest:  digest[:],
+	}
+}
+
+// LoadTokens reads tokens from the given file
+func LoadTokens(f *os.File) (*Tokens, error) {
+	tokens := &Tokens{ByDigest: make(map[string]Token)}
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 4 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+
+		created, err := time.Parse(time.RFC3339, record[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid created time %q for token %s", record[2], record[0])
+		}
+		digest, err := hex.DecodeString(record[3])
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid digest for token %s", record[0])
+		}
+
+		tokens.ByDigest[record[3]] = Token{
+			ID:      record[0],
+			User:    record[1],
+			Created: created,
+			Digest:  digest,
+		}
+	}
+
+	return tokens, nil
+}
+
+// Save writes the tokens as TSV, sorted by user then creation time
+func (t Tokens) Save(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = '\t'
+
+	_ = writer.Write([]string{"id", "user", "created", "digest"})
+	for _, token := range t.List("") {
+		_ = writer.Write(token.ToRecord())
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// List returns the tokens for a user (or everyone, if user is empty)
+func (t Tokens) List(user Username) []Token {
+	var list []Token
+	for _, token := range t.ByDigest {
+		if user == "" || token.User == user {
+			list = append(list, token)
+		}
+	}
+	slices.SortFunc(list, func(a, b Token) int {
+		if c := strings.Compare(a.User, b.User); c != 0 {
+			return c
+		}
+		return a.Created.Compare(b.Created)
+	})
+	return list
+}
+
+// Revoke removes the token with the given id, reporting whether it existed
+func (t *Tokens) Revoke(id string) bool {
+	for key, token := range t.ByDigest {
+		if token.ID == id {
+			delete(t.ByDigest, key)
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyToken returns the user a bearer token belongs to.
+// Tokens carry 256 bits of entropy, so a fast hash is sufficient.
+func (t Tokens) VerifyToken(token string) (Username, bool) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return "", false
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	found, ok := t.ByDigest[hex.EncodeToString(digest[:])]
+	if !ok {
+		return "", false
+	}
+	return found.User, true
+}
@@ -0,0 +1,49 @@
+package logapi
+
+import (
+	"strings"
+	"time"
+)
+
+// parseDate accepts a month (YYYY-MM) or, for busy users, a day (YYYY-MM-DD)
+func parseDate(date string) (time.Time, error) {
+	if len(date) == len("2006-01-02") {
+		return time.Parse("2006-01-02", date)
+	}
+	return time.Parse("2006-01", date)
+}
+
+// splitDate returns the month a date is stored under and the prefix for
+// its file names: a day's files live in a DD/ subdirectory of their month
+func splitDate(date string) (month, prefix string, ok bool) {
+	if _, err := parseDate(date); err != nil {
+		return "", "", false
+	}
+	month, day, isDay := strings.Cut(date[len("2006-"):], "-")
+	month = date[:len("2006-")] + month
+	if !isDay {
+		return month, "", true
+	}
+	return month, day + "/", true
+}
+
+// dirEntries lists the immediate children of dir ("" or "DD/") among a
+// month's file names, with subdirectories shown once, as "name/"
+func dirEntries(files []FileInfo, dir string) []string {
+	entries := []string{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		rest, ok := strings.CutPrefix(file.Name, dir)
+		if !ok {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			rest = sub + "/"
+		}
+		if !seen[rest] {
+			seen[rest] = true
+			entries = append(entries, rest)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,133 @@
+package logapi
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// storedEncodings are the compressed files ?decompress=true serves the
+// content of, by extension, with their Content-Encoding
+var storedEncodings = map[string]string{".gz": "gzip", ".zst": "zstd"}
+
+// decompressRequested reports whether a download asks for a compressed
+// file's content rather than its bytes
+func decompressRequested(r *http.Request) bool {
+	v := r.URL.Query().Get("decompress")
+	return v == "true" || v == "1"
+}
+
+// writeDecompressed serves a stored .gz or .zst file's content, live or
+// archived. A client that accepts the file's encoding gets the stored bytes
+// with Content-Encoding, to decode itself; others get them decoded here.
+func (s *Server) writeDecompressed(w http.ResponseWriter, r *http.Request, user, date, name string, info FileInfo, encoding string) {
+	if !slices.Contains(w.Header().Values("Vary"), "Accept-Encoding") {
+		w.Header().Add("Vary", "Accept-Encoding") // unless CompressResponses did
+	}
+	passthrough := acceptsEncoding(r.Header.Get("Accept-Encoding"), encoding)
+	variant := "decoded"
+	if passthrough {
+		variant = encoding
+	}
+	etag := strings.TrimSuffix(fileETag(info.Size, info.ModTime), `"`) + "-" + variant + `"`
+	if notModified(w, r, etag) {
+		return
+	}
+
+	f, err := s.store.Open(user, date, name)
+	if err != nil {
+		s.fileNotFound(w, user, date, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	contentType := mime.TypeByExtension(path.Ext(strings.TrimSuffix(name, path.Ext(name))))
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if passthrough {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		_, _ = io.Copy(w, f)
+		return
+	}
+
+	var body io.Reader
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", name+": "+err.Error())
+			return
+		}
+		body = zr
+	} else {
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", name+": "+err.Error())
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	// a corrupt file is cut short; the status has been sent
+	_, _ = io.Copy(w, body)
+}
+
+// writeStoredZstd sends an archived file's own zstd frames as they're
+// stored, with Content-Encoding, to a client that accepts zstd, rather than
+// decompressing it from the tarball for CompressResponses to compress it
+// again. It returns false, having sent nothing, if the file isn't stored
+// that way or the client doesn't accept zstd.
+func (s *Server) writeStoredZstd(w http.ResponseWriter, r *http.Request, user, date, name string, info FileInfo) bool {
+	opener, ok := s.store.(ZstdOpener)
+	if !ok {
+		return false
+	}
+	if !slices.Contains(w.Header().Values("Vary"), "Accept-Encoding") {
+		w.Header().Add("Vary", "Accept-Encoding") // unless CompressResponses did
+	}
+	if !acceptsEncoding(r.Header.Get("Accept-Encoding"), "zstd") {
+		return false
+	}
+	f, size, err := opener.OpenZstd(user, date, name)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	etag := strings.TrimSuffix(fileETag(info.Size, info.ModTime), `"`) + `-zstd"`
+	if notModified(w, r, etag) {
+		return true
+	}
+	w.Header().Set("Content-Encoding", "zstd")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.Copy(w, f)
+	return true
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows an
+// encoding, by name or *
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err != nil || q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
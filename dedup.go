@@ -0,0 +1,323 @@
+package logapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir holds content-addressed copies of live files, hardlinked into
+// each month that has them
+const blobsDir = ".blobs"
+
+// SetDedupBlobs stores live files content-addressed under {root}/.blobs,
+// hardlinked into their months, so identical files share disk space. It
+// needs hardlinks, and so a Unix-like OS. Call it before use.
+func (fsys *FSStorage) SetDedupBlobs(on bool) error {
+	if on && !hardlinkCounts {
+		return fmt.Errorf("content-addressed blobs need hardlink counts, which this OS doesn't report")
+	}
+	fsys.blobs = on
+	return nil
+}
+
+// PutIfChanged is Put, except that a live file that already has the same
+// content is left as it is, and changed is false
+func (fsys *FSStorage) PutIfChanged(user, date, name string, body io.Reader) (bool, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return false, err
+	}
+	defer lockFile(filePath).Unlock()
+
+	tmpPath := filePath + ".tmp"
+	size, sum, dirCreated, err := fsys.writeTemp(tmpPath, body)
+	if err != nil {
+		return false, err
+	}
+	if sameContent(filePath, size, sum) {
+		return false, os.Remove(tmpPath)
+	}
+	if fsys.blobs {
+		if err := fsys.linkBlob(tmpPath, sum); err != nil {
+			_ = os.Remove(tmpPath)
+			return false, err
+		}
+	}
+	if fsys.versions != nil && fsys.versions(user) {
+		if err := fsys.keepVersion(user, date, name); err != nil {
+			_ = os.Remove(tmpPath)
+			return false, err
+		}
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return false, err
+	}
+	if fsys.sync {
+		return true, fsys.syncDirs(filepath.Dir(filePath), dirCreated)
+	}
+	return true, nil
+}
+
+// Create checks for the file and stores it under its lock, and links the
+// temp file into place rather than renaming it, so nothing written since
+// is replaced either
+func (fsys *FSStorage) Create(user, date, name string, body io.Reader) error {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return err
+	}
+	defer lockFile(filePath).Unlock()
+
+	if _, err := fsys.Stat(user, date, name); err == nil {
+		return fmt.Errorf("%w: %s/%s", fs.ErrExist, date, name)
+	}
+	tmpPath := filePath + ".tmp"
+	_, sum, dirCreated, err := fsys.writeTemp(tmpPath, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	if fsys.blobs {
+		if err := fsys.linkBlob(tmpPath, sum); err != nil {
+			return err
+		}
+	}
+	if err := os.Link(tmpPath, filePath); err != nil {
+		return err
+	}
+	if fsys.sync {
+		return fsys.syncDirs(filepath.Dir(filePath), dirCreated)
+	}
+	return nil
+}
+
+// CreateFirst writes the body once, to a temp file of its own, then links
+// it into place under the first name that's free, checking each under its
+// lock as Create does
+func (fsys *FSStorage) CreateFirst(user, date string, names func(n int) string, body io.Reader) (string, error) {
+	first, err := fsys.filePath(user, date, names(0))
+	if err != nil {
+		return "", err
+	}
+	_, statErr := os.Stat(filepath.Dir(first))
+	if err := os.MkdirAll(filepath.Dir(first), 0755); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(first), filepath.Base(first)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	// readable like a Put file, not just by the owner
+	if err := tmpFile.Chmod(0644); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+	_, sum, err := fsys.writeBody(tmpFile, body)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	if fsys.blobs {
+		if err := fsys.linkBlob(tmpFile.Name(), sum); err != nil {
+			return "", err
+		}
+	}
+
+	for n := 0; ; n++ {
+		name := names(n)
+		filePath, err := fsys.filePath(user, date, name)
+		if err != nil {
+			return "", err
+		}
+		err = fsys.linkNew(user, date, name, filePath, tmpFile.Name())
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fsys.sync {
+			return name, fsys.syncDirs(filepath.Dir(filePath), statErr != nil)
+		}
+		return name, nil
+	}
+}
+
+// linkNew links tmpPath into place at filePath, failing with fs.ErrExist
+// if the file exists, live or archived
+func (fsys *FSStorage) linkNew(user, date, name, filePath, tmpPath string) error {
+	defer lockFile(filePath).Unlock()
+
+	if _, err := fsys.Stat(user, date, name); err == nil {
+		return fmt.Errorf("%w: %s/%s", fs.ErrExist, date, name)
+	}
+	return os.Link(tmpPath, filePath)
+}
+
+// writeTemp writes body to tmpPath, creating its directory if need be, and
+// returns its size and SHA-256, and whether the directory was created
+func (fsys *FSStorage) writeTemp(tmpPath string, body io.Reader) (int64, string, bool, error) {
+	_, statErr := os.Stat(filepath.Dir(tmpPath))
+	if err := os.MkdirAll(filepath.Dir(tmpPath), 0755); err != nil {
+		return 0, "", false, err
+	}
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, "", false, err
+	}
+	size, sum, err := fsys.writeBody(tmpFile, body)
+	if err != nil {
+		return 0, "", false, err
+	}
+	return size, sum, statErr != nil, nil
+}
+
+// writeBody writes body to a new temp file and closes it, removing it if
+// that fails, and returns its size and SHA-256
+func (fsys *FSStorage) writeBody(tmpFile *os.File, body io.Reader) (int64, string, error) {
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, h), body)
+	if err == nil && fsys.sync {
+		err = tmpFile.Sync()
+	}
+	if err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return 0, "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameContent reports whether the file at path has the given size and
+// SHA-256
+func sameContent(path string, size int64, sum string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	if info, err := f.Stat(); err != nil || info.Size() != size {
+		return false
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum
+}
+
+// blobPath is where content with the given SHA-256 is kept
+func (fsys *FSStorage) blobPath(sum string) string {
+	return filepath.Join(fsys.root, blobsDir, sum[:2], sum)
+}
+
+// linkBlob makes the new file at tmpPath a hardlink of the blob with its
+// content, storing it as that blob if there's none yet
+func (fsys *FSStorage) linkBlob(tmpPath, sum string) error {
+	blob := fsys.blobPath(sum)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	for {
+		// reuse the blob, unless PruneBlobs just removed it
+		err := os.Link(blob, tmpPath+".blob")
+		if err == nil {
+			return os.Rename(tmpPath+".blob", tmpPath)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// or store this file as the blob, unless another Put just did
+		err = os.Link(tmpPath, blob)
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+}
+
+// unshare gives a live file its own copy of its content, if it's linked
+// to a blob, so it can be changed in place. The caller holds its lock.
+func (fsys *FSStorage) unshare(filePath string) error {
+	if !fsys.blobs {
+		return nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || linkCount(info) < 2 {
+		return nil
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	return saveUpload(filepath.Dir(filePath), filepath.Base(filePath), src)
+}
+
+// PruneBlobs removes blobs that no live file links to any more, such as
+// those of archived or deleted months, returning how many it removed
+func (fsys *FSStorage) PruneBlobs() (int, error) {
+	if !fsys.blobs {
+		return 0, nil
+	}
+	var pruned int
+	err := filepath.WalkDir(filepath.Join(fsys.root, blobsDir), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if linkCount(info) == 1 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			pruned++
+		}
+		return nil
+	})
+	return pruned, err
+}
+
+// storedSHA256 returns a stored file's hex SHA-256, or "" if it can't
+func (s *Server) storedSHA256(user, date, name string) string {
+	hasher, ok := s.store.(Hasher)
+	if !ok {
+		return ""
+	}
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+	sum, err := hasher.SHA256(user, date, name)
+	if err != nil {
+		return ""
+	}
+	return sum
+}
+
+// writeUnchanged answers an upload of content that was already stored
+func (s *Server) writeUnchanged(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message":   fmt.Sprintf("File unchanged: %s", r.URL.Path),
+		"unchanged": true,
+	})
+}
@@ -0,0 +1,103 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// DeleteFile removes a file from a live month. Archived months are
+// immutable, so a file that only exists in the tarball is a 409.
+func (s *Server) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleUpload) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.canAccess(username, user) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only delete your own files")
+		return
+	}
+	date := r.PathValue("date")
+	name := r.PathValue("name")
+	if !s.validPath(w, user, date, name) {
+		return
+	}
+
+	month, prefix, _ := splitDate(date)
+
+	err := s.store.Remove(user, month, prefix+name)
+	switch {
+	case err == nil:
+		s.notify(Event{Event: EventDelete, User: user, Date: month, Name: prefix + name})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		_ = enc.Encode(map[string]string{
+			"message": fmt.Sprintf("File deleted: %s/%s/%s", user, date, name),
+		})
+	case errors.Is(err, ErrArchived):
+		s.jsonError(w, http.StatusConflict, "file_archived", "File is archived", fmt.Sprintf("%s has been compressed into %s.tar.%s and can no longer be deleted individually", date, month, s.compress))
+	case errors.Is(err, fs.ErrNotExist):
+		s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", fmt.Sprintf("%s/%s does not exist", date, name))
+	default:
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+	}
+}
+
+// DeleteMonth removes a whole month, live directory and tarball alike.
+// It requires the admin role and an X-Confirm-Delete header repeating the
+// month, so a stray request can't wipe data.
+func (s *Server) DeleteMonth(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	user := r.PathValue("user")
+	date := r.PathValue("date")
+	if !s.validUser(w, user) {
+		return
+	}
+	if _, err := time.Parse("2006-01", date); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", "Date must be YYYY-MM")
+		return
+	}
+
+	if r.Header.Get("X-Confirm-Delete") != date {
+		s.jsonError(w, http.StatusPreconditionRequired, "confirmation_required", "Confirmation required", fmt.Sprintf("Set X-Confirm-Delete: %s to delete the whole month", date))
+		return
+	}
+
+	s.commitLock.Lock()
+	defer s.commitLock.Unlock()
+
+	removed, err := s.store.RemoveMonth(user, date)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.jsonError(w, http.StatusNotFound, "month_not_found", "Month not found", fmt.Sprintf("%s/%s does not exist", user, date))
+		return
+	}
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	s.notify(Event{Event: EventDelete, User: user, Date: date})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": fmt.Sprintf("Month deleted: %s/%s", user, date),
+		"results": removed,
+	})
+}
@@ -0,0 +1,71 @@
+package logapi
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// How POST /api/logs handles a Content-Encoding of gzip or zstd
+const (
+	// UploadDecompress stores the decoded file under its own name
+	UploadDecompress = "decompress"
+	// UploadStore keeps the compressed bytes, adding .gz or .zst to the name
+	UploadStore = "store"
+)
+
+// WithUploadEncoding chooses UploadDecompress (the default) or UploadStore
+// for uploads sent with Content-Encoding
+func WithUploadEncoding(mode string) Option {
+	return func(s *Server) {
+		s.uploadEncoding = mode
+	}
+}
+
+// decodeUpload applies the request's Content-Encoding. It replaces r.Body
+// with the decoded stream, or, when storing compressed files, returns the
+// suffix to add to the file name. With always, as for multi-file bodies
+// (the container has to be read) and appends, the body is decoded either
+// way. It writes a 415 for other encodings.
+func (s *Server) decodeUpload(w http.ResponseWriter, r *http.Request, always bool) (string, bool) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	var suffix string
+	switch encoding {
+	case "", "identity":
+		return "", true
+	case "gzip", "x-gzip":
+		suffix = ".gz"
+	case "zstd":
+		suffix = ".zst"
+	default:
+		s.jsonError(w, http.StatusUnsupportedMediaType, "unsupported_encoding", "Unsupported Content-Encoding", fmt.Sprintf("%q is not supported, use gzip or zstd", encoding))
+		return "", false
+	}
+
+	if s.uploadEncoding == UploadStore && !always {
+		return suffix, true
+	}
+
+	var body io.ReadCloser
+	var err error
+	if suffix == ".gz" {
+		body, err = gzip.NewReader(r.Body)
+	} else {
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(r.Body)
+		if err == nil {
+			body = zr.IOReadCloser()
+		}
+	}
+	if err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_encoding", "Invalid compressed body", err.Error())
+		return "", false
+	}
+	r.Body = body
+	return "", true
+}
@@ -0,0 +1,218 @@
+package eventbus
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/paperos-labs/logapi"
+)
+
+var (
+	_ logapi.Events = (*SQS)(nil)
+	_ logapi.Events = (*SNS)(nil)
+)
+
+// AWSCredentials sign SQS and SNS requests
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// (optionally) AWS_SESSION_TOKEN
+func AWSCredentialsFromEnv() (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// SQS sends each event as a JSON message to a queue, with the event name
+// in an "event" message attribute
+type SQS struct {
+	QueueURL    string
+	Region      string
+	Credentials AWSCredentials
+	Client      *http.Client
+}
+
+// NewSQS sends to the queue at queueURL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/logapi-events, in the
+// region it names
+func NewSQS(queueURL string, creds AWSCredentials) (*SQS, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid SQS queue URL %q", queueURL)
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 4 || parts[0] != "sqs" {
+		return nil, fmt.Errorf("no region in SQS queue URL %q", queueURL)
+	}
+	return &SQS{QueueURL: queueURL, Region: parts[1], Credentials: creds}, nil
+}
+
+// Publish sends the event with SendMessage
+func (q *SQS) Publish(ctx context.Context, event logapi.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"Action":                               {"SendMessage"},
+		"Version":                              {"2012-11-05"},
+		"MessageBody":                          {string(body)},
+		"MessageAttribute.1.Name":              {"event"},
+		"MessageAttribute.1.Value.DataType":    {"String"},
+		"MessageAttribute.1.Value.StringValue": {event.Event},
+	}
+	return postAWS(ctx, q.Client, q.QueueURL, "sqs", q.Region, q.Credentials, form)
+}
+
+// SNS publishes each event as a JSON message to a topic, with the event
+// name in an "event" message attribute for subscription filter policies
+type SNS struct {
+	TopicARN    string
+	Region      string
+	Credentials AWSCredentials
+	Client      *http.Client
+}
+
+// NewSNS publishes to the topic topicARN, e.g.
+// arn:aws:sns:us-east-1:123456789012:logapi-events, in the region it names
+func NewSNS(topicARN string, creds AWSCredentials) (*SNS, error) {
+	parts := strings.Split(topicARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q", topicARN)
+	}
+	return &SNS{TopicARN: topicARN, Region: parts[3], Credentials: creds}, nil
+}
+
+// Publish sends the event with Publish
+func (t *SNS) Publish(ctx context.Context, event logapi.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {"2010-03-31"},
+		"TopicArn":                       {t.TopicARN},
+		"Message":                        {string(body)},
+		"MessageAttributes.entry.1.Name": {"event"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {event.Event},
+	}
+	endpoint := fmt.Sprintf("https://sns.%s.amazonaws.com/", t.Region)
+	return postAWS(ctx, t.Client, endpoint, "sns", t.Region, t.Credentials, form)
+}
+
+// awsError is the error body of AWS query APIs
+type awsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// postAWS POSTs a query API form, signed with Signature Version 4
+func postAWS(ctx context.Context, client *http.Client, endpoint, service, region string, creds AWSCredentials, form url.Values) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, []byte(body), service, region, creds, time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var awsErr awsError
+		if xml.Unmarshal(data, &awsErr) == nil && awsErr.Code != "" {
+			return fmt.Errorf("%s: %s: %s", service, awsErr.Code, awsErr.Message)
+		}
+		return fmt.Errorf("%s: %s", service, resp.Status)
+	}
+	return nil
+}
+
+// signV4 adds the X-Amz-Date, X-Amz-Security-Token and Authorization
+// headers of AWS Signature Version 4
+func signV4(req *http.Request, body []byte, service, region string, creds AWSCredentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-date"}
+	headerValues := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         req.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	if creds.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+		headerValues["x-amz-security-token"] = creds.SessionToken
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headerValues[name]) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
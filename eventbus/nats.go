@@ -0,0 +1,200 @@
+// Package eventbus publishes logapi events to NATS subjects and to SQS
+// queues or SNS topics, speaking their wire protocols directly
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paperos-labs/logapi"
+)
+
+const (
+	natsDialTimeout = 10 * time.Second
+	// DefaultSubject prefixes NATS subjects, as logapi.upload and so on
+	DefaultSubject = "logapi"
+)
+
+var _ logapi.Events = (*NATS)(nil)
+
+// NATS publishes each event as JSON to Subject + "." + the event name, and
+// waits for the server to have processed it
+type NATS struct {
+	// URL is nats://[user:pass@]host:port, or tls:// for TLS. A user with
+	// no password is sent as a token.
+	URL     string
+	Subject string
+
+	mu   sync.Mutex // one publish at a time
+	conn *natsConn
+}
+
+// natsConn is a connection and the reader that answers the server's PINGs
+// and reports its PONGs (or errors) to the publisher
+type natsConn struct {
+	net.Conn
+	writeMu sync.Mutex
+	pongs   chan error
+}
+
+// NewNATS publishes to the NATS server at natsURL, under subject (or
+// DefaultSubject if empty). It connects on the first publish.
+func NewNATS(natsURL, subject string) (*NATS, error) {
+	u, err := url.Parse(natsURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q: must be nats://host:port or tls://host:port", natsURL)
+	}
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	return &NATS{URL: natsURL, Subject: subject}, nil
+}
+
+// Publish sends the event, reconnecting once if the connection was lost
+func (n *NATS) Publish(ctx context.Context, event logapi.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("PUB %s.%s %d\r\n%s\r\nPING\r\n", n.Subject, event.Event, len(body), body)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if n.conn == nil {
+			n.conn, err = n.connect(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		err = n.conn.roundTrip(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		_ = n.conn.Close()
+		n.conn = nil
+		if attempt == 1 {
+			return err
+		}
+	}
+}
+
+// Close closes the connection, if any
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// connect dials the server, reads its INFO, sends CONNECT with any
+// credentials, and waits for the PONG that shows they were accepted
+func (n *NATS) connect(ctx context.Context) (*natsConn, error) {
+	u, _ := url.Parse(n.URL)
+	dialer := &net.Dialer{Timeout: natsDialTimeout}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", u.Host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", u.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(natsDialTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "logapid", "lang": "go"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"] = u.User.Username()
+			opts["pass"] = pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connectOpts, _ := json.Marshal(opts)
+
+	nc := &natsConn{Conn: conn, pongs: make(chan error, 1)}
+	go nc.read(r)
+	if err := nc.roundTrip(ctx, fmt.Sprintf("CONNECT %s\r\nPING\r\n", connectOpts)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// roundTrip writes msg, which ends in a PING, and waits for the PONG
+func (nc *natsConn) roundTrip(ctx context.Context, msg string) error {
+	nc.writeMu.Lock()
+	_ = nc.SetWriteDeadline(time.Now().Add(natsDialTimeout))
+	_, err := nc.Write([]byte(msg))
+	nc.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-nc.pongs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read handles what the server sends until the connection fails
+func (nc *natsConn) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			nc.report(err)
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			nc.writeMu.Lock()
+			_, _ = nc.Write([]byte("PONG\r\n"))
+			nc.writeMu.Unlock()
+		case line == "PONG":
+			nc.report(nil)
+		case strings.HasPrefix(line, "-ERR"):
+			nc.report(errors.New("nats: " + strings.Trim(strings.TrimPrefix(line, "-ERR"), " '")))
+		}
+	}
+}
+
+// report passes a PONG or error to a waiting roundTrip, dropping it if
+// one is already pending
+func (nc *natsConn) report(err error) {
+	select {
+	case nc.pongs <- err:
+	default:
+	}
+}
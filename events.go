@@ -0,0 +1,103 @@
+package logapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Events
+const (
+	// EventUpload is a file stored (or appended to) in a live month
+	EventUpload = "upload"
+	// EventDelete is a file, or a whole month when Name is empty, removed
+	EventDelete = "delete"
+	// EventCompress is a month archived into a tarball
+	EventCompress = "compress"
+	// EventQuota is a user's stored bytes crossing a webhook's QuotaBytes
+	EventQuota = "quota"
+	// EventAuthFailure is a request with missing or invalid credentials
+	EventAuthFailure = "auth_failure"
+)
+
+var eventNames = []string{EventUpload, EventDelete, EventCompress, EventQuota, EventAuthFailure}
+
+const eventTimeout = 10 * time.Second
+
+// Event is something that happened to stored files or the API, as sent
+// to webhooks and Events publishers
+type Event struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"`
+	Date      string    `json:"date,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Tarball   string    `json:"tarball,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Threshold int64     `json:"threshold,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+}
+
+// Events publishes events to a message bus, such as NATS or SQS (see the
+// eventbus package). Publish is called from one goroutine per event.
+type Events interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// eventState is what the server tracks to send events
+type eventState struct {
+	hooks      []Webhook
+	publishers []Events
+	deliveries sync.WaitGroup
+	usageLock  sync.Mutex       // serializes quota checks
+	usage      map[string]int64 // user -> stored bytes at the last check
+}
+
+// WithEvents publishes upload, delete, compress and auth_failure events,
+// in the background
+func WithEvents(publishers ...Events) Option {
+	return func(s *Server) {
+		s.events.publishers = append(s.events.publishers, publishers...)
+	}
+}
+
+// WaitEvents blocks until pending webhook deliveries and event publishes
+// have succeeded or given up
+func (s *Server) WaitEvents() {
+	s.events.deliveries.Wait()
+}
+
+// notify sends an event to every webhook that wants it, and to every
+// publisher
+func (s *Server) notify(event Event) {
+	if len(s.events.hooks) == 0 && len(s.events.publishers) == 0 {
+		return
+	}
+	event.Time = time.Now().UTC()
+
+	for _, hook := range s.events.hooks {
+		if hook.wants(event.Event) {
+			s.send(hook, event)
+		}
+	}
+	for _, publisher := range s.events.publishers {
+		s.events.deliveries.Add(1)
+		go func() {
+			defer s.events.deliveries.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+			defer cancel()
+			if err := publisher.Publish(ctx, event); err != nil {
+				log.Printf("Publishing %s event failed: %v", event.Event, err)
+			}
+		}()
+	}
+}
+
+// notifyAuthFailure sends an auth_failure event for a rejected request,
+// with the username it tried, if any
+func (s *Server) notifyAuthFailure(r *http.Request) {
+	username, _, _ := r.BasicAuth()
+	s.notify(Event{Event: EventAuthFailure, User: username, Remote: remoteIP(r)})
+}
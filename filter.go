@@ -0,0 +1,192 @@
+package logapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFields are the fields tried, in order, for a record's time when
+// ?time_field= isn't given
+var timeFields = []string{"time", "timestamp", "ts", "@timestamp"}
+
+// recordFilter selects NDJSON records by field values and time
+type recordFilter struct {
+	fields    []string // dotted paths, like http.status
+	values    []string
+	timeField string
+	from, to  time.Time // from inclusive, to exclusive; zero for open
+}
+
+// isFiltered reports whether a download asks for only some records
+func isFiltered(query url.Values) bool {
+	return query.Has("field") || query.Has("from") || query.Has("to")
+}
+
+// parseFilter reads ?field=&value= pairs (repeatable; all must match),
+// ?from= and ?to= (RFC 3339 times or YYYY-MM-DD days) and ?time_field=
+func parseFilter(query url.Values) (*recordFilter, error) {
+	f := &recordFilter{
+		fields:    query["field"],
+		values:    query["value"],
+		timeField: query.Get("time_field"),
+	}
+	if len(f.fields) != len(f.values) {
+		return nil, fmt.Errorf("got %d field and %d value parameters; give one value for each field", len(f.fields), len(f.values))
+	}
+	for _, field := range f.fields {
+		if field == "" {
+			return nil, fmt.Errorf("field must not be empty")
+		}
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if f.from, err = parseFilterTime(v); err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if f.to, err = parseFilterTime(v); err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func parseFilterTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or a YYYY-MM-DD date", v)
+}
+
+// match reports whether a line is a JSON object that has every field
+// value and a time in range. Lines that aren't JSON objects never match.
+func (f *recordFilter) match(line []byte) bool {
+	var record map[string]any
+	if err := json.Unmarshal(line, &record); err != nil {
+		return false
+	}
+	for i, field := range f.fields {
+		v, ok := lookupField(record, field)
+		if !ok || formatValue(v) != f.values[i] {
+			return false
+		}
+	}
+	if f.from.IsZero() && f.to.IsZero() {
+		return true
+	}
+
+	var t time.Time
+	var ok bool
+	if f.timeField != "" {
+		t, ok = recordTime(record, f.timeField)
+	} else {
+		for _, field := range timeFields {
+			if t, ok = recordTime(record, field); ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return false
+	}
+	return (f.from.IsZero() || !t.Before(f.from)) && (f.to.IsZero() || t.Before(f.to))
+}
+
+// lookupField follows a dotted path through nested objects; a key that
+// itself contains dots is found too
+func lookupField(record map[string]any, path string) (any, bool) {
+	if v, ok := record[path]; ok {
+		return v, true
+	}
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		return nil, false
+	}
+	inner, ok := record[head].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return lookupField(inner, rest)
+}
+
+// formatValue renders a JSON value for comparison with ?value=: strings
+// as is, numbers without exponents, and true, false and null
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// recordTime reads a time field: an RFC 3339 string, or Unix seconds or,
+// for values too large to be seconds, milliseconds
+func recordTime(record map[string]any, field string) (time.Time, bool) {
+	v, ok := lookupField(record, field)
+	if !ok {
+		return time.Time{}, false
+	}
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case float64:
+		if v > 1e12 {
+			return time.UnixMilli(int64(v)), true
+		}
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// writeFiltered streams the lines of a file that f matches, as NDJSON
+func (s *Server) writeFiltered(w http.ResponseWriter, user, date, name string, f *recordFilter) {
+	date, prefix, _ := splitDate(date)
+	rc, err := s.store.Open(user, date, prefix+name)
+	if err != nil {
+		s.fileNotFound(w, user, date, err)
+		return
+	}
+	defer func() { _ = rc.Close() }()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	br := bufio.NewReaderSize(rc, 64<<10)
+	bw := bufio.NewWriterSize(w, 64<<10)
+	defer func() { _ = bw.Flush() }()
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && f.match(line) {
+			if _, werr := bw.Write(line); werr != nil {
+				return
+			}
+			if line[len(line)-1] != '\n' {
+				_ = bw.WriteByte('\n')
+			}
+		}
+		if err != nil {
+			// io.EOF, or a read error after the status was sent
+			return
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package logapi
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paperos-labs/logapi/tarfs"
+)
+
+// FSStorage is the default Storage: {root}/{user}/{YYYY-MM}/{name} for live
+// months, and {root}/{user}/{YYYY-MM}.tar.{compress} once archived (tarballs
+// in the other formats are still found and served)
+type FSStorage struct {
+	root         string
+	compress     string
+	tars         *tarCache
+	archiveKey   []byte // master key for per-user archive keys
+	skipVerify   bool
+	compressOpts []tarfs.Option
+	tarPaths     sync.Map // tarCacheKey -> tarball found in another format
+	textIndex    bool
+	orgs         *Orgs
+	blobs        bool                   // content-addressed under .blobs; see SetDedupBlobs
+	versions     func(user string) bool // see SetKeepVersions
+	sync         bool                   // see SetSync
+	cold         ColdStore              // see SetColdStore
+	thawLock     sync.Mutex             // held while a tarball is fetched from cold
+}
+
+var (
+	_ Storage      = (*FSStorage)(nil)
+	_ Hasher       = (*FSStorage)(nil)
+	_ Deduper      = (*FSStorage)(nil)
+	_ Creator      = (*FSStorage)(nil)
+	_ FirstCreator = (*FSStorage)(nil)
+	_ ZstdOpener   = (*FSStorage)(nil)
+	_ Versioner    = (*FSStorage)(nil)
+	_ Tierer       = (*FSStorage)(nil)
+)
+
+// NewFSStorage stores files under root, archiving with compress (zst, gz or xz)
+func NewFSStorage(root, compress string) *FSStorage {
+	return &FSStorage{
+		root:     root,
+		compress: compress,
+		tars:     newTarCache(defaultTarCacheEntries, defaultTarCacheTTL, tarfs.NewEntryCache(defaultEntryCacheBytes, defaultEntryCacheMax)),
+	}
+}
+
+// SetTarCache limits how many tarball indexes are kept (default 64) and for
+// how long (default 10 minutes). Zero means no limit. Call it before use.
+func (fsys *FSStorage) SetTarCache(maxEntries int, ttl time.Duration) {
+	fsys.tars = newTarCache(maxEntries, ttl, fsys.tars.extracted)
+}
+
+// SetArchiveKey encrypts tarballs written from now on with a key derived
+// for each user from a 32-byte master key, so one user's key opens no one
+// else's archives. Unencrypted tarballs stay readable. Call it before use.
+func (fsys *FSStorage) SetArchiveKey(master []byte) error {
+	if len(master) != 32 {
+		return fmt.Errorf("archive master key must be 32 bytes, not %d", len(master))
+	}
+	fsys.archiveKey = master
+	return nil
+}
+
+// SetVerifyArchives turns off (or back on) reading each new tarball back
+// and comparing it with the month's files before Archive removes them. A
+// month that doesn't match is left live and Archive returns the error. Call
+// it before use.
+func (fsys *FSStorage) SetVerifyArchives(verify bool) {
+	fsys.skipVerify = !verify
+}
+
+// SetSync fsyncs each file Put or appended to, and each new tarball, with
+// the directory it's renamed into, before it counts as stored, so
+// acknowledged uploads survive a power loss. It costs throughput. Call it
+// before use.
+func (fsys *FSStorage) SetSync(on bool) {
+	fsys.sync = on
+}
+
+// syncDirs fsyncs dir, so a rename into it is durable, and if it was just
+// created, its parents up to the root, so it is too
+func (fsys *FSStorage) syncDirs(dir string, created bool) error {
+	for {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if !created || dir == filepath.Clean(fsys.root) || parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// syncDir fsyncs dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+// SetCompressOptions sets how tarballs are compressed, with tarfs.WithLevel,
+// WithZstdConcurrency and WithZstdWindow. Call it before use.
+func (fsys *FSStorage) SetCompressOptions(opts ...tarfs.Option) error {
+	if err := tarfs.CheckOptions(fsys.compress, opts...); err != nil {
+		return err
+	}
+	fsys.compressOpts = opts
+	return nil
+}
+
+// archiveOptions returns the tarfs options for a user's tarballs
+func (fsys *FSStorage) archiveOptions(user string) ([]tarfs.Option, error) {
+	opts := slices.Clone(fsys.compressOpts)
+	if fsys.skipVerify {
+		opts = append(opts, tarfs.SkipVerify())
+	}
+	if fsys.sync {
+		opts = append(opts, tarfs.WithSync())
+	}
+	if fsys.archiveKey == nil {
+		return opts, nil
+	}
+	key, err := hkdf.Key(sha256.New, fsys.archiveKey, nil, "logapi archive "+user, 32)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, tarfs.WithKey(key)), nil
+}
+
+// SetEntryCache sets how many bytes of small archived files (up to 1 MiB
+// each) are kept in memory after being read, 32 MiB by default. Zero turns
+// the cache off. Call it before use.
+func (fsys *FSStorage) SetEntryCache(maxBytes int64) {
+	var extracted *tarfs.EntryCache
+	if maxBytes > 0 {
+		extracted = tarfs.NewEntryCache(maxBytes, defaultEntryCacheMax)
+	}
+	fsys.tars = newTarCache(fsys.tars.maxEntries, fsys.tars.ttl, extracted)
+}
+
+// filePath checks a file's user, month and name before joining them, so a
+// bad name can reach neither the filesystem nor a tarball lookup
+func (fsys *FSStorage) filePath(user, date, name string) (string, error) {
+	if err := checkSegment(user); err != nil {
+		return "", fmt.Errorf("%w: user %q: %v", fs.ErrInvalid, user, err)
+	}
+	if err := checkSegment(date); err != nil {
+		return "", fmt.Errorf("%w: month %q: %v", fs.ErrInvalid, date, err)
+	}
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(fsys.userDir(user), date, name), nil
+}
+
+// Put writes the file via a temp file and rename
+func (fsys *FSStorage) Put(user, date, name string, body io.Reader) error {
+	_, err := fsys.PutIfChanged(user, date, name, body)
+	return err
+}
+
+func (fsys *FSStorage) Open(user, date, name string) (io.ReadCloser, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(filePath); err == nil {
+		return f, nil
+	}
+
+	tfs, err := fsys.loadTarFS(user, date)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := tfs.Get(filepath.Join(date, name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return r, nil
+}
+
+// OpenZstd reads an archived file's own zstd frames; a live file, which
+// isn't compressed, fails with errors.ErrUnsupported
+func (fsys *FSStorage) OpenZstd(user, date, name string) (io.ReadCloser, int64, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		return nil, 0, fmt.Errorf("%w: %s/%s is live", errors.ErrUnsupported, date, name)
+	}
+
+	tfs, err := fsys.loadTarFS(user, date)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tfs.GetZstd(filepath.Join(date, name))
+}
+
+func (fsys *FSStorage) Stat(user, date, name string) (FileInfo, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
+		return FileInfo{Month: date, Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	tfs, err := fsys.loadTarFS(user, date)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	entryPath := filepath.Join(date, name)
+	size, ok := tfs.EntrySize(entryPath)
+	if !ok {
+		return FileInfo{}, fmt.Errorf("%w: file %s not found", fs.ErrNotExist, entryPath)
+	}
+	modTime, _ := tfs.EntryModTime(entryPath)
+	return FileInfo{Month: date, Name: name, Size: size, ModTime: modTime, Archived: true}, nil
+}
+
+// SHA256 hashes live files on each call; archived entries are cached
+func (fsys *FSStorage) SHA256(user, date, name string) (string, error) {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return "", err
+	}
+	if f, err := os.Open(filePath); err == nil {
+		defer func() { _ = f.Close() }()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	tfs, err := fsys.loadTarFS(user, date)
+	if err != nil {
+		return "", err
+	}
+	return tfs.EntrySHA256(filepath.Join(date, name))
+}
+
+func (fsys *FSStorage) List(user, date string) ([]FileInfo, error) {
+	files := make(map[string]FileInfo)
+
+	datePath := filepath.Join(fsys.userDir(user), date)
+	_, liveErr := os.Stat(datePath)
+	tfs, archiveErr := fsys.loadTarFS(user, date)
+	if errors.Is(archiveErr, ErrRestoreRequired) {
+		return nil, archiveErr
+	}
+	if liveErr != nil && archiveErr != nil {
+		return nil, fmt.Errorf("%w: %s/%s", fs.ErrNotExist, user, date)
+	}
+
+	if archiveErr == nil {
+		for _, path := range tfs.EntryPaths() {
+			size, _ := tfs.EntrySize(path)
+			modTime, _ := tfs.EntryModTime(path)
+			name := strings.TrimPrefix(path, date+"/")
+			files[name] = FileInfo{Month: date, Name: name, Size: size, ModTime: modTime, Archived: true}
+		}
+	}
+	if liveErr == nil {
+		// days and other subdirectories are listed as "DD/name"
+		err := filepath.WalkDir(datePath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(datePath, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			files[name] = FileInfo{Month: date, Name: name, Size: info.Size(), ModTime: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]FileInfo, 0, len(files))
+	for _, file := range files {
+		results = append(results, file)
+	}
+	slices.SortFunc(results, func(a, b FileInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return results, nil
+}
+
+func (fsys *FSStorage) Months(user string) ([]Month, error) {
+	entries, err := os.ReadDir(fsys.userDir(user))
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Month)
+	local := make(map[string]bool) // months with a tarball on disk
+	month := func(name string) *Month {
+		if byName[name] == nil {
+			byName[name] = &Month{Name: name}
+		}
+		return byName[name]
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			if _, err := time.Parse("2006-01", name); err != nil {
+				continue
+			}
+			month(name).Live = true
+			continue
+		}
+
+		date, format, ok := strings.Cut(name, ".tar.")
+		format, cold := strings.CutSuffix(format, coldSuffix)
+		if !ok || !slices.Contains(archiveFormats, format) {
+			continue
+		}
+		if _, err := time.Parse("2006-01", date); err != nil {
+			continue
+		}
+		m := month(date)
+		m.Archived = true
+		m.Cold = m.Cold || cold
+		local[date] = local[date] || !cold
+	}
+
+	months := make([]Month, 0, len(byName))
+	for _, m := range byName {
+		m.Cold = m.Cold && !local[m.Name]
+		months = append(months, *m)
+	}
+	slices.SortFunc(months, func(a, b Month) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return months, nil
+}
+
+func (fsys *FSStorage) Users() ([]string, error) {
+	entries, err := os.ReadDir(fsys.root)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue // .blobs
+		}
+		if !fsys.orgs.IsOrg(name) {
+			if fsys.orgs.Org(name) == "" {
+				users = append(users, name)
+			}
+			continue
+		}
+		members, err := os.ReadDir(filepath.Join(fsys.root, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.IsDir() && fsys.orgs.Org(member.Name()) == name {
+				users = append(users, member.Name())
+			}
+		}
+	}
+	slices.Sort(users)
+	return users, nil
+}
+
+func (fsys *FSStorage) Remove(user, date, name string) error {
+	filePath, err := fsys.filePath(user, date, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if tfs, err := fsys.loadTarFS(user, date); err == nil {
+		if _, ok := tfs.EntrySize(filepath.Join(date, name)); ok {
+			return ErrArchived
+		}
+	}
+	return err
+}
+
+func (fsys *FSStorage) RemoveMonth(user, date string) ([]string, error) {
+	var removed []string
+	datePath := filepath.Join(fsys.userDir(user), date)
+	if _, err := os.Stat(datePath); err == nil {
+		if err := os.RemoveAll(datePath); err != nil {
+			return removed, err
+		}
+		removed = append(removed, date)
+	}
+
+	for _, format := range archiveFormats {
+		tarName := date + ".tar." + format
+		if err := os.Remove(filepath.Join(fsys.userDir(user), tarName)); err == nil {
+			removed = append(removed, tarName)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		if err := fsys.removeCold(filepath.Join(fsys.userDir(user), tarName+coldSuffix)); err == nil {
+			removed = append(removed, tarName+coldSuffix)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+	}
+
+	if err := os.Remove(fsys.indexPath(user, date)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return removed, err
+	}
+	if err := os.RemoveAll(filepath.Join(fsys.userDir(user), versionsDir, date)); err != nil {
+		return removed, err
+	}
+	fsys.tars.remove(tarCacheKey{user, date})
+	fsys.tarPaths.Delete(tarCacheKey{user, date})
+
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("%w: %s/%s", fs.ErrNotExist, user, date)
+	}
+	return removed, nil
+}
+
+func (fsys *FSStorage) Archive(user, date string) (string, error) {
+	userPath := fsys.userDir(user)
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return "", err
+	}
+	tarPath := filepath.Join(userPath, date+".tar."+fsys.compress)
+	// late files for a cold month are merged into its tarball fetched back
+	if _, ok := fsys.coldStubPath(user, date); ok {
+		if _, err := fsys.localTarPath(user, date); err != nil {
+			return "", err
+		}
+	}
+	// late files for a month archived in another format convert it first,
+	// so the month stays in one tarball
+	if found, ok := fsys.tarPath(user, date); ok && found != tarPath {
+		if err := tarfs.Convert(found, tarPath, opts...); err != nil {
+			return "", err
+		}
+		fsys.tarPaths.Delete(tarCacheKey{user, date})
+		if err := fsys.removeColdCopy(found); err != nil {
+			return "", err
+		}
+	}
+	if err := tarfs.CompressAndRemove(userPath, date, fsys.compress, opts...); err != nil {
+		return "", err
+	}
+	if fsys.textIndex && fsys.archiveKey == nil {
+		if err := fsys.writeIndex(user, date, tarPath); err != nil {
+			return tarPath, fmt.Errorf("%s archived, but not indexed: %w", tarPath, err)
+		}
+	}
+	return tarPath, nil
+}
+
+// VerifyArchive reads a month's whole tarball with tarfs.Verify. Cold
+// months aren't fetched for it; they're checked against their SHA-256 when
+// they are.
+func (fsys *FSStorage) VerifyArchive(user, date string) error {
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return err
+	}
+	tarPath, ok := fsys.tarPath(user, date)
+	if !ok {
+		if _, cold := fsys.coldStubPath(user, date); cold {
+			return nil
+		}
+		return fmt.Errorf("%w: %s/%s has no tarball", fs.ErrNotExist, user, date)
+	}
+	_, err = tarfs.Verify(tarPath, opts...)
+	return err
+}
+
+// Repack rewrites a month's tarball with tarfs.Repack. Volumes are written
+// next to it, as {YYYY-MM}.001.tar.{compress} and so on, for shipping
+// elsewhere; the month is still served from the single tarball.
+func (fsys *FSStorage) Repack(user, date string, maxVolume int64) ([]string, error) {
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return nil, err
+	}
+	tarPath, err := fsys.localTarPath(user, date)
+	if err != nil {
+		return nil, err
+	}
+	return tarfs.Repack(tarPath, maxVolume, opts...)
+}
+
+// archiveFormats are the tarball extensions read, whatever compress is
+var archiveFormats = []string{"zst", "gz", "bz2", "xz", "br", "lz4"}
+
+// ConvertArchives rewrites a user's tarballs in formats other than compress,
+// such as .tar.gz ones left from before --compress changed, as
+// {YYYY-MM}.tar.{compress} with tarfs.Convert, merging into any tarball the
+// month already has in that format. Older files are converted first, so
+// newer ones win. It returns the tarballs written.
+func (fsys *FSStorage) ConvertArchives(user string) ([]string, error) {
+	userPath := fsys.userDir(user)
+	entries, err := os.ReadDir(userPath)
+	if err != nil {
+		return nil, err
+	}
+	type foreign struct {
+		date    string
+		path    string
+		modTime time.Time
+	}
+	var found []foreign
+	for _, entry := range entries {
+		date, format, ok := strings.Cut(entry.Name(), ".tar.")
+		if !ok || entry.IsDir() || format == fsys.compress || !slices.Contains(archiveFormats, format) {
+			continue
+		}
+		if _, err := time.Parse("2006-01", date); err != nil {
+			continue // volumes, like 2025-07.001.tar.gz, aren't served
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, foreign{date, filepath.Join(userPath, entry.Name()), info.ModTime()})
+	}
+	slices.SortFunc(found, func(a, b foreign) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, f := range found {
+		dstPath := filepath.Join(userPath, f.date+".tar."+fsys.compress)
+		if err := tarfs.Convert(f.path, dstPath, opts...); err != nil {
+			return written, err
+		}
+		if err := fsys.removeColdCopy(f.path); err != nil {
+			return written, err
+		}
+		written = append(written, dstPath)
+	}
+	return written, nil
+}
+
+// Warmup indexes archived months, newest first, stopping once the tarball
+// cache is full so warming up doesn't evict its own work. Cold months are
+// left until they're read.
+func (fsys *FSStorage) Warmup(workers int, progress func(done, total int)) error {
+	users, err := fsys.Users()
+	if err != nil {
+		return err
+	}
+	var archived []tarCacheKey
+	for _, user := range users {
+		months, err := fsys.Months(user)
+		if err != nil {
+			return err
+		}
+		for _, month := range months {
+			if month.Archived && !month.Cold {
+				archived = append(archived, tarCacheKey{user, month.Name})
+			}
+		}
+	}
+	slices.SortFunc(archived, func(a, b tarCacheKey) int {
+		return strings.Compare(b.date, a.date)
+	})
+	if limit := fsys.tars.maxEntries; limit > 0 && len(archived) > limit {
+		archived = archived[:limit]
+	}
+
+	keys := make(chan tarCacheKey)
+	var (
+		wg      sync.WaitGroup
+		done    atomic.Int64
+		errLock sync.Mutex
+		errs    []error
+	)
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				if _, err := fsys.loadTarFS(key.user, key.date); err != nil {
+					errLock.Lock()
+					errs = append(errs, fmt.Errorf("%s/%s: %w", key.user, key.date, err))
+					errLock.Unlock()
+				}
+				n := done.Add(1)
+				if progress != nil {
+					progress(int(n), len(archived))
+				}
+			}
+		}()
+	}
+	for _, key := range archived {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// loadTarFS returns the cached index for a month's tarball, indexing it on
+// first use, and fetching it first if it's cold
+func (fsys *FSStorage) loadTarFS(user, date string) (*tarfs.TarFS, error) {
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return nil, err
+	}
+	tarPath, ok := fsys.tarPath(user, date)
+	if !ok && fsys.cold != nil {
+		if _, cold := fsys.coldStubPath(user, date); cold {
+			if tarPath, err = fsys.thaw(user, date); err != nil {
+				return nil, err
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		// for the usual not-found error
+		tarPath = filepath.Join(fsys.userDir(user), date+".tar."+fsys.compress)
+	}
+	return fsys.tars.get(tarCacheKey{user, date}, tarPath, opts...)
+}
+
+// tarPath finds a month's tarball: {date}.tar.{compress} or, failing that,
+// one in another format, left from before compress changed. Those are
+// remembered until the file goes away, so they cost one stat like the rest.
+func (fsys *FSStorage) tarPath(user, date string) (string, bool) {
+	key := tarCacheKey{user, date}
+	tarPath := filepath.Join(fsys.userDir(user), date+".tar."+fsys.compress)
+	if _, err := os.Stat(tarPath); err == nil {
+		return tarPath, true
+	}
+	if cached, ok := fsys.tarPaths.Load(key); ok {
+		if _, err := os.Stat(cached.(string)); err == nil {
+			return cached.(string), true
+		}
+		fsys.tarPaths.Delete(key)
+	}
+	for _, format := range archiveFormats {
+		if format == fsys.compress {
+			continue
+		}
+		tarPath := filepath.Join(fsys.userDir(user), date+".tar."+format)
+		if _, err := os.Stat(tarPath); err == nil {
+			fsys.tarPaths.Store(key, tarPath)
+			return tarPath, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,321 @@
+package logapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	grantsFile      = ".grants.json"
+	defaultGrantTTL = 24 * time.Hour
+)
+
+// Grant lets another account read a user's files: every month, or one,
+// and every file, or those whose names start with Prefix
+type Grant struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Grantee   string    `json:"grantee"`
+	Date      string    `json:"date,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	Expires   time.Time `json:"expires"`
+}
+
+// GrantRequest represents the POST /api/logs/{user}/grants JSON body
+type GrantRequest struct {
+	Grantee   string `json:"grantee"`
+	Date      string `json:"date"`
+	Prefix    string `json:"prefix"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// loadGrants reads persisted grants, if any
+func loadGrants(path string) (map[string]Grant, error) {
+	grants := make(map[string]Grant)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return grants, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Grant
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid %q format: %w", path, err)
+	}
+	for _, grant := range list {
+		grants[grant.ID] = grant
+	}
+	return grants, nil
+}
+
+// saveGrants persists the unexpired grants; grantsLock must be held
+func (s *Server) saveGrants() error {
+	now := time.Now()
+	list := []Grant{}
+	for id, grant := range s.grants {
+		if now.After(grant.Expires) {
+			delete(s.grants, id)
+			continue
+		}
+		list = append(list, grant)
+	}
+	slices.SortFunc(list, func(a, b Grant) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.storage, grantsFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// grantedPrefixes returns the name prefixes that username's unexpired
+// grants allow of user's date (YYYY-MM or YYYY-MM-DD), or nil if none do.
+// A grant without a prefix shows up as "".
+func (s *Server) grantedPrefixes(username, user, date string) []string {
+	month := date[:min(len(date), len("2006-01"))]
+	now := time.Now()
+
+	s.grantsLock.RLock()
+	defer s.grantsLock.RUnlock()
+	var prefixes []string
+	for _, grant := range s.grants {
+		if grant.Grantee != username || grant.User != user || now.After(grant.Expires) {
+			continue
+		}
+		if grant.Date != "" && grant.Date != month {
+			continue
+		}
+		if grant.Prefix == "" {
+			return []string{""}
+		}
+		prefixes = append(prefixes, grant.Prefix)
+	}
+	return prefixes
+}
+
+// granted reports whether a month-relative file name starts with one of
+// a grant's prefixes
+func granted(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateGrant lets another account read some of a user's files, until it
+// expires. Users grant access to their own files; admins to anyone's.
+func (s *Server) CreateGrant(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.canAccess(username, user) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only grant access to your own files")
+		return
+	}
+	if !s.validUser(w, user) {
+		return
+	}
+
+	var req GrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+		return
+	}
+	if err := checkSegment(req.Grantee); err != nil || req.Grantee == user {
+		s.jsonError(w, http.StatusBadRequest, "invalid_grantee", "Invalid grantee", fmt.Sprintf("grantee must be another user's name, not %q", req.Grantee))
+		return
+	}
+	if req.Date != "" {
+		if _, err := time.Parse("2006-01", req.Date); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", fmt.Sprintf("%q is not a YYYY-MM month", req.Date))
+			return
+		}
+	}
+	if req.Prefix != "" {
+		if err := checkName(strings.TrimSuffix(req.Prefix, "/")); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_name", "Invalid file name", err.Error())
+			return
+		}
+	}
+
+	ttl := defaultGrantTTL
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			s.jsonError(w, http.StatusBadRequest, "invalid_expires_in", "Invalid expiration", "expires_in must be a positive duration such as 72h")
+			return
+		}
+	}
+
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	grant := Grant{
+		ID:        hex.EncodeToString(id),
+		User:      user,
+		Grantee:   req.Grantee,
+		Date:      req.Date,
+		Prefix:    req.Prefix,
+		CreatedBy: username,
+		Expires:   time.Now().UTC().Add(ttl).Truncate(time.Second),
+	}
+
+	s.grantsLock.Lock()
+	s.grants[grant.ID] = grant
+	err := s.saveGrants()
+	s.grantsLock.Unlock()
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	s.audit("grant_created",
+		slog.String("id", grant.ID),
+		slog.String("user", user),
+		slog.String("grantee", grant.Grantee),
+		slog.String("date", grant.Date),
+		slog.String("prefix", grant.Prefix),
+		slog.String("by", username),
+		slog.Time("expires", grant.Expires),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(grant)
+}
+
+// ListGrants lists the unexpired grants of a user's files
+func (s *Server) ListGrants(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.canAccess(username, user) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files")
+		return
+	}
+	if !s.validUser(w, user) {
+		return
+	}
+
+	s.writeGrants(w, func(grant Grant) bool { return grant.User == user })
+}
+
+// ListHeldGrants lists the unexpired grants given to the caller
+func (s *Server) ListHeldGrants(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	s.writeGrants(w, func(grant Grant) bool { return grant.Grantee == username })
+}
+
+// writeGrants writes the unexpired grants that match, by user then id
+func (s *Server) writeGrants(w http.ResponseWriter, match func(Grant) bool) {
+	now := time.Now()
+	results := []Grant{}
+	s.grantsLock.RLock()
+	for _, grant := range s.grants {
+		if match(grant) && !now.After(grant.Expires) {
+			results = append(results, grant)
+		}
+	}
+	s.grantsLock.RUnlock()
+	slices.SortFunc(results, func(a, b Grant) int {
+		if c := strings.Compare(a.User, b.User); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results": results,
+	})
+}
+
+// RevokeGrant removes a grant of a user's files, by the user or an admin
+func (s *Server) RevokeGrant(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.canAccess(username, user) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only revoke grants of your own files")
+		return
+	}
+
+	id := r.PathValue("id")
+	s.grantsLock.Lock()
+	defer s.grantsLock.Unlock()
+	grant, ok := s.grants[id]
+	if !ok || grant.User != user {
+		s.jsonError(w, http.StatusNotFound, "grant_not_found", "Grant not found", "No such grant")
+		return
+	}
+
+	delete(s.grants, id)
+	if err := s.saveGrants(); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	s.audit("grant_revoked",
+		slog.String("id", id),
+		slog.String("user", user),
+		slog.String("grantee", grant.Grantee),
+		slog.String("by", username),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"message": fmt.Sprintf("Grant revoked: %s for %s", id, grant.Grantee),
+	})
+}
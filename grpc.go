@@ -0,0 +1,234 @@
+package logapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/paperos-labs/logapi/logapipb"
+)
+
+// RegisterGRPC adds the LogAPI gRPC service to gs. Each call is handled by
+// the same route as its HTTP request, so authentication, roles, grants,
+// quotas and storage behave the same.
+func (s *Server) RegisterGRPC(gs *grpc.Server) {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	logapipb.RegisterLogAPIServer(gs, &grpcService{mux: mux})
+}
+
+// grpcService answers gRPC calls by serving the equivalent HTTP requests
+type grpcService struct {
+	logapipb.UnimplementedLogAPIServer
+	mux *http.ServeMux
+}
+
+func (g *grpcService) Upload(stream grpc.ClientStreamingServer[logapipb.UploadRequest, logapipb.UploadResponse]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	r := grpcRequest(stream.Context(), http.MethodPost, "/api/logs", &uploadBody{stream: stream, data: first.GetData()})
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set("X-File-Date", first.GetDate())
+	r.Header.Set("X-File-Name", first.GetName())
+	if first.GetNoOverwrite() {
+		r.Header.Set("X-No-Overwrite", "true")
+	}
+	resp := &grpcResponse{header: http.Header{}}
+	g.mux.ServeHTTP(resp, r)
+	if err := resp.err(); err != nil {
+		return err
+	}
+
+	var result struct {
+		Message   string `json:"message"`
+		Unchanged bool   `json:"unchanged"`
+	}
+	_ = json.Unmarshal(resp.body.Bytes(), &result)
+	return stream.SendAndClose(&logapipb.UploadResponse{Message: result.Message, Unchanged: result.Unchanged})
+}
+
+func (g *grpcService) Get(req *logapipb.GetRequest, stream grpc.ServerStreamingServer[logapipb.GetResponse]) error {
+	if req.GetName() == "" || strings.HasSuffix(req.GetName(), "/") {
+		return status.Error(codes.InvalidArgument, "name must be a file, use List for directories")
+	}
+	r := grpcRequest(stream.Context(), http.MethodGet, logsPath(req.GetUser(), req.GetDate(), req.GetName()), nil)
+	resp := &grpcResponse{header: http.Header{}, send: func(p []byte) error {
+		return stream.Send(&logapipb.GetResponse{Data: bytes.Clone(p)})
+	}}
+	g.mux.ServeHTTP(resp, r)
+	if resp.sendErr != nil {
+		return resp.sendErr
+	}
+	return resp.err()
+}
+
+func (g *grpcService) List(ctx context.Context, req *logapipb.ListRequest) (*logapipb.ListResponse, error) {
+	r := grpcRequest(ctx, http.MethodGet, logsPath(req.GetUser(), req.GetDate(), ""), nil)
+	resp := &grpcResponse{header: http.Header{}}
+	g.mux.ServeHTTP(resp, r)
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+
+	if req.GetDate() == "" {
+		var result struct {
+			Results []MonthInfo `json:"results"`
+		}
+		if err := json.Unmarshal(resp.body.Bytes(), &result); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		months := make([]string, len(result.Results))
+		for i, month := range result.Results {
+			months[i] = month.Month
+		}
+		return &logapipb.ListResponse{Results: months}, nil
+	}
+
+	var result struct {
+		Results []string `json:"results"`
+	}
+	if err := json.Unmarshal(resp.body.Bytes(), &result); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &logapipb.ListResponse{Results: result.Results}, nil
+}
+
+// uploadBody reads the data of an Upload's messages
+type uploadBody struct {
+	stream grpc.ClientStreamingServer[logapipb.UploadRequest, logapipb.UploadResponse]
+	data   []byte
+}
+
+func (ub *uploadBody) Read(p []byte) (int, error) {
+	for len(ub.data) == 0 {
+		msg, err := ub.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		ub.data = msg.GetData()
+	}
+	n := copy(p, ub.data)
+	ub.data = ub.data[n:]
+	return n, nil
+}
+
+// logsPath is the /api/logs path of a user, month or file
+func logsPath(user, date, name string) string {
+	segments := []string{"api", "logs", user}
+	if date != "" {
+		segments = append(segments, date)
+	}
+	if name != "" {
+		segments = append(segments, strings.Split(name, "/")...)
+	}
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// grpcRequest builds the HTTP request for a call, with its credentials
+// from the "authorization" metadata or the peer's TLS client certificate
+func grpcRequest(ctx context.Context, method, path string, body io.Reader) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, method, path, body)
+	if body != nil {
+		r.ContentLength = -1
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, name := range []string{"authorization", "x-request-id"} {
+		if values := md.Get(name); len(values) > 0 {
+			r.Header.Set(name, values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &tlsInfo.State
+		}
+	}
+	return r
+}
+
+// grpcResponse records the status and headers of an HTTP response, passing
+// a successful body to send if there is one, and keeping it otherwise
+type grpcResponse struct {
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	send    func([]byte) error
+	sendErr error
+}
+
+func (gr *grpcResponse) Header() http.Header {
+	return gr.header
+}
+
+func (gr *grpcResponse) WriteHeader(status int) {
+	if gr.status == 0 {
+		gr.status = status
+	}
+}
+
+func (gr *grpcResponse) Write(p []byte) (int, error) {
+	if gr.status == 0 {
+		gr.status = http.StatusOK
+	}
+	if gr.send == nil || gr.status >= 300 {
+		return gr.body.Write(p)
+	}
+	if gr.sendErr == nil {
+		gr.sendErr = gr.send(p)
+	}
+	if gr.sendErr != nil {
+		return 0, gr.sendErr
+	}
+	return len(p), nil
+}
+
+// err converts an HTTP error response, and its JSON error, to a gRPC status
+func (gr *grpcResponse) err() error {
+	if gr.status < 300 {
+		return nil
+	}
+	var jsonErr JSONError
+	msg := http.StatusText(gr.status)
+	if json.Unmarshal(gr.body.Bytes(), &jsonErr) == nil && jsonErr.Code != "" {
+		msg = jsonErr.Code + ": " + jsonErr.Detail
+	}
+
+	code := codes.Internal
+	switch gr.status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, msg)
+}
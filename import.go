@@ -0,0 +1,229 @@
+package logapi
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// errMonthExists is returned by storeImport for a month with files
+var errMonthExists = errors.New("month exists")
+
+// ImportMonth handles PUT /api/logs/{user}/{date}/archive: a month's files
+// as a tar stream, plain, gzip or zstd (by Content-Encoding, or as sent),
+// for moving history in a month at a time. Entries may be named as in the
+// month or under a {YYYY-MM}/ directory, as in logapi's own tarballs. The
+// whole stream is read and checked before any of it is stored, then the
+// month is archived, or with ?live=true left live. The month mustn't have
+// any files yet. Admin only.
+func (s *Server) ImportMonth(w http.ResponseWriter, r *http.Request) {
+	s.uploads.Add(1)
+	defer s.uploads.Done()
+
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	user := r.PathValue("user")
+	date := r.PathValue("date")
+	if !s.validUser(w, user) {
+		return
+	}
+	if _, err := time.Parse("2006-01", date); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", "Date must be YYYY-MM")
+		return
+	}
+	live := r.URL.Query().Get("live") == "true"
+	thisMonth := time.Now().UTC().Format("2006-01")
+	if date > thisMonth || (date == thisMonth && !live) {
+		s.jsonError(w, http.StatusBadRequest, "date_out_of_range", "Date out of range", fmt.Sprintf("Months up to %s can be imported, and %s itself with ?live=true", thisMonth, thisMonth))
+		return
+	}
+	if s.monthExists(user, date) {
+		s.monthExistsError(w, user, date)
+		return
+	}
+
+	if !s.acquireUpload(w) {
+		return
+	}
+	defer s.releaseUpload()
+	if _, ok := s.decodeUpload(w, r, true); !ok {
+		return
+	}
+	body, err := decodeSniffed(r.Body)
+	if err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_encoding", "Invalid compressed body", err.Error())
+		return
+	}
+	defer func() { _ = body.Close() }()
+
+	// unpack it all before storing any of it
+	parent := filepath.Join(s.userDir(user), stagingDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	importDir, err := os.MkdirTemp(parent, "import-")
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	defer func() { _ = os.RemoveAll(importDir) }()
+
+	var names []string
+	tr := tar.NewReader(body)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid tar body", err.Error())
+			return
+		}
+		// directories, links and the like aren't log files
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		name = strings.TrimPrefix(name, date+"/")
+		if err := checkName(name); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_name", "Invalid file name", err.Error())
+			return
+		}
+		if !s.allowedName(w, name) {
+			return
+		}
+		if err := saveUpload(importDir, name, tr); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid tar body", err.Error())
+			return
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	if len(names) == 0 {
+		s.jsonError(w, http.StatusBadRequest, "no_files", "No files", "The tar contained no files")
+		return
+	}
+
+	err = s.storeImport(user, date, importDir, names)
+	if errors.Is(err, errMonthExists) {
+		s.monthExistsError(w, user, date)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "write_failed", "Failed to write file", err.Error())
+		return
+	}
+	var tarball string
+	if !live {
+		if tarball, err = s.store.Archive(user, date); err != nil {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", fmt.Sprintf("Files stored live, but not archived: %v", err))
+			return
+		}
+	}
+	s.audit("month_imported",
+		slog.String("user", user),
+		slog.String("date", date),
+		slog.Int("files", len(names)),
+		slog.String("tarball", tarball),
+		slog.String("by", username),
+	)
+
+	results := make([]string, len(names))
+	for i, name := range names {
+		results[i] = date + "/" + name
+	}
+	message := fmt.Sprintf("Month imported and archived: %s/%s", user, date)
+	if live {
+		message = fmt.Sprintf("Month imported: %s/%s", user, date)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": message,
+		"results": results,
+	})
+}
+
+// storeImport puts an unpacked import's files into the month, all at once
+// as far as listings can tell. If one fails the month is removed again.
+func (s *Server) storeImport(user, date, importDir string, names []string) error {
+	s.commitLock.Lock()
+	defer s.commitLock.Unlock()
+
+	if s.monthExists(user, date) {
+		return errMonthExists
+	}
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(importDir, name))
+		if err == nil {
+			err = s.store.Put(user, date, name, f)
+			_ = f.Close()
+		}
+		if err != nil {
+			_, _ = s.store.RemoveMonth(user, date)
+			return fmt.Errorf("%s/%s: %w", date, name, err)
+		}
+	}
+	return nil
+}
+
+// monthExists reports whether a user has any files, live or archived, in
+// a month
+func (s *Server) monthExists(user, date string) bool {
+	months, _ := s.store.Months(user)
+	return slices.ContainsFunc(months, func(month Month) bool {
+		return month.Name == date
+	})
+}
+
+// monthExistsError writes the 409 for an import into a month with files
+func (s *Server) monthExistsError(w http.ResponseWriter, user, date string) {
+	s.jsonError(w, http.StatusConflict, "month_exists", "Month exists", fmt.Sprintf("%s/%s has files already; delete the month to import it again", user, date))
+}
+
+// decodeSniffed decompresses a body that's gzip or zstd by its magic
+// number, for compressed tarballs sent without a Content-Encoding
+func decodeSniffed(body io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
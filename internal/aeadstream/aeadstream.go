@@ -0,0 +1,256 @@
+// Package aeadstream seals streams with AES-256-GCM, for encrypted files
+// and archives. A sealed stream is a 4-byte magic, a random stream key
+// sealed with the caller's key, then the plaintext in 64 KiB chunks. The
+// last chunk is always short (possibly empty) and flagged in its nonce, so
+// chunks can't be reordered or dropped and truncation is detected.
+package aeadstream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// MagicSize is the length of a stream's magic
+	MagicSize = 4
+	// KeySize is the length of keys, the caller's and each stream's
+	KeySize   = 32
+	chunkSize = 64 * 1024
+	nonceSize = 12
+	tagSize   = 16
+
+	// HeaderSize is the length of a stream's magic and sealed key
+	HeaderSize  = MagicSize + nonceSize + KeySize + tagSize
+	sealedChunk = chunkSize + tagSize
+)
+
+// Sealer seals and opens streams starting with its magic, whose keys are
+// sealed with its key
+type Sealer struct {
+	magic string
+	wrap  cipher.AEAD
+}
+
+// New returns a Sealer of streams starting with magic, under a 32-byte key
+func New(magic string, key []byte) (*Sealer, error) {
+	if len(magic) != MagicSize {
+		return nil, fmt.Errorf("magic must be %d bytes, not %d", MagicSize, len(magic))
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, not %d", KeySize, len(key))
+	}
+	wrap, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{magic: magic, wrap: wrap}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PlainSize derives the plaintext size from the sealed size. Every stream
+// ends with a short final chunk, so the chunk count is the sealed body size
+// rounded up to whole chunks.
+func PlainSize(size int64) int64 {
+	body := size - int64(HeaderSize)
+	if body <= 0 {
+		return 0
+	}
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	return body - chunks*tagSize
+}
+
+// chunkNonce is a big-endian chunk counter with the last byte flagging
+// the final chunk
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// header returns a new stream's header and the AEAD of its chunks
+func (s *Sealer) header() ([]byte, cipher.AEAD, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	header := append([]byte(s.magic), nonce...)
+	return s.wrap.Seal(header, nonce, key, []byte(s.magic)), aead, nil
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	plain   []byte
+	sealed  []byte
+	closed  bool
+}
+
+// NewWriter writes a header to w and returns a writer sealing to it. Close
+// seals the final chunk; it doesn't close w.
+func (s *Sealer) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	header, aead, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, plain: make([]byte, 0, chunkSize)}, nil
+}
+
+func (sw *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(sw.plain[len(sw.plain):chunkSize], p)
+		sw.plain = sw.plain[:len(sw.plain)+n]
+		p = p[n:]
+		written += n
+		// a full chunk is never the last one
+		if len(sw.plain) == chunkSize {
+			if err := sw.flushChunk(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (sw *writer) flushChunk(last bool) error {
+	sw.sealed = sw.aead.Seal(sw.sealed[:0], chunkNonce(sw.counter, last), sw.plain, nil)
+	sw.counter++
+	sw.plain = sw.plain[:0]
+	_, err := sw.w.Write(sw.sealed)
+	return err
+}
+
+func (sw *writer) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flushChunk(true)
+}
+
+type reader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte // sealed output not yet read
+	plain   []byte
+	done    bool
+}
+
+// NewReader returns a reader of the sealed form of src
+func (s *Sealer) NewReader(src io.Reader) (io.Reader, error) {
+	header, aead, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+	return &reader{src: src, aead: aead, buf: header, plain: make([]byte, chunkSize)}, nil
+}
+
+func (sr *reader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(sr.src, sr.plain)
+		last := false
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return 0, err
+		}
+		sr.buf = sr.aead.Seal(sr.buf[:0], chunkNonce(sr.counter, last), sr.plain[:n], nil)
+		sr.counter++
+		sr.done = last
+	}
+
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+type opener struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte // opened plaintext not yet read
+	sealed  []byte
+	done    bool
+}
+
+// Open reads the header from src and returns a reader of the plaintext
+func (s *Sealer) Open(src io.Reader) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("read encryption header: %w", err)
+	}
+	if string(header[:MagicSize]) != s.magic {
+		return nil, fmt.Errorf("not encrypted with %q", s.magic)
+	}
+	nonce := header[MagicSize : MagicSize+nonceSize]
+	key, err := s.wrap.Open(nil, nonce, header[MagicSize+nonceSize:], []byte(s.magic))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key (wrong key?): %w", err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &opener{src: src, aead: aead, sealed: make([]byte, sealedChunk)}, nil
+}
+
+func (so *opener) Read(p []byte) (int, error) {
+	for len(so.buf) == 0 {
+		if so.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(so.src, so.sealed)
+		last := false
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case errors.Is(err, io.EOF):
+			return 0, io.ErrUnexpectedEOF // truncated at a chunk boundary
+		case err != nil:
+			return 0, err
+		}
+		so.buf, err = so.aead.Open(so.buf[:0], chunkNonce(so.counter, last), so.sealed[:n], nil)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt chunk %d: %w", so.counter, err)
+		}
+		so.counter++
+		so.done = last
+	}
+
+	n := copy(p, so.buf)
+	so.buf = so.buf[n:]
+	return n, nil
+}
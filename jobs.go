@@ -0,0 +1,348 @@
+package logapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	compressJobsDir  = ".compress-jobs"
+	compressJobsKept = 50
+	// compressJobSaveEvery is how often a running job's progress is saved,
+	// or its file touched when there's no new progress
+	compressJobSaveEvery = 5 * time.Second
+	// compressJobStaleAfter is how long a running job's file may go
+	// untouched before the job counts as interrupted
+	compressJobStaleAfter = 12 * compressJobSaveEvery
+)
+
+// Compression job statuses
+const (
+	CompressJobRunning = "running"
+	CompressJobDone    = "done"
+	CompressJobFailed  = "failed"
+	// CompressJobInterrupted is a job whose process stopped (crashed or
+	// restarted) before it finished
+	CompressJobInterrupted = "interrupted"
+)
+
+// CompressJob is a compression run (one CompressEach), with its progress
+// while it runs. The last 50 are kept in the storage directory, each in its
+// own file, so runs of `logapid compact` elsewhere show up too.
+type CompressJob struct {
+	ID       string    `json:"id"`
+	Host     string    `json:"host,omitempty"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	// Months is how many months the run set out to compress, Done how many
+	// of those have finished (Failed of them with an error)
+	Months   int    `json:"months"`
+	Done     int    `json:"done"`
+	Failed   int    `json:"failed"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+	// Results has each finished month; it's left out of job listings
+	Results []CompressJobMonth `json:"results,omitempty"`
+
+	saved time.Time     // when the job was last written to the history
+	stop  chan struct{} // ends the job's heartbeat
+}
+
+// CompressJobMonth is a month a compression job archived, or failed to
+type CompressJobMonth struct {
+	User    string `json:"user"`
+	Month   string `json:"month"`
+	Tarball string `json:"tarball,omitempty"`
+	// BytesIn is the size of the month's live files, BytesOut how much its
+	// tarball grew by
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+// WithJobLog logs each compression job and month to logger, as
+// compress_job_started, compress_month and compress_job_finished events.
+// It defaults to slog.Default().
+func WithJobLog(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.jobLog = logger
+	}
+}
+
+// startCompressJob records a new job that's to compress months
+func (s *Server) startCompressJob(months int) *CompressJob {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	host, _ := os.Hostname()
+	job := &CompressJob{
+		ID:      hex.EncodeToString(id),
+		Host:    host,
+		Status:  CompressJobRunning,
+		Started: time.Now().UTC(),
+		Months:  months,
+		Results: []CompressJobMonth{},
+	}
+	s.jobLog.Info("compress_job_started",
+		slog.String("job", job.ID),
+		slog.Int("months", months),
+	)
+	s.saveCompressJob(job)
+	job.stop = make(chan struct{})
+	go s.compressJobHeartbeat(job.ID, job.stop)
+	return job
+}
+
+// compressJobHeartbeat touches a running job's file every
+// compressJobSaveEvery until stop is closed, so a month that takes a while
+// doesn't make the job look interrupted
+func (s *Server) compressJobHeartbeat(id string, stop chan struct{}) {
+	path := filepath.Join(s.storage, compressJobsDir, id+".json")
+	ticker := time.NewTicker(compressJobSaveEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			_ = os.Chtimes(path, now, now)
+		}
+	}
+}
+
+// addCompressResult records a month the job has finished
+func (s *Server) addCompressResult(job *CompressJob, result CompressResult) {
+	month := CompressJobMonth{
+		User:     result.User,
+		Month:    result.Month,
+		Tarball:  result.Tarball,
+		BytesIn:  result.Bytes,
+		BytesOut: result.ArchiveBytes,
+		Duration: result.Duration.Milliseconds(),
+	}
+	attrs := []slog.Attr{
+		slog.String("job", job.ID),
+		slog.String("user", result.User),
+		slog.String("month", result.Month),
+		slog.String("tarball", result.Tarball),
+		slog.Int64("bytes_in", month.BytesIn),
+		slog.Int64("bytes_out", month.BytesOut),
+		slog.Duration("duration", result.Duration),
+	}
+	level := slog.LevelInfo
+	if result.Err != nil {
+		month.Error = result.Err.Error()
+		job.Failed++
+		if job.Error == "" {
+			job.Error = month.Error
+		}
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", month.Error))
+	}
+	job.Done++
+	job.BytesIn += month.BytesIn
+	job.BytesOut += month.BytesOut
+	job.Duration = time.Since(job.Started).Milliseconds()
+	job.Results = append(job.Results, month)
+	s.jobLog.LogAttrs(context.Background(), level, "compress_month", attrs...)
+	if time.Since(job.saved) >= compressJobSaveEvery {
+		s.saveCompressJob(job)
+	}
+}
+
+// finishCompressJob records the end of a job; err is why it stopped, if
+// it failed before it could start compressing
+func (s *Server) finishCompressJob(job *CompressJob, err error) {
+	close(job.stop)
+	job.Finished = time.Now().UTC()
+	job.Duration = job.Finished.Sub(job.Started).Milliseconds()
+	job.Status = CompressJobDone
+	if err != nil && job.Error == "" {
+		job.Error = err.Error()
+	}
+	if job.Error != "" {
+		job.Status = CompressJobFailed
+	}
+	level := slog.LevelInfo
+	if job.Status == CompressJobFailed {
+		level = slog.LevelError
+	}
+	s.jobLog.LogAttrs(context.Background(), level, "compress_job_finished",
+		slog.String("job", job.ID),
+		slog.String("status", job.Status),
+		slog.Int("months", job.Months),
+		slog.Int("done", job.Done),
+		slog.Int("failed", job.Failed),
+		slog.Int64("bytes_in", job.BytesIn),
+		slog.Int64("bytes_out", job.BytesOut),
+		slog.Duration("duration", job.Finished.Sub(job.Started)),
+	)
+	s.saveCompressJob(job)
+	s.trimCompressJobs()
+}
+
+// saveCompressJob writes a job's file in the history. Only the process
+// running a job writes its file, so processes sharing the storage don't
+// lose each other's jobs. The history is only a record, so failing to
+// write it is logged rather than failing the job.
+func (s *Server) saveCompressJob(job *CompressJob) {
+	job.saved = time.Now()
+	dir := filepath.Join(s.storage, compressJobsDir)
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, job.ID+".json"), data)
+	}
+	if err != nil {
+		s.jobLog.Error("compress_job_history", slog.String("error", err.Error()))
+	}
+}
+
+// writeFileAtomic writes data to a temp file of its own that's renamed
+// over path, so readers see the old file or the new one
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// trimCompressJobs removes the oldest jobs past compressJobsKept
+func (s *Server) trimCompressJobs() {
+	jobs, err := s.loadCompressJobs()
+	if err != nil {
+		s.jobLog.Error("compress_job_history", slog.String("error", err.Error()))
+		return
+	}
+	for _, job := range jobs[min(len(jobs), compressJobsKept):] {
+		err := os.Remove(filepath.Join(s.storage, compressJobsDir, job.ID+".json"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.jobLog.Error("compress_job_history", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// loadCompressJobs reads the job history, newest first. A running job
+// whose file has gone untouched for compressJobStaleAfter is reported as
+// interrupted.
+func (s *Server) loadCompressJobs() ([]CompressJob, error) {
+	dir := filepath.Join(s.storage, compressJobsDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []CompressJob{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	jobs := []CompressJob{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // trimmed since
+		}
+		if err != nil {
+			return nil, err
+		}
+		var job CompressJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("invalid %q format: %w", path, err)
+		}
+		if info, err := entry.Info(); err == nil && job.Status == CompressJobRunning && time.Since(info.ModTime()) > compressJobStaleAfter {
+			job.Status = CompressJobInterrupted
+		}
+		jobs = append(jobs, job)
+	}
+	slices.SortStableFunc(jobs, func(a, b CompressJob) int {
+		return b.Started.Compare(a.Started)
+	})
+	return jobs, nil
+}
+
+// ListCompressJobs handles GET /api/compress-jobs, the compression job
+// history, newest first, without each job's months (admin only)
+func (s *Server) ListCompressJobs(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	jobs, err := s.loadCompressJobs()
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	for i := range jobs {
+		jobs[i].Results = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results": jobs,
+	})
+}
+
+// GetCompressJob handles GET /api/compress-jobs/{id}, a compression job
+// with each month it has finished so far (admin only)
+func (s *Server) GetCompressJob(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	id := r.PathValue("id")
+	jobs, err := s.loadCompressJobs()
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	i := slices.IndexFunc(jobs, func(job CompressJob) bool {
+		return job.ID == id
+	})
+	if i < 0 {
+		s.jsonError(w, http.StatusNotFound, "job_not_found", "Job not found", fmt.Sprintf("No compression job %q", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(jobs[i])
+}
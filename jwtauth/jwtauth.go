@@ -0,0 +1,285 @@
+// Package jwtauth verifies RS256 / ES256 JWTs against an issuer's JWKS,
+// mapping a claim (the subject, by default) to the storage username
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jwksTTL          = time.Hour
+	jwksRetryBackoff = time.Minute
+	clockSkew        = time.Minute
+)
+
+// Verifier checks JWT signatures and the iss, aud, exp and nbf claims
+type Verifier struct {
+	Issuer   string
+	JWKSURL  string
+	Audience string // optional
+	Claim    string // defaults to "sub"
+	Client   *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey // kid -> key
+	fetched     time.Time
+	lastAttempt time.Time
+}
+
+// New creates a verifier for tokens from the given issuer
+func New(issuer, jwksURL string) *Verifier {
+	return &Verifier{
+		Issuer:  issuer,
+		JWKSURL: jwksURL,
+		Claim:   "sub",
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// VerifyToken returns the username a valid JWT maps to
+func (v *Verifier) VerifyToken(token string) (string, bool) {
+	claims, ok := v.Claims(token)
+	if !ok {
+		return "", false
+	}
+	return v.Username(claims)
+}
+
+// Username returns the username Claim maps a valid JWT's claims to
+func (v *Verifier) Username(claims map[string]any) (string, bool) {
+	claim := v.Claim
+	if claim == "" {
+		claim = "sub"
+	}
+	username, _ := claims[claim].(string)
+	if username == "" {
+		return "", false
+	}
+	return username, true
+}
+
+// Claims returns a JWT's claims, if it is validly signed by the issuer and
+// its iss, aud, exp and nbf check out
+func (v *Verifier) Claims(token string) (map[string]any, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, false
+	}
+	if hdr.Alg != "RS256" && hdr.Alg != "ES256" {
+		return nil, false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+
+	key, ok := v.key(hdr.Kid)
+	if !ok {
+		return nil, false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(hdr.Alg, key, digest[:], sig) {
+		return nil, false
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, false
+	}
+	if !v.validClaims(claims, time.Now()) {
+		return nil, false
+	}
+	return claims, true
+}
+
+func (v *Verifier) validClaims(claims map[string]any, now time.Time) bool {
+	if iss, _ := claims["iss"].(string); iss != v.Issuer {
+		return false
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return false
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return false
+	}
+
+	if v.Audience == "" {
+		return true
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == v.Audience
+	case []any:
+		for _, a := range aud {
+			if s, _ := a.(string); s == v.Audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, sig []byte) bool {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// key returns the signing key for a kid, refreshing the JWKS when it is
+// stale or the kid is unknown (at most once per jwksRetryBackoff)
+func (v *Verifier) key(kid string) (crypto.PublicKey, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	key, ok := v.keys[kid]
+	if ok && now.Sub(v.fetched) < jwksTTL {
+		return key, true
+	}
+	if now.Sub(v.lastAttempt) < jwksRetryBackoff {
+		return key, ok
+	}
+
+	v.lastAttempt = now
+	keys, err := v.fetchJWKS()
+	if err != nil {
+		log.Printf("[jwtauth] fetching %s: %v", v.JWKSURL, err)
+		return key, ok
+	}
+	v.keys = keys
+	v.fetched = now
+
+	key, ok = v.keys[kid]
+	return key, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *Verifier) fetchJWKS() (map[string]crypto.PublicKey, error) {
+	resp, err := v.Client.Get(v.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			log.Printf("[jwtauth] skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
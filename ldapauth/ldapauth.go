@@ -0,0 +1,295 @@
+// Package ldapauth verifies Basic Auth passwords by binding to an LDAP
+// directory as the user, either at a DN made from the username or at the
+// entry a search finds, and maps the user's groups to roles
+package ldapauth
+
+import (
+	"crypto/tls"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultFilter         = "(uid=%s)"
+	defaultGroupAttribute = "memberOf"
+	defaultTimeout        = 10 * time.Second
+)
+
+// Verifier checks passwords against an LDAP directory. With UserDN set it
+// binds as the user directly; otherwise it searches BaseDN for the user
+// with Filter, as BindDN or anonymously, then binds as the entry found.
+type Verifier struct {
+	URL       string      // ldap://, ldaps:// or ldapi://
+	StartTLS  bool        // upgrade ldap:// connections with StartTLS
+	TLSConfig *tls.Config // optional, for ldaps:// and StartTLS
+	Timeout   time.Duration
+
+	// bind as the user
+	UserDN string // e.g. uid=%s,ou=people,dc=example,dc=com; %s is the escaped username
+
+	// search, then bind as the user
+	BaseDN       string
+	Filter       string // defaults to (uid=%s); %s is the escaped username
+	BindDN       string // optional, to search as
+	BindPassword string
+
+	// GroupRoles maps group DNs to roles. Without it the directory doesn't
+	// assign roles, and users get the server's defaults.
+	GroupRoles map[string][]string
+	// GroupFilter finds the groups a user is in, searching GroupBaseDN (or
+	// BaseDN), e.g. (member=%s) where %s is the user's escaped DN. Without
+	// it, groups are read from the user entry's GroupAttribute.
+	GroupFilter    string
+	GroupBaseDN    string
+	GroupAttribute string // defaults to memberOf
+
+	mu    sync.Mutex
+	roles map[string][]string // username -> roles, as of their last login
+}
+
+// New creates a verifier for the directory at ldapURL
+func New(ldapURL string) *Verifier {
+	return &Verifier{
+		URL:            ldapURL,
+		Filter:         defaultFilter,
+		GroupAttribute: defaultGroupAttribute,
+		Timeout:        defaultTimeout,
+	}
+}
+
+// Verify checks Basic Auth credentials by binding as the user
+func (v *Verifier) Verify(username, password string) bool {
+	// an empty password is an unauthenticated bind, which servers allow
+	if username == "" || password == "" {
+		return false
+	}
+
+	conn, err := v.dial()
+	if err != nil {
+		log.Printf("[ldapauth] connecting to %s: %v", v.URL, err)
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	var userDN string
+	var groups []string
+	if v.UserDN != "" {
+		userDN = fmt.Sprintf(v.UserDN, ldap.EscapeDN(username))
+		if !v.bind(conn, userDN, password) {
+			return false
+		}
+		if len(v.GroupRoles) > 0 && v.GroupFilter == "" {
+			entry, err := v.searchOne(conn, userDN, ldap.ScopeBaseObject, "(objectClass=*)")
+			if err != nil {
+				log.Printf("[ldapauth] reading %s: %v", userDN, err)
+				return false
+			}
+			groups = entry.GetAttributeValues(v.groupAttribute())
+		}
+	} else {
+		if v.BindDN != "" {
+			if err := conn.Bind(v.BindDN, v.BindPassword); err != nil {
+				log.Printf("[ldapauth] binding as %s: %v", v.BindDN, err)
+				return false
+			}
+		}
+		filter := v.Filter
+		if filter == "" {
+			filter = defaultFilter
+		}
+		entry, err := v.searchOne(conn, v.BaseDN, ldap.ScopeWholeSubtree, fmt.Sprintf(filter, ldap.EscapeFilter(username)))
+		if err != nil {
+			if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+				log.Printf("[ldapauth] searching for %q: %v", username, err)
+			}
+			return false
+		}
+		userDN = entry.DN
+		groups = entry.GetAttributeValues(v.groupAttribute())
+		if !v.bind(conn, userDN, password) {
+			return false
+		}
+	}
+
+	if len(v.GroupRoles) > 0 && v.GroupFilter != "" {
+		groups, err = v.searchGroups(conn, userDN)
+		if err != nil {
+			log.Printf("[ldapauth] searching groups of %s: %v", userDN, err)
+			return false
+		}
+	}
+	if len(v.GroupRoles) > 0 {
+		v.setRoles(username, groups)
+	}
+	return true
+}
+
+// Roles returns the roles a user's groups map to, as of their last login,
+// or nil without GroupRoles. Users in no mapped group have no roles.
+func (v *Verifier) Roles(username string) []string {
+	if len(v.GroupRoles) == 0 {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if roles, ok := v.roles[username]; ok {
+		return roles
+	}
+	return []string{}
+}
+
+func (v *Verifier) dial() (*ldap.Conn, error) {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	conn, err := ldap.DialURL(v.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(v.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if v.StartTLS {
+		config := v.TLSConfig
+		if config == nil {
+			u, err := url.Parse(v.URL)
+			if err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			config = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		}
+		if err := conn.StartTLS(config); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bind reports whether the password is the user's, logging errors other
+// than wrong credentials
+func (v *Verifier) bind(conn *ldap.Conn, userDN, password string) bool {
+	err := conn.Bind(userDN, password)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		log.Printf("[ldapauth] binding as %s: %v", userDN, err)
+	}
+	return err == nil
+}
+
+// searchOne returns the single entry a search finds
+func (v *Verifier) searchOne(conn *ldap.Conn, baseDN string, scope int, filter string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 2, 0, false, filter, []string{v.groupAttribute()}, nil)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	switch {
+	case res == nil || len(res.Entries) == 0:
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("%s matches no entries", filter))
+	case len(res.Entries) > 1:
+		return nil, fmt.Errorf("%s matches more than one entry", filter)
+	}
+	return res.Entries[0], nil
+}
+
+// searchGroups returns the DNs of the groups GroupFilter finds for a user
+func (v *Verifier) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	baseDN := v.GroupBaseDN
+	if baseDN == "" {
+		baseDN = v.BaseDN
+	}
+	req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(v.GroupFilter, ldap.EscapeFilter(userDN)), []string{"1.1"}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, len(res.Entries))
+	for i, entry := range res.Entries {
+		groups[i] = entry.DN
+	}
+	return groups, nil
+}
+
+func (v *Verifier) groupAttribute() string {
+	if v.GroupAttribute == "" {
+		return defaultGroupAttribute
+	}
+	return v.GroupAttribute
+}
+
+// setRoles records the roles a user's groups map to. Group DNs compare
+// case-insensitively, ignoring spacing.
+func (v *Verifier) setRoles(username string, groups []string) {
+	roles := []string{}
+	for group, groupRoles := range v.GroupRoles {
+		mapped, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for _, g := range groups {
+			if dn, err := ldap.ParseDN(g); err == nil && mapped.EqualFold(dn) {
+				roles = append(roles, groupRoles...)
+				break
+			}
+		}
+	}
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.roles == nil {
+		v.roles = make(map[string][]string)
+	}
+	v.roles[username] = roles
+}
+
+// LoadGroupRoles reads a group, roles TSV mapping group DNs to
+// comma-separated roles
+func LoadGroupRoles(f *os.File) (map[string][]string, error) {
+	groupRoles := make(map[string][]string)
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 2 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		if _, err := ldap.ParseDN(record[0]); err != nil {
+			return nil, fmt.Errorf("invalid group DN %q in %q: %w", record[0], f.Name(), err)
+		}
+		for _, role := range strings.Split(record[1], ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				groupRoles[record[0]] = append(groupRoles[record[0]], role)
+			}
+		}
+	}
+
+	return groupRoles, nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package logapi
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// hardlinkCounts is whether linkCount works on this OS
+const hardlinkCounts = true
+
+// linkCount returns how many names a file has
+func linkCount(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 0
+}
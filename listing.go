@@ -0,0 +1,151 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
+// FileInfo describes a stored file, as in a recursive listing
+type FileInfo struct {
+	Month    string    `json:"month"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"-"`
+	Archived bool      `json:"archived"`
+}
+
+// MonthInfo is a month of a user's listing: a live directory, a tarball,
+// or both while files that arrived after it was archived wait for the next
+// compression run. Files and Size count both; for a cold month (whose
+// tarball is in cold storage) they're left at 0, as counting its files
+// would fetch the tarball back.
+type MonthInfo struct {
+	Month    string `json:"month"`
+	Live     bool   `json:"live"`
+	Archived bool   `json:"archived"`
+	Cold     bool   `json:"cold,omitempty"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`
+	// ArchiveSize is what the tarball takes up, if the storage can tell
+	ArchiveSize int64 `json:"archive_size,omitempty"`
+}
+
+// monthInfos describes a user's months
+func (s *Server) monthInfos(user string, months []Month) ([]MonthInfo, error) {
+	sizer, _ := s.store.(ArchiveSizer)
+	results := []MonthInfo{}
+	for _, month := range months {
+		info := MonthInfo{
+			Month:    month.Name,
+			Live:     month.Live,
+			Archived: month.Archived,
+			Cold:     month.Cold,
+		}
+		s.commitLock.RLock()
+		var err error
+		if !month.Cold {
+			var files []FileInfo
+			files, err = s.store.List(user, month.Name)
+			for _, file := range files {
+				info.Files++
+				info.Size += file.Size
+			}
+		}
+		if err == nil && month.Archived && sizer != nil {
+			info.ArchiveSize, err = sizer.ArchiveSize(user, month.Name)
+		}
+		s.commitLock.RUnlock()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed since Months
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, info)
+	}
+	return results, nil
+}
+
+// writeRecursiveList writes every file of every month, or of the months
+// from ?from= through ?to= (YYYY-MM, either may be left out), paginated by
+// ?limit= and an opaque ?cursor= taken from the previous page's "next"
+func (s *Server) writeRecursiveList(w http.ResponseWriter, r *http.Request, user string) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	for _, month := range []string{from, to} {
+		if _, err := time.Parse("2006-01", month); month != "" && err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", fmt.Sprintf("%q is not a YYYY-MM month", month))
+			return
+		}
+	}
+
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			s.jsonError(w, http.StatusBadRequest, "invalid_limit", "Invalid limit", "limit must be between 1 and 10000")
+			return
+		}
+		limit = n
+	}
+	cursor := r.URL.Query().Get("cursor")
+
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+
+	months, err := s.store.Months(user)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	results := []FileInfo{}
+	next := ""
+	for _, month := range months {
+		if cursor != "" && month.Name < strings.SplitN(cursor, "/", 2)[0] {
+			continue
+		}
+		if (from != "" && month.Name < from) || (to != "" && month.Name > to) {
+			continue
+		}
+
+		files, err := s.store.List(user, month.Name)
+		if err != nil {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+			return
+		}
+		for _, file := range files {
+			key := file.Month + "/" + file.Name
+			if cursor != "" && key <= cursor {
+				continue
+			}
+			if len(results) == limit {
+				next = results[len(results)-1].Month + "/" + results[len(results)-1].Name
+				break
+			}
+			results = append(results, file)
+		}
+		if next != "" {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results": results,
+		"next":    next,
+	})
+}
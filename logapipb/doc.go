@@ -0,0 +1,5 @@
+// Package logapipb holds the generated protobuf messages and gRPC service
+// of logapi.proto, served by logapi.Server.RegisterGRPC
+package logapipb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative logapi.proto
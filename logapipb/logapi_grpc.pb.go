@@ -0,0 +1,214 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: logapi.proto
+
+package logapipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LogAPI_Upload_FullMethodName = "/logapi.v1.LogAPI/Upload"
+	LogAPI_Get_FullMethodName    = "/logapi.v1.LogAPI/Get"
+	LogAPI_List_FullMethodName   = "/logapi.v1.LogAPI/List"
+)
+
+// LogAPIClient is the client API for LogAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LogAPI is the gRPC form of the HTTP API. Credentials are sent as an
+// "authorization" metadata value, as they would be in the Authorization
+// header (Basic or Bearer), or as a TLS client certificate.
+type LogAPIClient interface {
+	// Upload stores one file for the authenticated user. The first message
+	// names it, and every message may carry some of its content.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, UploadResponse], error)
+	// Get streams a file's content, from the live directory or the month's
+	// tarball.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetResponse], error)
+	// List returns a user's months or, with a date, a month's (or day's)
+	// files.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+}
+
+type logAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogAPIClient(cc grpc.ClientConnInterface) LogAPIClient {
+	return &logAPIClient{cc}
+}
+
+func (c *logAPIClient) Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadRequest, UploadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogAPI_ServiceDesc.Streams[0], LogAPI_Upload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadRequest, UploadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogAPI_UploadClient = grpc.ClientStreamingClient[UploadRequest, UploadResponse]
+
+func (c *logAPIClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogAPI_ServiceDesc.Streams[1], LogAPI_Get_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetRequest, GetResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogAPI_GetClient = grpc.ServerStreamingClient[GetResponse]
+
+func (c *logAPIClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, LogAPI_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LogAPIServer is the server API for LogAPI service.
+// All implementations must embed UnimplementedLogAPIServer
+// for forward compatibility.
+//
+// LogAPI is the gRPC form of the HTTP API. Credentials are sent as an
+// "authorization" metadata value, as they would be in the Authorization
+// header (Basic or Bearer), or as a TLS client certificate.
+type LogAPIServer interface {
+	// Upload stores one file for the authenticated user. The first message
+	// names it, and every message may carry some of its content.
+	Upload(grpc.ClientStreamingServer[UploadRequest, UploadResponse]) error
+	// Get streams a file's content, from the live directory or the month's
+	// tarball.
+	Get(*GetRequest, grpc.ServerStreamingServer[GetResponse]) error
+	// List returns a user's months or, with a date, a month's (or day's)
+	// files.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	mustEmbedUnimplementedLogAPIServer()
+}
+
+// UnimplementedLogAPIServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogAPIServer struct{}
+
+func (UnimplementedLogAPIServer) Upload(grpc.ClientStreamingServer[UploadRequest, UploadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedLogAPIServer) Get(*GetRequest, grpc.ServerStreamingServer[GetResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedLogAPIServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedLogAPIServer) mustEmbedUnimplementedLogAPIServer() {}
+func (UnimplementedLogAPIServer) testEmbeddedByValue()                {}
+
+// UnsafeLogAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogAPIServer will
+// result in compilation errors.
+type UnsafeLogAPIServer interface {
+	mustEmbedUnimplementedLogAPIServer()
+}
+
+func RegisterLogAPIServer(s grpc.ServiceRegistrar, srv LogAPIServer) {
+	// If the following call pancis, it indicates UnimplementedLogAPIServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LogAPI_ServiceDesc, srv)
+}
+
+func _LogAPI_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogAPIServer).Upload(&grpc.GenericServerStream[UploadRequest, UploadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogAPI_UploadServer = grpc.ClientStreamingServer[UploadRequest, UploadResponse]
+
+func _LogAPI_Get_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogAPIServer).Get(m, &grpc.GenericServerStream[GetRequest, GetResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogAPI_GetServer = grpc.ServerStreamingServer[GetResponse]
+
+func _LogAPI_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogAPIServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LogAPI_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogAPIServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LogAPI_ServiceDesc is the grpc.ServiceDesc for LogAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LogAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "logapi.v1.LogAPI",
+	HandlerType: (*LogAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    _LogAPI_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _LogAPI_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Get",
+			Handler:       _LogAPI_Get_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "logapi.proto",
+}
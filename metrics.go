@@ -0,0 +1,38 @@
+package logapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GetMetrics serves failed logins and IP bans in the Prometheus text
+// format (admin only)
+func (s *Server) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	bans := s.bans.list(time.Now())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "# HELP logapi_login_failures_total Failed logins since the server started.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE logapi_login_failures_total counter\n")
+	_, _ = fmt.Fprintf(w, "logapi_login_failures_total %d\n", s.authFailures.Load())
+	_, _ = fmt.Fprintf(w, "# HELP logapi_ip_bans_total Addresses banned for failed logins since the server started.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE logapi_ip_bans_total counter\n")
+	_, _ = fmt.Fprintf(w, "logapi_ip_bans_total %d\n", s.bans.total())
+	_, _ = fmt.Fprintf(w, "# HELP logapi_ip_bans Addresses banned now.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE logapi_ip_bans gauge\n")
+	_, _ = fmt.Fprintf(w, "logapi_ip_bans %d\n", len(bans))
+	_, _ = fmt.Fprintf(w, "# HELP logapi_ip_ban_until_seconds When each banned address's ban ends, as a Unix time.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE logapi_ip_ban_until_seconds gauge\n")
+	for _, ban := range bans {
+		_, _ = fmt.Fprintf(w, "logapi_ip_ban_until_seconds{address=%q} %d\n", ban.Address, ban.Until.Unix())
+	}
+}
@@ -0,0 +1,150 @@
+package logapi
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isMultiUpload reports whether a POST /api/logs body carries several files,
+// as multipart/form-data or a tar stream, rather than a single one
+func isMultiUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data" || mediaType == "application/x-tar"
+}
+
+// saveUpload writes one file into dataDir via a temp file and rename
+func saveUpload(dataDir, name string, body io.Reader) error {
+	storagePath := filepath.Join(dataDir, name)
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		return err
+	}
+	tmpPath := storagePath + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmpFile, body); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, storagePath)
+}
+
+// writeMultiUpload unpacks every file in a multipart or tar body with put,
+// which returns the name it stored the file as. Files written before an
+// error are kept and listed in the error.
+func (s *Server) writeMultiUpload(w http.ResponseWriter, r *http.Request, date string, put func(name string, body io.Reader) (string, error)) {
+	var uploaded []string
+	fail := func(status int, code, errorMsg string, err error) {
+		detail := err.Error()
+		if len(uploaded) > 0 {
+			detail = fmt.Sprintf("%s (already uploaded: %s)", detail, strings.Join(uploaded, ", "))
+		}
+		s.jsonError(w, status, code, errorMsg, detail)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var next func() (string, io.Reader, error)
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid_multipart", "Invalid multipart body", err)
+			return
+		}
+		next = func() (string, io.Reader, error) {
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					return "", nil, err
+				}
+				// part.FileName() drops directories, so read the raw filename
+				_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+				name := params["filename"]
+				// skip plain form fields
+				if name == "" {
+					continue
+				}
+				return name, part, nil
+			}
+		}
+	} else {
+		tr := tar.NewReader(r.Body)
+		next = func() (string, io.Reader, error) {
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					return "", nil, err
+				}
+				// directories, links and the like aren't log files
+				if hdr.Typeflag != tar.TypeReg {
+					continue
+				}
+				return strings.TrimPrefix(path.Clean(hdr.Name), "./"), tr, nil
+			}
+		}
+	}
+
+	for {
+		name, body, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if isTooLarge(err) {
+			fail(http.StatusRequestEntityTooLarge, "upload_too_large", "Upload too large", err)
+			return
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid_body", "Invalid upload body", err)
+			return
+		}
+		if err := checkName(name); err != nil {
+			fail(http.StatusBadRequest, "invalid_name", "Invalid file name", err)
+			return
+		}
+		if code, detail := s.checkNamePolicy(name); code != "" {
+			fail(http.StatusUnprocessableEntity, code, "File name not allowed", errors.New(detail))
+			return
+		}
+		stored, err := put(name, body)
+		if err != nil {
+			if isTooLarge(err) {
+				fail(http.StatusRequestEntityTooLarge, "upload_too_large", "Upload too large", err)
+				return
+			}
+			if errors.Is(err, ErrExists) {
+				fail(http.StatusConflict, "file_exists", "File exists", err)
+				return
+			}
+			fail(http.StatusInternalServerError, "write_failed", "Failed to write file", err)
+			return
+		}
+		uploaded = append(uploaded, date+"/"+stored)
+	}
+
+	if len(uploaded) == 0 {
+		s.jsonError(w, http.StatusBadRequest, "no_files", "No files", "The request body contained no files")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": fmt.Sprintf("%d files uploaded: %s", len(uploaded), r.URL.Path),
+		"results": uploaded,
+	})
+}
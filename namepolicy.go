@@ -0,0 +1,84 @@
+package logapi
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// NamePolicy restricts the file names uploads may use, on top of what's
+// never allowed (see checkName), so misconfigured agents can't fill the
+// archive with garbage names. Each rule is off when left empty, and a name
+// breaking one gets a 422 with that rule's code.
+type NamePolicy struct {
+	// MaxLength is the longest name, subdirectories included, in
+	// characters (name_too_long)
+	MaxLength int
+	// Extensions are those a name may end in, such as ".log" and ".gz"; a
+	// name's extension is what follows the last dot of its last segment
+	// (extension_not_allowed)
+	Extensions []string
+	// Charset is the characters a name may use, as the inside of a regexp
+	// bracket expression such as "A-Za-z0-9._-"; "/" is always allowed
+	// between segments (invalid_name_chars)
+	Charset string
+	// Suffixes are endings a name must have one of, such as ".log" or
+	// ".log.gz" (suffix_required)
+	Suffixes []string
+}
+
+// WithNamePolicy rejects uploads whose X-File-Name (or entry names, for
+// multi-file uploads and imported months) break policy. Files stored
+// before can still be read.
+func WithNamePolicy(policy NamePolicy) Option {
+	return func(s *Server) {
+		s.namePolicy = policy
+	}
+}
+
+// compileNamePolicy checks the policy's Charset, for New
+func (s *Server) compileNamePolicy() error {
+	if s.namePolicy.Charset == "" {
+		return nil
+	}
+	chars, err := regexp.Compile("^[/" + s.namePolicy.Charset + "]*$")
+	if err != nil {
+		return fmt.Errorf("invalid file name charset %q: %w", s.namePolicy.Charset, err)
+	}
+	s.nameChars = chars
+	return nil
+}
+
+// checkNamePolicy returns the error code and detail for a name the policy
+// doesn't allow, or "" if it does
+func (s *Server) checkNamePolicy(name string) (string, string) {
+	policy := s.namePolicy
+	if policy.MaxLength > 0 && utf8.RuneCountInString(name) > policy.MaxLength {
+		return "name_too_long", fmt.Sprintf("%q is longer than %d characters", name, policy.MaxLength)
+	}
+	if s.nameChars != nil && !s.nameChars.MatchString(name) {
+		return "invalid_name_chars", fmt.Sprintf("%q may only use the characters [%s]", name, policy.Charset)
+	}
+	if len(policy.Extensions) > 0 && !slices.Contains(policy.Extensions, path.Ext(name)) {
+		return "extension_not_allowed", fmt.Sprintf("%q must end in one of %s", name, strings.Join(policy.Extensions, ", "))
+	}
+	if len(policy.Suffixes) > 0 && !slices.ContainsFunc(policy.Suffixes, func(suffix string) bool {
+		return strings.HasSuffix(name, suffix)
+	}) {
+		return "suffix_required", fmt.Sprintf("%q must end with one of %s", name, strings.Join(policy.Suffixes, ", "))
+	}
+	return "", ""
+}
+
+// allowedName writes the 422 for a name the policy doesn't allow
+func (s *Server) allowedName(w http.ResponseWriter, name string) bool {
+	if code, detail := s.checkNamePolicy(name); code != "" {
+		s.jsonError(w, http.StatusUnprocessableEntity, code, "File name not allowed", detail)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,409 @@
+// Package objstore keeps files in an S3 bucket, or in another store with
+// an S3-compatible API, such as Google Cloud Storage (with HMAC keys) or
+// MinIO, speaking its REST API directly
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrArchived is returned by Get for an object in an archive storage class
+// (S3 Glacier Flexible Retrieval or Deep Archive) that hasn't been restored
+var ErrArchived = errors.New("object is archived; restore it first")
+
+// Restore states of an object, from RestoreStatus and Restore
+const (
+	Archived  = "archived"  // must be restored before it's read
+	Restoring = "restoring" // restore in progress
+	Available = "available" // restored, or never archived
+)
+
+// archiveClasses are the storage classes read only after a restore
+var archiveClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// Credentials sign requests. For Google Cloud Storage they're an HMAC key.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// (optionally) AWS_SESSION_TOKEN
+func CredentialsFromEnv() (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// Bucket is a bucket, and a prefix for the keys written to it
+type Bucket struct {
+	Endpoint    string // scheme://host[:port]
+	Name        string
+	Prefix      string // e.g. "logapi/", or ""
+	Region      string
+	VirtualHost bool // bucket.host rather than host/bucket
+	Credentials Credentials
+	Client      *http.Client
+	// StorageClass, if set, is the x-amz-storage-class objects are Put
+	// with, e.g. GLACIER or DEEP_ARCHIVE
+	StorageClass string
+	// RestoreDays is how long a restored copy of an archived object is
+	// kept (default 7), and RestoreTier how fast it's restored: Expedited,
+	// Standard (default) or Bulk
+	RestoreDays int
+	RestoreTier string
+}
+
+// New opens s3://bucket[/prefix] (AWS S3, in region) or
+// gs://bucket[/prefix] (Google Cloud Storage). With endpoint, such as
+// http://minio:9000, the bucket is on that S3-compatible server instead.
+func New(storeURL, region, endpoint string, creds Credentials) (*Bucket, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil || u.Host == "" || (u.Scheme != "s3" && u.Scheme != "gs") {
+		return nil, fmt.Errorf("invalid object store URL %q: must be s3://bucket/prefix or gs://bucket/prefix", storeURL)
+	}
+	b := &Bucket{
+		Name:        u.Host,
+		Prefix:      strings.Trim(u.Path, "/"),
+		Region:      region,
+		Credentials: creds,
+		Client:      http.DefaultClient,
+	}
+	if b.Prefix != "" {
+		b.Prefix += "/"
+	}
+
+	switch {
+	case endpoint != "":
+		if e, err := url.Parse(endpoint); err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+			return nil, fmt.Errorf("invalid object store endpoint %q", endpoint)
+		}
+		b.Endpoint = strings.TrimSuffix(endpoint, "/")
+		if b.Region == "" {
+			b.Region = "us-east-1"
+		}
+	case u.Scheme == "gs":
+		b.Endpoint = "https://storage.googleapis.com"
+		if b.Region == "" {
+			b.Region = "auto"
+		}
+	default:
+		if b.Region == "" {
+			return nil, fmt.Errorf("%s needs a region", storeURL)
+		}
+		b.Endpoint = "https://s3." + b.Region + ".amazonaws.com"
+		// dotted names don't match the wildcard certificate
+		b.VirtualHost = !strings.Contains(b.Name, ".")
+	}
+	return b, nil
+}
+
+// Put uploads size bytes of body, whose hex SHA-256 is sum, as key
+func (b *Bucket) Put(ctx context.Context, key string, body io.Reader, size int64, sum string) error {
+	resp, err := b.do(ctx, http.MethodPut, key, "", body, size, sum)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return checkResponse(resp, key)
+}
+
+// Get downloads key, or fails with fs.ErrNotExist
+func (b *Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, "", nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, key); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes key. Deleting a key that doesn't exist isn't an error.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, "", nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return checkResponse(resp, key)
+}
+
+// RestoreStatus reports whether key can be read: Available, Archived, or
+// Restoring
+func (b *Bucket) RestoreStatus(ctx context.Context, key string) (string, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, "", nil, 0, emptySHA256)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := checkResponse(resp, key); err != nil {
+		return "", err
+	}
+
+	// e.g. ongoing-request="false", expiry-date="Fri, 23 Oct 2026 00:00:00 GMT"
+	restore := resp.Header.Get("X-Amz-Restore")
+	switch {
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return Restoring, nil
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return Available, nil
+	case slices.Contains(archiveClasses, resp.Header.Get("X-Amz-Storage-Class")):
+		return Archived, nil
+	}
+	return Available, nil
+}
+
+// Restore starts restoring key if it's Archived, returning its status
+// afterwards
+func (b *Bucket) Restore(ctx context.Context, key string) (string, error) {
+	status, err := b.RestoreStatus(ctx, key)
+	if err != nil || status != Archived {
+		return status, err
+	}
+
+	days, tier := b.RestoreDays, b.RestoreTier
+	if days <= 0 {
+		days = 7
+	}
+	if tier == "" {
+		tier = "Standard"
+	}
+	body := fmt.Sprintf("<RestoreRequest><Days>%d</Days><GlacierJobParameters><Tier>%s</Tier></GlacierJobParameters></RestoreRequest>", days, tier)
+	sum := sha256.Sum256([]byte(body))
+	resp, err := b.do(ctx, http.MethodPost, key, "restore=", strings.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:]))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusConflict: // RestoreAlreadyInProgress
+		return Restoring, nil
+	case http.StatusOK:
+		return Available, nil
+	}
+	return "", checkResponse(resp, key)
+}
+
+// Object is a key found by List, without the bucket's prefix
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// listResult is a page of ListObjectsV2
+type listResult struct {
+	IsTruncated bool `xml:"IsTruncated"`
+	Contents    []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List calls fn with each object under the bucket's prefix, in key order,
+// a page (up to 1000) at a time
+func (b *Bucket) List(ctx context.Context, fn func(Object) error) error {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		// sorted, and with %20 rather than +, as SigV4 wants
+		resp, err := b.send(ctx, http.MethodGet, "", strings.ReplaceAll(query.Encode(), "+", "%20"), nil, 0, emptySHA256)
+		if err != nil {
+			return err
+		}
+		var page listResult
+		err = checkResponse(resp, b.Prefix)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&page)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, content := range page.Contents {
+			object := Object{
+				Key:     strings.TrimPrefix(content.Key, b.Prefix),
+				Size:    content.Size,
+				ModTime: content.LastModified,
+			}
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object, with query in canonical form
+func (b *Bucket) do(ctx context.Context, method, key, query string, body io.Reader, size int64, sum string) (*http.Response, error) {
+	return b.send(ctx, method, "/"+escapePath(b.Prefix+key), query, body, size, sum)
+}
+
+// send sends a signed request for objectPath, or with "" the bucket itself
+func (b *Bucket) send(ctx context.Context, method, objectPath, query string, body io.Reader, size int64, sum string) (*http.Response, error) {
+	endpoint, _ := url.Parse(b.Endpoint)
+	host := endpoint.Host
+	switch {
+	case !b.VirtualHost:
+		objectPath = "/" + escapePath(b.Name) + objectPath
+	case objectPath == "":
+		objectPath = "/"
+	}
+
+	target := endpoint.Scheme + "://" + host + objectPath
+	if query != "" {
+		target += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if method == http.MethodPut && b.StorageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", b.StorageClass)
+	}
+	b.sign(req, sum, time.Now().UTC())
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// s3Error is the error body of S3 requests
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// checkResponse turns a non-2xx response into an error
+func checkResponse(resp *http.Response, key string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: object %s", fs.ErrNotExist, key)
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var s3Err s3Error
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		if s3Err.Code == "InvalidObjectState" {
+			return fmt.Errorf("%w: object %s", ErrArchived, key)
+		}
+		return fmt.Errorf("object %s: %s: %s", key, s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("object %s: %s", key, resp.Status)
+}
+
+// sign adds the headers of AWS Signature Version 4, for the S3 service,
+// with sum as the payload hash. Host and every x-amz-* header are signed.
+func (b *Bucket) sign(req *http.Request, sum string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", sum)
+	if b.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.Credentials.SessionToken)
+	}
+
+	headerValues := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headerValues[name] = strings.Join(values, ",")
+		}
+	}
+	signedHeaders := slices.Sorted(maps.Keys(headerValues))
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headerValues[name]) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		sum,
+	}, "\n")
+
+	scope := day + "/" + b.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.Credentials.SecretAccessKey), day)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.Credentials.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// escapePath percent-encodes everything but unreserved characters and
+// slashes, as SigV4's canonical URI expects
+func escapePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,218 @@
+package logapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie     = "logapi_session"
+	loginCookie       = "logapi_login"
+	loginTTL          = 10 * time.Minute
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// OIDCProvider runs an OpenID Connect provider's authorization code flow
+// (see oidcauth.Provider)
+type OIDCProvider interface {
+	// AuthCodeURL returns where to send a browser to log in, with a PKCE
+	// S256 code challenge
+	AuthCodeURL(state, nonce, codeChallenge string) string
+	// Exchange trades an authorization code for the username its ID token
+	// maps to, checking the token's nonce
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (string, error)
+}
+
+// WithOIDC lets browsers log in at /auth/login with an OpenID Connect
+// provider, for a session cookie that authenticates API requests for ttl
+// (12h if 0). Sessions are signed with key, so servers sharing it, or
+// restarted with it, accept each other's; a nil key is random.
+func WithOIDC(provider OIDCProvider, key []byte, ttl time.Duration) Option {
+	return func(s *Server) {
+		s.oidc = provider
+		s.sessionKey = key
+		if s.sessionKey == nil {
+			s.sessionKey = newDigestKey()
+		}
+		s.sessionTTL = ttl
+		if s.sessionTTL <= 0 {
+			s.sessionTTL = defaultSessionTTL
+		}
+		s.authFuncs = append(s.authFuncs, s.sessionAuth)
+	}
+}
+
+// oidcLogin is the signed cookie that carries a login's state from
+// /auth/login to /auth/callback
+type oidcLogin struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	Return       string `json:"return"`
+	Expires      int64  `json:"exp"`
+}
+
+// session is the signed session cookie
+type session struct {
+	User    string `json:"user"`
+	Expires int64  `json:"exp"`
+}
+
+// Login sends the browser to the OIDC provider to log in, coming back to
+// /auth/callback and then ?return= (default /)
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		s.jsonError(w, http.StatusNotImplemented, "oidc_unsupported", "OIDC login not supported", "This server has no OpenID Connect provider")
+		return
+	}
+
+	returnTo := r.URL.Query().Get("return")
+	if !localPath(returnTo) {
+		returnTo = "/"
+	}
+	login := oidcLogin{
+		State:        randomString(),
+		Nonce:        randomString(),
+		CodeVerifier: randomString(),
+		Return:       returnTo,
+		Expires:      time.Now().Add(loginTTL).Unix(),
+	}
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+
+	s.setCookie(w, r, loginCookie, "/auth/", s.sign(loginCookie, login), loginTTL)
+	http.Redirect(w, r, s.oidc.AuthCodeURL(login.State, login.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:])), http.StatusFound)
+}
+
+// LoginCallback finishes an OIDC login, setting the session cookie
+func (s *Server) LoginCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		s.jsonError(w, http.StatusNotImplemented, "oidc_unsupported", "OIDC login not supported", "This server has no OpenID Connect provider")
+		return
+	}
+
+	query := r.URL.Query()
+	var login oidcLogin
+	if !s.readCookie(r, loginCookie, &login) || time.Now().Unix() > login.Expires ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		s.jsonError(w, http.StatusBadRequest, "invalid_login_state", "Invalid login", "The login expired or was started in another browser; log in again")
+		return
+	}
+	s.setCookie(w, r, loginCookie, "/auth/", "", -1)
+
+	if errCode := query.Get("error"); errCode != "" {
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Login failed", strings.TrimSpace(errCode+" "+query.Get("error_description")))
+		return
+	}
+	username, err := s.oidc.Exchange(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		s.notifyAuthFailure(r)
+		s.loginFailed(r)
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Login failed", err.Error())
+		return
+	}
+	if s.disabled(username) {
+		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Login failed", "Account disabled")
+		return
+	}
+
+	expires := time.Now().Add(s.sessionTTL)
+	s.setCookie(w, r, sessionCookie, "/", s.sign(sessionCookie, session{User: username, Expires: expires.Unix()}), s.sessionTTL)
+	s.audit("oidc_login",
+		slog.String("user", username),
+		slog.String("remote", remoteIP(r)),
+		slog.Time("expires", expires),
+	)
+	http.Redirect(w, r, login.Return, http.StatusSeeOther)
+}
+
+// Logout clears the session cookie
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	s.setCookie(w, r, sessionCookie, "/", "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionAuth is the AuthFunc for session cookies
+func (s *Server) sessionAuth(r *http.Request) (string, bool) {
+	var sess session
+	if !s.readCookie(r, sessionCookie, &sess) || time.Now().Unix() > sess.Expires || sess.User == "" {
+		return "", false
+	}
+	return sess.User, true
+}
+
+// sign encodes v as JSON with an HMAC over it and the cookie's name, so
+// one kind of cookie can't stand in for another
+func (s *Server) sign(name string, v any) string {
+	payload, _ := json.Marshal(v)
+	mac := hmac.New(sha256.New, s.sessionKey)
+	_, _ = mac.Write([]byte(name + "\x00"))
+	_, _ = mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readCookie decodes a cookie written with sign, if its HMAC checks out
+func (s *Server) readCookie(r *http.Request, name string, v any) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil || s.sessionKey == nil {
+		return false
+	}
+	payload64, sig64, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payload64)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sig64)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, s.sessionKey)
+	_, _ = mac.Write([]byte(name + "\x00"))
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+// setCookie sets (or, with a negative ttl, clears) an HttpOnly cookie.
+// SameSite=Lax keeps other sites' forms and scripts from sending it.
+func (s *Server) setCookie(w http.ResponseWriter, r *http.Request, name, path, value string, ttl time.Duration) {
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// localPath reports whether a ?return= is a path on this server, rather
+// than somewhere an attacker could send a freshly logged in user
+func localPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+// randomString returns 256 random bits, base64url encoded
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
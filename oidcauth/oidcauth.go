@@ -0,0 +1,164 @@
+// Package oidcauth logs users in with an OpenID Connect provider's
+// authorization code flow, and accepts its ID tokens as bearer tokens,
+// mapping a claim (the subject, by default) to the storage username
+package oidcauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/paperos-labs/logapi/jwtauth"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Provider is an OpenID Connect provider logapi is registered with as a
+// confidential client
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // logapi's /auth/callback, as the provider knows it
+	Scopes       []string // requested besides openid, e.g. profile, email
+	Client       *http.Client
+
+	authURL  string
+	tokenURL string
+	verifier *jwtauth.Verifier
+}
+
+// Discover reads an issuer's endpoints and signing keys' location from its
+// /.well-known/openid-configuration
+func Discover(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", req.URL, resp.Status)
+	}
+
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", req.URL, err)
+	}
+	if config.Issuer != issuer {
+		return nil, fmt.Errorf("%s: issuer is %q, not %q", req.URL, config.Issuer, issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: missing authorization, token or JWKS endpoint", req.URL)
+	}
+
+	verifier := jwtauth.New(config.Issuer, config.JWKSURI)
+	verifier.Audience = clientID
+	return &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Client:       client,
+		authURL:      config.AuthorizationEndpoint,
+		tokenURL:     config.TokenEndpoint,
+		verifier:     verifier,
+	}, nil
+}
+
+// SetClaim sets the ID token claim used as the storage username (default
+// "sub"), e.g. preferred_username or email
+func (p *Provider) SetClaim(claim string) {
+	p.verifier.Claim = claim
+}
+
+// AuthCodeURL returns where to send a browser to log in. codeChallenge is
+// the PKCE S256 challenge of the verifier later given to Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode()
+}
+
+// Exchange trades an authorization code for an ID token, and returns the
+// username it maps to
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("token endpoint: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+
+	claims, ok := p.verifier.Claims(token.IDToken)
+	if !ok {
+		return "", errors.New("invalid ID token")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return "", errors.New("ID token nonce doesn't match")
+	}
+	username, ok := p.verifier.Username(claims)
+	if !ok {
+		return "", fmt.Errorf("ID token has no %q claim", p.verifier.Claim)
+	}
+	return username, nil
+}
+
+// VerifyToken returns the username a valid ID token, issued to this
+// client, maps to
+func (p *Provider) VerifyToken(token string) (string, bool) {
+	return p.verifier.VerifyToken(token)
+}
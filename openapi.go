@@ -0,0 +1,119 @@
+package logapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI serves an OpenAPI 3 description of Routes()
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.openAPIDocument())
+}
+
+func (s *Server) openAPIDocument() map[string]any {
+	paths := make(map[string]map[string]any)
+	for _, route := range s.Routes() {
+		// OpenAPI has no {name...}; the value may contain slashes
+		path := strings.ReplaceAll(route.Path, "...}", "}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][strings.ToLower(route.Method)] = openAPIOperation(route)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "logapi",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]any{
+				"JSONError": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"error":      map[string]any{"type": "string"},
+						"code":       map[string]any{"type": "string"},
+						"detail":     map[string]any{"type": "string"},
+						"request_id": map[string]any{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func openAPIOperation(route Route) map[string]any {
+	var params []map[string]any
+	for _, name := range pathParams(route.Path) {
+		params = append(params, map[string]any{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, p := range route.Query {
+		params = append(params, openAPIParam(p, "query"))
+	}
+	for _, p := range route.Headers {
+		params = append(params, openAPIParam(p, "header"))
+	}
+
+	codes := append([]string{"address_banned"}, route.Errors...)
+	if !route.Public {
+		codes = append([]string{"unauthorized", "too_many_attempts", "address_not_allowed", "missing_role"}, codes...)
+	}
+	byStatus := make(map[int][]string)
+	for _, code := range codes {
+		status := errorStatus[code]
+		if !slices.Contains(byStatus[status], code) {
+			byStatus[status] = append(byStatus[status], code)
+		}
+	}
+	responses := map[string]any{
+		"2XX": map[string]any{"description": "Success"},
+	}
+	for status, codes := range byStatus {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status) + ": " + strings.Join(codes, ", "),
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/JSONError"},
+				},
+			},
+		}
+	}
+
+	op := map[string]any{
+		"summary":   route.Summary,
+		"responses": responses,
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if !route.Public {
+		op["security"] = []map[string][]string{{"basicAuth": {}}, {"bearerAuth": {}}}
+	}
+	return op
+}
+
+func openAPIParam(p Param, in string) map[string]any {
+	return map[string]any{
+		"name":        p.Name,
+		"in":          in,
+		"description": p.Description,
+		"required":    p.Required,
+		"schema":      map[string]any{"type": "string"},
+	}
+}
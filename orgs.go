@@ -0,0 +1,200 @@
+package logapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// OrgRoleMember stores files in the org and can read only their own
+	OrgRoleMember = "member"
+	// OrgRoleAdmin can also read, and list, every member's files
+	OrgRoleAdmin = "admin"
+)
+
+// OrgMember is a user's place in an organization
+type OrgMember struct {
+	Org  string `json:"org"`
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// Orgs groups users into organizations, each user in at most one. A nil
+// *Orgs has no organizations.
+type Orgs struct {
+	byUser map[string]OrgMember
+	byOrg  map[string][]OrgMember // sorted by user
+}
+
+// LoadOrgs reads an org, user, role TSV, where role is member or admin
+func LoadOrgs(f *os.File) (*Orgs, error) {
+	orgs := &Orgs{
+		byUser: make(map[string]OrgMember),
+		byOrg:  make(map[string][]OrgMember),
+	}
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 3 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		member := OrgMember{Org: record[0], User: record[1], Role: record[2]}
+		for _, name := range []string{member.Org, member.User} {
+			if err := checkSegment(name); err != nil || strings.HasPrefix(name, ".") {
+				return nil, fmt.Errorf("invalid name %q in %q", name, f.Name())
+			}
+		}
+		if member.Role != OrgRoleMember && member.Role != OrgRoleAdmin {
+			return nil, fmt.Errorf("invalid role %q for %q: must be %s or %s", member.Role, member.User, OrgRoleMember, OrgRoleAdmin)
+		}
+		if other, ok := orgs.byUser[member.User]; ok {
+			return nil, fmt.Errorf("%q is in both %q and %q", member.User, other.Org, member.Org)
+		}
+		orgs.byUser[member.User] = member
+		orgs.byOrg[member.Org] = append(orgs.byOrg[member.Org], member)
+	}
+
+	// an org and a user of the same name would share a directory
+	for org, members := range orgs.byOrg {
+		if _, ok := orgs.byUser[org]; ok {
+			return nil, fmt.Errorf("%q is both an org and a user", org)
+		}
+		slices.SortFunc(members, func(a, b OrgMember) int {
+			return strings.Compare(a.User, b.User)
+		})
+	}
+	return orgs, nil
+}
+
+// Org returns a user's organization, or "" for none
+func (o *Orgs) Org(user string) string {
+	if o == nil {
+		return ""
+	}
+	return o.byUser[user].Org
+}
+
+// IsOrg reports whether an organization has members
+func (o *Orgs) IsOrg(name string) bool {
+	if o == nil {
+		return false
+	}
+	_, ok := o.byOrg[name]
+	return ok
+}
+
+// Members returns an organization's members, sorted by user
+func (o *Orgs) Members(org string) []OrgMember {
+	if o == nil {
+		return nil
+	}
+	return o.byOrg[org]
+}
+
+// isOrgAdmin reports whether username administers the org user is in
+func (o *Orgs) isOrgAdmin(username, user string) bool {
+	if o == nil {
+		return false
+	}
+	member, ok := o.byUser[username]
+	return ok && member.Role == OrgRoleAdmin && member.Org == o.Org(user)
+}
+
+// WithOrgs groups users into organizations. Org admins can read their
+// members' files; the default storage keeps each member's files under
+// {org}/{user}.
+func WithOrgs(orgs *Orgs) Option {
+	return func(s *Server) {
+		s.orgs = orgs
+	}
+}
+
+// userDir is where the server keeps a user's own state, such as staged
+// uploads
+func (s *Server) userDir(user string) string {
+	return filepath.Join(s.storage, s.orgs.Org(user), user)
+}
+
+// SetOrgs keeps each org member's files under {root}/{org}/{user} rather
+// than {root}/{user}. Call it before use.
+func (fsys *FSStorage) SetOrgs(orgs *Orgs) {
+	fsys.orgs = orgs
+}
+
+// userDir is where a user's months and tarballs are kept
+func (fsys *FSStorage) userDir(user string) string {
+	return filepath.Join(fsys.root, fsys.orgs.Org(user), user)
+}
+
+// OrgUser is a member in an org listing
+type OrgUser struct {
+	OrgMember
+	Months []string `json:"months"`
+}
+
+// ListOrg lists an organization's members and their months, for the org's
+// admins and server admins
+func (s *Server) ListOrg(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	org := r.PathValue("org")
+	members := s.orgs.Members(org)
+	isAdmin := slices.Contains(s.userRoles(username), RoleAdmin)
+	if !isAdmin && (len(members) == 0 || !s.orgs.isOrgAdmin(username, members[0].User)) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "Only the org's admins can list it")
+		return
+	}
+	if len(members) == 0 {
+		s.jsonError(w, http.StatusNotFound, "org_not_found", "Org not found", fmt.Sprintf("No org %q", org))
+		return
+	}
+
+	results := []OrgUser{}
+	for _, member := range members {
+		months := []string{}
+		userMonths, err := s.store.Months(member.User)
+		if err != nil && !os.IsNotExist(err) {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+			return
+		}
+		for _, month := range userMonths {
+			months = append(months, month.Name)
+		}
+		results = append(results, OrgUser{OrgMember: member, Months: months})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"org":     org,
+		"results": results,
+	})
+}
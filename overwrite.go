@@ -0,0 +1,285 @@
+package logapi
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// What an upload does to a file that exists already
+const (
+	// OverwriteAllow replaces the file (the default)
+	OverwriteAllow = "allow"
+	// OverwriteDeny refuses the upload with a 409
+	OverwriteDeny = "deny"
+	// OverwriteVersion replaces the file, keeping what it had as a version
+	OverwriteVersion = "version"
+	// OverwriteAppendSuffix keeps the file, storing the upload under the
+	// next free name: app.log as app-1.log, then app-2.log
+	OverwriteAppendSuffix = "append-suffix"
+)
+
+// overwritePolicies are the valid overwrite policies
+var overwritePolicies = []string{OverwriteAllow, OverwriteDeny, OverwriteVersion, OverwriteAppendSuffix}
+
+// overwriteHeader tells uploaders the overwrite policy their uploads get
+const overwriteHeader = "X-Overwrite-Policy"
+
+// versionsDir holds the prior versions of a user's live files, as
+// .versions/{month}/{name}@{n}
+const versionsDir = ".versions"
+
+// ErrExists is returned for an upload that may not replace an existing file
+var ErrExists = errors.New("file exists")
+
+// WithOverwritePolicy chooses OverwriteAllow (the default), OverwriteDeny,
+// OverwriteVersion or OverwriteAppendSuffix for uploads of files that exist
+// already. Versions are kept by the default storage; with WithStorage,
+// call FSStorage.SetKeepVersionsFor(server.KeepsVersions) too.
+func WithOverwritePolicy(policy string) Option {
+	return func(s *Server) {
+		s.overwrite = policy
+	}
+}
+
+// WithUserOverwritePolicies gives users their own overwrite policies, in
+// place of WithOverwritePolicy's
+func WithUserOverwritePolicies(policies map[string]string) Option {
+	return func(s *Server) {
+		s.userOverwrite = policies
+	}
+}
+
+// LoadOverwritePolicies reads a user, policy TSV
+func LoadOverwritePolicies(f *os.File) (map[string]string, error) {
+	policies := make(map[string]string)
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 2 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		if !slices.Contains(overwritePolicies, record[1]) {
+			return nil, fmt.Errorf("invalid overwrite policy for %q in %q: %s", record[0], f.Name(), record[1])
+		}
+		policies[record[0]] = record[1]
+	}
+
+	return policies, nil
+}
+
+// OverwritePolicy returns the overwrite policy of a user's uploads
+func (s *Server) OverwritePolicy(user string) string {
+	if policy, ok := s.userOverwrite[user]; ok {
+		return policy
+	}
+	return s.overwrite
+}
+
+// KeepsVersions reports whether uploads replacing a user's files keep what
+// they had, for FSStorage.SetKeepVersionsFor
+func (s *Server) KeepsVersions(user string) bool {
+	return s.OverwritePolicy(user) == OverwriteVersion
+}
+
+// SetKeepVersions keeps what a live file had before each Put that changes
+// it, as {name}@1, @2 and so on under the user's .versions directory.
+// Call it before use.
+func (fsys *FSStorage) SetKeepVersions(on bool) {
+	fsys.versions = func(string) bool { return on }
+}
+
+// SetKeepVersionsFor is SetKeepVersions for the users keep reports true
+// for. Call it before use.
+func (fsys *FSStorage) SetKeepVersionsFor(keep func(user string) bool) {
+	fsys.versions = keep
+}
+
+// keepVersion links a live file that's about to be replaced into the
+// versions directory, as the next version of it. The caller holds its lock.
+func (fsys *FSStorage) keepVersion(user, date, name string) error {
+	dir, base, err := fsys.versionDir(user, date, name)
+	if err != nil {
+		return err
+	}
+	versions, err := fsys.Versions(user, date, name)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	filePath, _ := fsys.filePath(user, date, name)
+	err = os.Link(filePath, filepath.Join(dir, fmt.Sprintf("%s@%d", base, next)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// noOverwrite reports whether a user's upload may only create files,
+// because of If-None-Match: *, X-No-Overwrite: true or OverwriteDeny
+func (s *Server) noOverwrite(r *http.Request, user string) bool {
+	return s.OverwritePolicy(user) == OverwriteDeny ||
+		strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" ||
+		strings.EqualFold(r.Header.Get("X-No-Overwrite"), "true")
+}
+
+// storedFile is what putFile did with an upload
+type storedFile struct {
+	name     string // as stored, which OverwriteAppendSuffix may have changed
+	changed  bool   // false when it had the same content already
+	replaced bool   // it replaced a file with other content
+}
+
+// putFile stores an uploaded file under the user's overwrite policy,
+// failing with ErrExists if it may not replace one
+func (s *Server) putFile(user, date, name string, body io.Reader, noOverwrite bool) (storedFile, error) {
+	stored := storedFile{name: name}
+	policy := s.OverwritePolicy(user)
+	if noOverwrite || policy == OverwriteDeny {
+		err := s.createFile(user, date, name, body)
+		if errors.Is(err, fs.ErrExist) {
+			return stored, existsError(date, name, policy)
+		}
+		if err != nil {
+			return stored, err
+		}
+		stored.changed = true
+		s.notifyUpload(user, date, name)
+		return stored, nil
+	}
+
+	if policy == OverwriteAppendSuffix {
+		candidate, err := s.createFirst(user, date, name, body)
+		if err != nil {
+			return stored, err
+		}
+		stored.name, stored.changed = candidate, true
+		s.notifyUpload(user, date, candidate)
+		return stored, nil
+	}
+
+	_, err := s.store.Stat(user, date, name)
+	exists := err == nil
+	stored.changed = true
+	if deduper, ok := s.store.(Deduper); ok {
+		if stored.changed, err = deduper.PutIfChanged(user, date, stored.name, body); err != nil {
+			return stored, err
+		}
+	} else if err := s.store.Put(user, date, stored.name, body); err != nil {
+		return stored, err
+	}
+	stored.replaced = exists && stored.changed && stored.name == name
+	if stored.changed {
+		s.notifyUpload(user, date, stored.name)
+	}
+	return stored, nil
+}
+
+// existsError is the ErrExists of an upload that may not replace a file
+func existsError(date, name, policy string) error {
+	if policy == OverwriteDeny {
+		return fmt.Errorf("%w: %s/%s, and the overwrite policy is %s", ErrExists, date, name, policy)
+	}
+	return fmt.Errorf("%w: %s/%s", ErrExists, date, name)
+}
+
+// createFile stores a file only if there's none of that name, in one step
+// when the storage is a Creator
+func (s *Server) createFile(user, date, name string, body io.Reader) error {
+	if creator, ok := s.store.(Creator); ok {
+		return creator.Create(user, date, name, body)
+	}
+	if _, err := s.store.Stat(user, date, name); err == nil {
+		return fmt.Errorf("%w: %s/%s", fs.ErrExist, date, name)
+	}
+	return s.store.Put(user, date, name, body)
+}
+
+// createFirst stores a file under the first of name, name-1 and so on
+// that's free, and returns it. Each name is checked and taken in one step,
+// so of two uploads only one gets it, and the other goes on to the next.
+// Storage that isn't a FirstCreator can only go on while none of the body
+// has been read; a name taken after that fails with ErrExists.
+func (s *Server) createFirst(user, date, name string, body io.Reader) (string, error) {
+	names := func(n int) string { return suffixedName(name, n) }
+	if creator, ok := s.store.(FirstCreator); ok {
+		return creator.CreateFirst(user, date, names, body)
+	}
+	counted := &countingReader{r: body}
+	for n := 0; ; n++ {
+		candidate := names(n)
+		err := s.createFile(user, date, candidate, counted)
+		if errors.Is(err, fs.ErrExist) {
+			if counted.n > 0 {
+				return "", fmt.Errorf("%w: %s/%s was created during the upload", ErrExists, date, candidate)
+			}
+			continue
+		}
+		return candidate, err
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// suffixedName returns name numbered n before its extension (and any
+// compression suffix), as name-n, or name itself for 0
+func suffixedName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	base, ext := name, ""
+	if compressedExts[path.Ext(base)] {
+		ext = path.Ext(base)
+		base = strings.TrimSuffix(base, ext)
+	}
+	ext = path.Ext(base) + ext
+	base = strings.TrimSuffix(name, ext)
+	if base == "" || strings.HasSuffix(base, "/") {
+		// a dotfile is all name
+		base, ext = name, ""
+	}
+	return fmt.Sprintf("%s-%d%s", base, n, ext)
+}
+
+// fileExists writes the 409 for an upload that may not replace a file
+func (s *Server) fileExists(w http.ResponseWriter, err error) {
+	s.jsonError(w, http.StatusConflict, "file_exists", "File exists", err.Error())
+}
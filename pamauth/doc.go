@@ -0,0 +1,6 @@
+// Package pamauth verifies Basic Auth passwords against the host's Unix
+// accounts through PAM. It needs cgo and libpam, so it is only built with
+// the pam build tag:
+//
+//	go build -tags pam ./cmd/logapid
+package pamauth
@@ -0,0 +1,101 @@
+//go:build pam
+
+package pamauth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/user"
+	"slices"
+	"sync"
+
+	"github.com/msteinert/pam/v2"
+)
+
+// DefaultService is the PAM service, /etc/pam.d/logapi, New uses
+const DefaultService = "logapi"
+
+// Verifier checks passwords with a PAM service's auth and account stacks,
+// so expired and locked accounts are refused too
+type Verifier struct {
+	Service string // /etc/pam.d/<Service>
+	Group   string // optional Unix group users have to be in
+
+	// PAM modules aren't all safe to run concurrently
+	mu sync.Mutex
+}
+
+// New creates a verifier for a PAM service
+func New(service string) *Verifier {
+	return &Verifier{Service: service}
+}
+
+// Verify checks Basic Auth credentials against the user's Unix account
+func (v *Verifier) Verify(username, password string) bool {
+	if username == "" || password == "" {
+		return false
+	}
+	if v.Group != "" {
+		ok, err := inGroup(username, v.Group)
+		if err != nil {
+			log.Printf("[pamauth] looking up %q's groups: %v", username, err)
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	tx, err := pam.StartFunc(v.Service, username, func(style pam.Style, msg string) (string, error) {
+		switch style {
+		case pam.PromptEchoOff:
+			return password, nil
+		case pam.ErrorMsg, pam.TextInfo:
+			return "", nil
+		default:
+			return "", fmt.Errorf("unexpected PAM prompt %q", msg)
+		}
+	})
+	if err != nil {
+		log.Printf("[pamauth] starting %q: %v", v.Service, err)
+		return false
+	}
+	defer func() { _ = tx.End() }()
+
+	if err := tx.Authenticate(pam.Silent | pam.DisallowNullAuthtok); err != nil {
+		if !errors.Is(err, pam.ErrAuth) && !errors.Is(err, pam.ErrUserUnknown) {
+			log.Printf("[pamauth] authenticating %q: %v", username, err)
+		}
+		return false
+	}
+	if err := tx.AcctMgmt(pam.Silent); err != nil {
+		log.Printf("[pamauth] account %q: %v", username, err)
+		return false
+	}
+	return true
+}
+
+// inGroup reports whether a user is in a group, as their primary group or
+// a supplementary one
+func inGroup(username, group string) (bool, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		var unknown user.UnknownUserError
+		if errors.As(err, &unknown) {
+			return false, nil
+		}
+		return false, err
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return false, err
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return false, err
+	}
+	return u.Gid == g.Gid || slices.Contains(gids, g.Gid), nil
+}
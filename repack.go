@@ -0,0 +1,67 @@
+package logapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RepackArchives rewrites every archived month without superseded entries
+// or, with maxVolume > 0, splits each into volumes of about that many bytes.
+// It returns the files written.
+func (s *Server) RepackArchives(maxVolume int64) ([]string, error) {
+	repacker, ok := s.store.(Repacker)
+	if !ok {
+		return nil, fmt.Errorf("storage can't repack archives: %w", errors.ErrUnsupported)
+	}
+
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, user := range users {
+		months, err := s.store.Months(user)
+		if err != nil {
+			return written, err
+		}
+		for _, month := range months {
+			if !month.Archived {
+				continue
+			}
+			s.commitLock.Lock()
+			files, err := repacker.Repack(user, month.Name, maxVolume)
+			s.commitLock.Unlock()
+			written = append(written, files...)
+			if err != nil {
+				return written, fmt.Errorf("%s/%s: %w", user, month.Name, err)
+			}
+		}
+	}
+	return written, nil
+}
+
+// ConvertArchives rewrites archives left in another compression format, say
+// after --compress changed, into the current one, so they're served again.
+// It returns the tarballs written.
+func (s *Server) ConvertArchives() ([]string, error) {
+	converter, ok := s.store.(Converter)
+	if !ok {
+		return nil, fmt.Errorf("storage can't convert archives: %w", errors.ErrUnsupported)
+	}
+
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, user := range users {
+		s.commitLock.Lock()
+		files, err := converter.ConvertArchives(user)
+		s.commitLock.Unlock()
+		written = append(written, files...)
+		if err != nil {
+			return written, fmt.Errorf("%s: %w", user, err)
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,80 @@
+package logapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo is filled in as a request is handled, for RequestLog
+type requestInfo struct {
+	id   string
+	user string
+}
+
+type requestInfoKey struct{}
+
+// RequestLog assigns each request an X-Request-ID (keeping a well-formed
+// one from the client), echoes it in the response and in JSON errors,
+// and logs one line per request to logger
+func RequestLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			idBytes := make([]byte, 8)
+			_, _ = rand.Read(idBytes)
+			id = hex.EncodeToString(idBytes)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r)
+
+		status := rr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("user", info.user),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int64("bytes", rr.bytes),
+		)
+	})
+}
+
+// validRequestID limits client-supplied ids to short, log-safe tokens
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setRequestUser records the authenticated user for RequestLog
+func setRequestUser(r *http.Request, username string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.user = username
+	}
+}
@@ -0,0 +1,175 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const defaultResetTTL = 24 * time.Hour
+
+// PasswordSetter is implemented by credential stores that can change a
+// user's password
+type PasswordSetter interface {
+	SetPassword(username, password string) error
+}
+
+// ResetTokenIssuer mints and redeems single-use password reset tokens
+type ResetTokenIssuer interface {
+	// IssueResetToken returns a new token for a user and when it expires
+	IssueResetToken(username string, ttl time.Duration) (string, time.Time, error)
+	// RedeemResetToken uses up a token, returning its user if it was
+	// unused and unexpired
+	RedeemResetToken(token string) (string, bool, error)
+}
+
+// WithPasswordResets lets admins issue reset tokens, and their holders set
+// a new password with them. The verifier must implement PasswordSetter.
+func WithPasswordResets(issuer ResetTokenIssuer) Option {
+	return func(s *Server) {
+		s.resets = issuer
+	}
+}
+
+// WithPasswordPolicy rejects new passwords, set with a reset token, that
+// check returns an error for, answering 400 invalid_password with it (see
+// csvpass.PasswordPolicy)
+func WithPasswordPolicy(check func(password string) error) Option {
+	return func(s *Server) {
+		s.passwordPolicy = check
+	}
+}
+
+// ResetTokenRequest represents the POST /api/users/{user}/reset-tokens
+// JSON body
+type ResetTokenRequest struct {
+	ExpiresIn string `json:"expires_in"`
+}
+
+// PasswordResetRequest represents the POST /api/password-reset JSON body
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// passwordSetter returns the verifier as a PasswordSetter, if resets are
+// enabled and it is one
+func (s *Server) passwordSetter() (PasswordSetter, bool) {
+	if s.resets == nil {
+		return nil, false
+	}
+	setter, ok := s.auth.(PasswordSetter)
+	return setter, ok
+}
+
+// CreateResetToken issues a password reset token for a user (admin only)
+func (s *Server) CreateResetToken(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+	if _, ok := s.passwordSetter(); !ok {
+		s.jsonError(w, http.StatusNotImplemented, "reset_unsupported", "Password resets not supported", "This server can't change passwords")
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.validUser(w, user) {
+		return
+	}
+
+	var req ResetTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+			return
+		}
+	}
+	ttl := defaultResetTTL
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			s.jsonError(w, http.StatusBadRequest, "invalid_expires_in", "Invalid expiration", "expires_in must be a positive duration such as 24h")
+			return
+		}
+	}
+
+	token, expires, err := s.resets.IssueResetToken(user, ttl)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	s.audit("reset_token_created",
+		slog.String("user", user),
+		slog.String("by", username),
+		slog.Time("expires", expires),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"token":   token,
+		"user":    user,
+		"expires": expires,
+	})
+}
+
+// ResetPassword sets a new password with a reset token, which is used up
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	setter, ok := s.passwordSetter()
+	if !ok {
+		s.jsonError(w, http.StatusNotImplemented, "reset_unsupported", "Password resets not supported", "This server can't change passwords")
+		return
+	}
+
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+		return
+	}
+	if req.Password == "" {
+		s.jsonError(w, http.StatusBadRequest, "invalid_password", "Invalid password", "password must not be empty")
+		return
+	}
+	if s.passwordPolicy != nil {
+		if err := s.passwordPolicy(req.Password); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_password", "Invalid password", err.Error())
+			return
+		}
+	}
+
+	user, ok, err := s.resets.RedeemResetToken(req.Token)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	if !ok {
+		s.jsonError(w, http.StatusBadRequest, "invalid_reset_token", "Invalid reset token", "The reset token is unknown, already used, or expired")
+		return
+	}
+	if err := setter.SetPassword(user, req.Password); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s.jsonError(w, http.StatusNotFound, "user_not_found", "User not found", err.Error())
+			return
+		}
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	setRequestUser(r, user)
+	s.audit("password_reset", slog.String("user", user))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": "Password changed for " + user,
+	})
+}
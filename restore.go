@@ -0,0 +1,145 @@
+package logapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/paperos-labs/logapi/objstore"
+)
+
+var _ Restorer = (*FSStorage)(nil)
+
+// RestoreStatus reports whether a month can be read; only cold months in
+// an archive tier can't
+func (fsys *FSStorage) RestoreStatus(user, date string) (string, error) {
+	return fsys.restore(user, date, false)
+}
+
+// Restore starts restoring a cold month's tarball in the cold store, for
+// reads to fetch it once it's done
+func (fsys *FSStorage) Restore(user, date string) (string, error) {
+	return fsys.restore(user, date, true)
+}
+
+func (fsys *FSStorage) restore(user, date string, start bool) (string, error) {
+	if _, ok := fsys.tarPath(user, date); ok {
+		return objstore.Available, nil
+	}
+	stubPath, cold := fsys.coldStubPath(user, date)
+	restorer, ok := fsys.cold.(ColdRestorer)
+	if !cold || !ok {
+		return objstore.Available, nil
+	}
+	stub, err := readColdStub(stubPath)
+	if err != nil {
+		return "", err
+	}
+	if start {
+		return restorer.Restore(context.Background(), stub.Key)
+	}
+	return restorer.RestoreStatus(context.Background(), stub.Key)
+}
+
+// RestoreMonth handles POST /api/logs/{user}/{date}/restore: it starts
+// restoring a month whose tarball is in cold storage's archive tier, with
+// a 202 and a Location to poll (GetRestore). Once restored, the month's
+// files are read as usual. A month that can be read already gets a 200.
+func (s *Server) RestoreMonth(w http.ResponseWriter, r *http.Request) {
+	s.writeRestore(w, r, true)
+}
+
+// GetRestore handles GET /api/restores/{user}/{date}: a 202 while a
+// month is being restored, a 200 once it can be read, and a 409 if it's
+// archived with no restore under way (or its restored copy has expired)
+func (s *Server) GetRestore(w http.ResponseWriter, r *http.Request) {
+	s.writeRestore(w, r, false)
+}
+
+func (s *Server) writeRestore(w http.ResponseWriter, r *http.Request, start bool) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	date := r.PathValue("date")
+	if !s.validUser(w, user) {
+		return
+	}
+	if _, err := time.Parse("2006-01", date); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", "Date must be YYYY-MM")
+		return
+	}
+	if !s.canRead(username, user) && s.grantedPrefixes(username, user, date) == nil {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files, or those granted to you")
+		return
+	}
+	if !s.monthExists(user, date) {
+		s.jsonError(w, http.StatusNotFound, "month_not_found", "Month not found", fmt.Sprintf("%s/%s does not exist", user, date))
+		return
+	}
+
+	status := objstore.Available
+	if restorer, ok := s.store.(Restorer); ok {
+		var err error
+		if status, err = restorer.RestoreStatus(user, date); err != nil {
+			s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+			return
+		}
+		if start && status == objstore.Archived {
+			if status, err = restorer.Restore(user, date); err != nil {
+				s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+				return
+			}
+			s.audit("restore_requested",
+				slog.String("user", user),
+				slog.String("month", date),
+				slog.String("by", username),
+			)
+		}
+	}
+
+	var code int
+	var message string
+	switch status {
+	case objstore.Archived:
+		s.restoreRequired(w, user, date)
+		return
+	case objstore.Restoring:
+		code = http.StatusAccepted
+		message = fmt.Sprintf("Restoring %s/%s from cold storage", user, date)
+		w.Header().Set("Location", fmt.Sprintf("/api/restores/%s/%s", user, date))
+	default:
+		code = http.StatusOK
+		message = fmt.Sprintf("%s/%s can be read", user, date)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"message": message,
+		"status":  status,
+	})
+}
+
+// fileNotFound writes the 404 for a month or file storage couldn't read,
+// or the 409 if the month has to be restored first
+func (s *Server) fileNotFound(w http.ResponseWriter, user, date string, err error) {
+	if errors.Is(err, ErrRestoreRequired) {
+		s.restoreRequired(w, user, date)
+		return
+	}
+	s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", err.Error())
+}
+
+func (s *Server) restoreRequired(w http.ResponseWriter, user, date string) {
+	s.jsonError(w, http.StatusConflict, "restore_required", "Restore required", fmt.Sprintf("%s/%s is in cold storage's archive tier; POST /api/logs/%s/%s/restore, then poll GET /api/restores/%s/%s until it's restored", user, date, user, date, user, date))
+}
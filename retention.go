@@ -0,0 +1,121 @@
+package logapi
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RetentionPolicy deletes archived months once they are older than a
+// number of months. Zero keeps everything. A month that has live files as
+// well is left until compression has added them to its tarball.
+type RetentionPolicy struct {
+	Months int
+	// Users overrides Months for specific users
+	Users map[string]int
+	// DryRun reports what would be deleted without deleting it
+	DryRun bool
+}
+
+// monthsFor returns the retention for a user
+func (p RetentionPolicy) monthsFor(user string) int {
+	if months, ok := p.Users[user]; ok {
+		return months
+	}
+	return p.Months
+}
+
+// RetentionResult is a month ApplyRetentionEach deleted, or in a dry run
+// would delete
+type RetentionResult struct {
+	User   string
+	Month  string
+	DryRun bool
+	// Bytes is the size of the month's tarball, if the storage can tell
+	Bytes int64
+	// Err is why the month couldn't be deleted
+	Err error
+}
+
+// ApplyRetention deletes every archived month past its user's retention,
+// returning "user/YYYY-MM" for each. Each deletion (or, in a dry run, each
+// month that would be deleted) is recorded in the audit log, as is each
+// month that couldn't be deleted, whose errors it returns joined.
+func (s *Server) ApplyRetention(now time.Time, policy RetentionPolicy) ([]string, error) {
+	var (
+		expired []string
+		errs    []error
+	)
+	err := s.ApplyRetentionEach(now, policy, func(result RetentionResult) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", result.User, result.Month, result.Err))
+			return
+		}
+		expired = append(expired, result.User+"/"+result.Month)
+	})
+	return expired, errors.Join(append(errs, err)...)
+}
+
+// ApplyRetentionEach is ApplyRetention, calling fn for each month as it's
+// deleted. A month that can't be deleted is passed to fn with its Err, and
+// the rest are still deleted.
+func (s *Server) ApplyRetentionEach(now time.Time, policy RetentionPolicy, fn func(RetentionResult)) error {
+	users, err := s.store.Users()
+	if err != nil {
+		return err
+	}
+	sizer, _ := s.store.(ArchiveSizer)
+	for _, user := range users {
+		keep := policy.monthsFor(user)
+		if keep <= 0 {
+			continue
+		}
+		cutoff := now.UTC().AddDate(0, -keep, 0).Format("2006-01")
+
+		months, err := s.store.Months(user)
+		if err != nil {
+			continue
+		}
+		for _, month := range months {
+			if !month.Archived || month.Live || month.Name >= cutoff {
+				continue
+			}
+
+			result := RetentionResult{User: user, Month: month.Name, DryRun: policy.DryRun}
+			if sizer != nil {
+				result.Bytes, _ = sizer.ArchiveSize(user, month.Name)
+			}
+			if !policy.DryRun {
+				s.commitLock.Lock()
+				_, err := s.store.RemoveMonth(user, month.Name)
+				s.commitLock.Unlock()
+				if err != nil {
+					result.Err = err
+					s.audit("retention_delete_failed",
+						slog.String("user", user),
+						slog.String("month", month.Name),
+						slog.String("error", err.Error()),
+					)
+					if fn != nil {
+						fn(result)
+					}
+					continue
+				}
+				s.notify(Event{Event: EventDelete, User: user, Date: month.Name})
+			}
+			s.audit("retention_delete",
+				slog.String("user", user),
+				slog.String("month", month.Name),
+				slog.Int("retention_months", keep),
+				slog.Int64("bytes", result.Bytes),
+				slog.Bool("dry_run", policy.DryRun),
+			)
+			if fn != nil {
+				fn(result)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package logapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const (
+	RoleUpload = "upload"
+	RoleRead   = "read"
+	RoleAdmin  = "admin"
+)
+
+// defaultRoles apply to users the RoleProvider doesn't know (e.g. from a JWT)
+// and to verifiers without roles at all, matching the original behavior
+var defaultRoles = []string{RoleUpload, RoleRead}
+
+// RoleProvider is implemented by credential stores that assign roles.
+// It returns nil for unknown users.
+type RoleProvider interface {
+	Roles(username string) []string
+}
+
+// userRoles returns a user's roles, including admin for --admin-users
+func (s *Server) userRoles(username string) []string {
+	roles := defaultRoles
+	if rp, ok := s.auth.(RoleProvider); ok {
+		if userRoles := rp.Roles(username); userRoles != nil {
+			roles = userRoles
+		}
+	}
+	if s.adminUsers[username] {
+		roles = append(slices.Clone(roles), RoleAdmin)
+	}
+	return roles
+}
+
+// hasRole reports whether a user holds a role; admin implies every role
+func (s *Server) hasRole(username, role string) bool {
+	roles := s.userRoles(username)
+	return slices.Contains(roles, role) || slices.Contains(roles, RoleAdmin)
+}
+
+// canAccess reports whether a user may read another user's files:
+// their own, or anyone's for admins
+func (s *Server) canAccess(username, user string) bool {
+	return username == user || slices.Contains(s.userRoles(username), RoleAdmin)
+}
+
+// canRead is canAccess, plus an org admin's read access to their members'
+// files
+func (s *Server) canRead(username, user string) bool {
+	return s.canAccess(username, user) || s.orgs.isOrgAdmin(username, user)
+}
+
+// authorize writes a 403 unless the user holds the role
+func (s *Server) authorize(w http.ResponseWriter, username, role string) bool {
+	if s.hasRole(username, role) {
+		return true
+	}
+	s.jsonError(w, http.StatusForbidden, "missing_role", "Forbidden", fmt.Sprintf("The %q role is required", role))
+	return false
+}
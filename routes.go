@@ -0,0 +1,430 @@
+package logapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Route is one API endpoint. Routes() is the single list used both to
+// register handlers and to describe them in /api/openapi.json.
+type Route struct {
+	Method  string
+	Path    string
+	Summary string
+	// Public routes don't take credentials (share tokens are in the path)
+	Public  bool
+	Headers []Param
+	Query   []Param
+	// Errors lists the JSONError codes the handler may return
+	Errors  []string
+	Handler http.HandlerFunc
+}
+
+// Param is a request header or query parameter
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// errorStatus maps each JSONError code to its HTTP status
+var errorStatus = map[string]int{
+	"unauthorized":          http.StatusUnauthorized,
+	"missing_role":          http.StatusForbidden,
+	"too_many_attempts":     http.StatusTooManyRequests,
+	"address_not_allowed":   http.StatusForbidden,
+	"address_banned":        http.StatusForbidden,
+	"forbidden":             http.StatusForbidden,
+	"missing_headers":       http.StatusBadRequest,
+	"date_out_of_range":     http.StatusBadRequest,
+	"invalid_date":          http.StatusBadRequest,
+	"invalid_limit":         http.StatusBadRequest,
+	"invalid_body":          http.StatusBadRequest,
+	"invalid_multipart":     http.StatusBadRequest,
+	"invalid_name":          http.StatusBadRequest,
+	"invalid_user":          http.StatusBadRequest,
+	"invalid_encoding":      http.StatusBadRequest,
+	"invalid_expires_in":    http.StatusBadRequest,
+	"invalid_append":        http.StatusBadRequest,
+	"invalid_query":         http.StatusBadRequest,
+	"invalid_filter":        http.StatusBadRequest,
+	"invalid_grantee":       http.StatusBadRequest,
+	"invalid_password":      http.StatusBadRequest,
+	"invalid_reset_token":   http.StatusBadRequest,
+	"invalid_login_state":   http.StatusBadRequest,
+	"no_files":              http.StatusBadRequest,
+	"file_not_found":        http.StatusNotFound,
+	"month_not_found":       http.StatusNotFound,
+	"share_not_found":       http.StatusNotFound,
+	"grant_not_found":       http.StatusNotFound,
+	"upload_not_found":      http.StatusNotFound,
+	"user_not_found":        http.StatusNotFound,
+	"org_not_found":         http.StatusNotFound,
+	"ban_not_found":         http.StatusNotFound,
+	"job_not_found":         http.StatusNotFound,
+	"file_archived":         http.StatusConflict,
+	"file_exists":           http.StatusConflict,
+	"month_exists":          http.StatusConflict,
+	"restore_required":      http.StatusConflict,
+	"name_too_long":         http.StatusUnprocessableEntity,
+	"invalid_name_chars":    http.StatusUnprocessableEntity,
+	"extension_not_allowed": http.StatusUnprocessableEntity,
+	"suffix_required":       http.StatusUnprocessableEntity,
+	"invalid_version":       http.StatusBadRequest,
+	"version_not_found":     http.StatusNotFound,
+	"versions_unsupported":  http.StatusNotImplemented,
+	"upload_too_large":      http.StatusRequestEntityTooLarge,
+	"upload_timeout":        http.StatusRequestTimeout,
+	"too_many_uploads":      http.StatusServiceUnavailable,
+	"unsupported_encoding":  http.StatusUnsupportedMediaType,
+	"confirmation_required": http.StatusPreconditionRequired,
+	"server_error":          http.StatusInternalServerError,
+	"write_failed":          http.StatusInternalServerError,
+	"commit_failed":         http.StatusInternalServerError,
+	"append_unsupported":    http.StatusNotImplemented,
+	"search_unsupported":    http.StatusNotImplemented,
+	"reset_unsupported":     http.StatusNotImplemented,
+	"oidc_unsupported":      http.StatusNotImplemented,
+}
+
+// Routes returns every endpoint, in registration order
+func (s *Server) Routes() []Route {
+	return []Route{
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/logs",
+			Summary: "Upload a file, or several as multipart/form-data or application/x-tar",
+			Headers: []Param{
+				{Name: "X-File-Date", Description: "Month (YYYY-MM) or day (YYYY-MM-DD) to store the file under", Required: true},
+				{Name: "X-File-Name", Description: "File name (not needed for multi-file uploads)"},
+				{Name: "X-Upload-ID", Description: "Stage the file in an open upload transaction"},
+				{Name: "X-File-Append", Description: "true to add the body's lines to the end of a live file instead of replacing it"},
+				{Name: "Content-Encoding", Description: "gzip or zstd, for pre-compressed files"},
+				{Name: "X-Content-SHA256", Description: "Hex SHA-256 of the file as stored; a 200 without reading the body if it's stored already"},
+				{Name: "If-None-Match", Description: "* to only create files, with a 409 for one that exists"},
+				{Name: "X-No-Overwrite", Description: "true, the same as If-None-Match: *"},
+			},
+			Errors:  []string{"missing_headers", "invalid_user", "invalid_date", "date_out_of_range", "upload_not_found", "invalid_multipart", "invalid_body", "invalid_name", "name_too_long", "invalid_name_chars", "extension_not_allowed", "suffix_required", "invalid_encoding", "invalid_append", "no_files", "unsupported_encoding", "upload_too_large", "upload_timeout", "too_many_uploads", "file_archived", "file_exists", "append_unsupported", "write_failed", "server_error"},
+			Handler: s.UploadLog,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/logs/{user}",
+			Summary: "List a user's months, or every file with ?recursive=true or of a range of months with ?from= and ?to=",
+			Query: []Param{
+				{Name: "recursive", Description: "true to list every file of every month"},
+				{Name: "from", Description: "List every file from this month (YYYY-MM) on"},
+				{Name: "to", Description: "List every file up to and including this month (YYYY-MM)"},
+				{Name: "limit", Description: "Page size for recursive listings (default 1000, max 10000)"},
+				{Name: "cursor", Description: "The previous page's next value"},
+			},
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "invalid_limit", "server_error"},
+			Handler: s.ListMonths,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/logs/{user}/stats",
+			Summary: "Count a user's files and bytes, live and archived",
+			Errors:  []string{"forbidden", "invalid_user", "server_error"},
+			Handler: s.GetUserStats,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/logs/{user}/{date}",
+			Summary: "List the files of a month, or search their lines with ?q=",
+			Query: []Param{
+				{Name: "q", Description: "Text to search the month's (or day's) files for"},
+				{Name: "limit", Description: "Most matching lines to return (default 100, max 1000)"},
+			},
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "invalid_query", "invalid_limit", "file_not_found", "restore_required", "search_unsupported", "server_error"},
+			Handler: s.ListFiles,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/logs/{user}/{date}/{name...}",
+			Summary: "Download a file (HEAD for its size, date and SHA-256), or list a subdirectory ending in /",
+			Headers: []Param{
+				{Name: "If-None-Match", Description: "ETag from a previous download"},
+				{Name: "Accept-Encoding", Description: "zstd to get a file archived in a .tar.zst month as stored, with Content-Encoding: zstd"},
+			},
+			Query: []Param{
+				{Name: "field", Description: "Only return NDJSON records whose field (a dotted path) has the matching value; repeatable"},
+				{Name: "value", Description: "The value for each field"},
+				{Name: "from", Description: "Only return records at or after this time (RFC 3339 or YYYY-MM-DD)"},
+				{Name: "to", Description: "Only return records before this time"},
+				{Name: "time_field", Description: "The records' time field (default: time, timestamp, ts or @timestamp)"},
+				{Name: "versions", Description: "true to list the file's kept versions (see --overwrite version)"},
+				{Name: "version", Description: "Download this kept version of the file"},
+				{Name: "decompress", Description: "true to download a stored .gz or .zst file's content (with Content-Encoding, if accepted)"},
+			},
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "invalid_name", "invalid_filter", "invalid_version", "file_not_found", "restore_required", "version_not_found", "versions_unsupported", "server_error"},
+			Handler: s.GetFile,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/logs/{user}/{date}",
+			Summary: "Delete a whole month (admin only)",
+			Headers: []Param{
+				{Name: "X-Confirm-Delete", Description: "Must repeat the month being deleted", Required: true},
+			},
+			Errors:  []string{"invalid_user", "invalid_date", "confirmation_required", "month_not_found", "server_error"},
+			Handler: s.DeleteMonth,
+		},
+		{
+			Method:  http.MethodPut,
+			Path:    "/api/logs/{user}/{date}/archive",
+			Summary: "Import a month with no files yet from a tar, .tar.gz or .tar.zst body, archiving it (admin only)",
+			Query: []Param{
+				{Name: "live", Description: "true to leave the imported month live rather than archive it"},
+			},
+			Headers: []Param{
+				{Name: "Content-Encoding", Description: "gzip or zstd; compressed tarballs are also recognized without it"},
+			},
+			Errors:  []string{"invalid_user", "invalid_date", "date_out_of_range", "month_exists", "too_many_uploads", "unsupported_encoding", "invalid_encoding", "invalid_body", "invalid_name", "name_too_long", "invalid_name_chars", "extension_not_allowed", "suffix_required", "no_files", "write_failed", "server_error"},
+			Handler: s.ImportMonth,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/logs/{user}/{date}/restore",
+			Summary: "Restore a month from cold storage's archive tier (202, then poll the Location), so it can be read",
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "month_not_found", "server_error"},
+			Handler: s.RestoreMonth,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/logs/{user}/{date}/{name...}",
+			Summary: "Delete a file from a live month",
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "invalid_name", "file_archived", "file_not_found", "server_error"},
+			Handler: s.DeleteFile,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/logs/{user}/{date}/shares",
+			Summary: "Create a share link for a month or a file",
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "invalid_name", "invalid_body", "invalid_expires_in", "server_error"},
+			Handler: s.CreateShare,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/logs/{user}/grants",
+			Summary: "Let another account read a user's files, or a month or name prefix of them, for a while",
+			Errors:  []string{"forbidden", "invalid_user", "invalid_body", "invalid_grantee", "invalid_date", "invalid_name", "invalid_expires_in", "server_error"},
+			Handler: s.CreateGrant,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/logs/{user}/grants",
+			Summary: "List the grants of a user's files",
+			Errors:  []string{"forbidden", "invalid_user"},
+			Handler: s.ListGrants,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/logs/{user}/grants/{id}",
+			Summary: "Revoke a grant of a user's files",
+			Errors:  []string{"forbidden", "grant_not_found", "server_error"},
+			Handler: s.RevokeGrant,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/grants",
+			Summary: "List the grants of other users' files given to you",
+			Handler: s.ListHeldGrants,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/restores/{user}/{date}",
+			Summary: "Check a month's restore from cold storage: 202 while it runs, 200 once the month can be read",
+			Errors:  []string{"forbidden", "invalid_user", "invalid_date", "month_not_found", "restore_required", "server_error"},
+			Handler: s.GetRestore,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/shares/{token}",
+			Summary: "Revoke a share link",
+			Errors:  []string{"share_not_found", "server_error"},
+			Handler: s.RevokeShare,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/uploads",
+			Summary: "Open a staged upload transaction",
+			Errors:  []string{"server_error"},
+			Handler: s.BeginUpload,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/uploads/{id}/commit",
+			Summary: "Publish every file of a staged upload",
+			Errors:  []string{"upload_not_found", "file_exists", "commit_failed", "server_error"},
+			Handler: s.CommitUpload,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/uploads/{id}",
+			Summary: "Discard a staged upload",
+			Errors:  []string{"upload_not_found", "server_error"},
+			Handler: s.AbortUpload,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/archives/verify",
+			Summary: "Read every archived month and report corrupt ones (admin only)",
+			Errors:  []string{"server_error"},
+			Handler: s.VerifyArchives,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/stats",
+			Summary: "Count every user's files and bytes, live and archived (admin only)",
+			Errors:  []string{"server_error"},
+			Handler: s.GetStats,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/compress-jobs",
+			Summary: "List the last 50 compression runs, newest first, with their progress (admin only)",
+			Errors:  []string{"server_error"},
+			Handler: s.ListCompressJobs,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/compress-jobs/{id}",
+			Summary: "Get a compression run with each month it has compressed so far (admin only)",
+			Errors:  []string{"job_not_found", "server_error"},
+			Handler: s.GetCompressJob,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/bans",
+			Summary: "List the addresses banned for failed logins (admin only)",
+			Handler: s.ListBans,
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/api/bans/{address}",
+			Summary: "Lift an address's ban (admin only)",
+			Errors:  []string{"ban_not_found"},
+			Handler: s.LiftBan,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/metrics",
+			Summary: "Failed logins and IP bans, in the Prometheus text format (admin only)",
+			Handler: s.GetMetrics,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/orgs/{org}",
+			Summary: "List an org's members, their org roles and months (org admins and admins)",
+			Errors:  []string{"forbidden", "org_not_found", "server_error"},
+			Handler: s.ListOrg,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/users/{user}/reset-tokens",
+			Summary: "Issue a single-use password reset token for a user (admin only)",
+			Errors:  []string{"invalid_user", "invalid_body", "invalid_expires_in", "reset_unsupported", "server_error"},
+			Handler: s.CreateResetToken,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/api/password-reset",
+			Summary: "Set a new password with a reset token",
+			Public:  true,
+			Errors:  []string{"invalid_body", "invalid_password", "invalid_reset_token", "user_not_found", "reset_unsupported", "server_error"},
+			Handler: s.ResetPassword,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/auth/login",
+			Summary: "Log in with the OpenID Connect provider, for a session cookie",
+			Public:  true,
+			Query: []Param{
+				{Name: "return", Description: "Path to go back to once logged in (default /)"},
+			},
+			Errors:  []string{"oidc_unsupported"},
+			Handler: s.Login,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/auth/callback",
+			Summary: "Where the OpenID Connect provider sends the browser back to after logging in",
+			Public:  true,
+			Query: []Param{
+				{Name: "code", Description: "Authorization code", Required: true},
+				{Name: "state", Description: "The login's state", Required: true},
+			},
+			Errors:  []string{"invalid_login_state", "unauthorized", "oidc_unsupported"},
+			Handler: s.LoginCallback,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/auth/logout",
+			Summary: "Clear the session cookie",
+			Public:  true,
+			Handler: s.Logout,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/shares/{token}",
+			Summary: "List the files of a share link",
+			Public:  true,
+			Errors:  []string{"share_not_found", "file_not_found", "restore_required", "server_error"},
+			Handler: s.ListSharedFiles,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/shares/{token}/{name...}",
+			Summary: "Download a shared file",
+			Public:  true,
+			Headers: []Param{
+				{Name: "If-None-Match", Description: "ETag from a previous download"},
+			},
+			Query: []Param{
+				{Name: "decompress", Description: "true to download a stored .gz or .zst file's content (with Content-Encoding, if accepted)"},
+			},
+			Errors:  []string{"share_not_found", "invalid_name", "forbidden", "file_not_found", "restore_required", "server_error"},
+			Handler: s.GetSharedFile,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/api/openapi.json",
+			Summary: "This OpenAPI document",
+			Public:  true,
+			Handler: s.OpenAPI,
+		},
+	}
+}
+
+// Register adds every route to mux. Banned addresses are refused by all
+// of them.
+func (s *Server) Register(mux *http.ServeMux) {
+	for _, route := range s.Routes() {
+		handler := route.Handler
+		if s.bans != nil {
+			handler = s.refuseBanned(handler)
+		}
+		mux.HandleFunc(route.Method+" "+route.Path, handler)
+	}
+}
+
+// PrintRoutes writes one "METHOD /path" line per route
+func (s *Server) PrintRoutes(out io.Writer) {
+	for _, route := range s.Routes() {
+		_, _ = fmt.Fprintf(out, "   %-6s %s\n", route.Method, route.Path)
+	}
+}
+
+// pathParams returns the {wildcards} of a route pattern
+func pathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, strings.TrimSuffix(strings.Trim(segment, "{}"), "..."))
+		}
+	}
+	return params
+}
@@ -0,0 +1,89 @@
+package logapi
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLen    = 1024
+	maxSegmentLen = 255
+)
+
+// checkName is the single validator for file names, whether they come from
+// X-File-Name, a multi-upload entry, the {name} path value or a tarball
+// lookup. Names may have subdirectories, such as web-01/access.log.
+func checkName(name string) error {
+	if len(name) > maxNameLen {
+		return fmt.Errorf("%w: file name is longer than %d bytes", fs.ErrInvalid, maxNameLen)
+	}
+	if path.Ext(name) == ".tmp" {
+		return fmt.Errorf("%w: %q: .tmp files are reserved for uploads in progress", fs.ErrInvalid, name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if err := checkSegment(segment); err != nil {
+			return fmt.Errorf("%w: %q: %v", fs.ErrInvalid, name, err)
+		}
+	}
+	return nil
+}
+
+// checkSegment validates one path segment: a user name, or one directory
+// or file name within a file name
+func checkSegment(segment string) error {
+	switch {
+	case segment == "":
+		return fmt.Errorf("empty path segment")
+	case segment == "." || segment == "..":
+		return fmt.Errorf("%q is not allowed", segment)
+	case len(segment) > maxSegmentLen:
+		return fmt.Errorf("path segment is longer than %d bytes", maxSegmentLen)
+	case !utf8.ValidString(segment):
+		return fmt.Errorf("not valid UTF-8")
+	}
+	for _, c := range segment {
+		// control characters, bidi overrides, zero-width and line separators
+		if c == '\\' || unicode.IsControl(c) || unicode.In(c, unicode.Cf, unicode.Zl, unicode.Zp) {
+			return fmt.Errorf("character %U is not allowed", c)
+		}
+	}
+	return nil
+}
+
+// validUser writes a 400 unless user is a single plain path segment
+func (s *Server) validUser(w http.ResponseWriter, user string) bool {
+	if err := checkSegment(user); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_user", "Invalid user name", fmt.Sprintf("%q: %v", user, err))
+		return false
+	}
+	if s.orgs.IsOrg(user) {
+		s.jsonError(w, http.StatusBadRequest, "invalid_user", "Invalid user name", fmt.Sprintf("%q is an org, not a user", user))
+		return false
+	}
+	return true
+}
+
+// validPath checks the user, date and (optional) file name of a request
+// before any of them reach storage, writing a 400 if one is bad
+func (s *Server) validPath(w http.ResponseWriter, user, date, name string) bool {
+	if !s.validUser(w, user) {
+		return false
+	}
+	if _, err := parseDate(date); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", fmt.Sprintf("%q is not a YYYY-MM or YYYY-MM-DD date", date))
+		return false
+	}
+	if name == "" {
+		return true
+	}
+	if err := checkName(name); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_name", "Invalid file name", err.Error())
+		return false
+	}
+	return true
+}
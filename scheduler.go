@@ -0,0 +1,165 @@
+package logapi
+
+import (
+	"log"
+	"time"
+)
+
+// Scheduler compresses stale months, then applies retention and moves old
+// tarballs to cold storage, on a cron schedule
+type Scheduler struct {
+	server     *Server
+	schedule   *Schedule
+	staleAfter time.Duration
+	retention  RetentionPolicy
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewScheduler parses a cron expression such as "0 3 15 * *". With an
+// empty one, the scheduler only runs when Run is called.
+func NewScheduler(server *Server, cron string, staleAfter time.Duration, retention RetentionPolicy) (*Scheduler, error) {
+	var schedule *Schedule
+	if len(cron) > 0 {
+		var err error
+		if schedule, err = ParseSchedule(cron); err != nil {
+			return nil, err
+		}
+	}
+	return &Scheduler{
+		server:     server,
+		schedule:   schedule,
+		staleAfter: staleAfter,
+		retention:  retention,
+	}, nil
+}
+
+// Run compresses, applies retention, removes expired upload transactions
+// and tiers once, logging what it did
+// (compression to the server's job log, see WithJobLog). Dry runs log what
+// compression and retention would do, and how much space that would free.
+// A compression dry run also only logs which months would move to cold
+// storage, without tiering or pruning blobs; a retention one doesn't stop
+// either.
+func (sc *Scheduler) Run(now time.Time) error {
+	var compressSaved int64
+	err := sc.server.CompressEach(now, sc.staleAfter, func(result CompressResult) {
+		if result.DryRun {
+			log.Printf("Would compress %s/%s: %d files, %d MiB (saving about %d MiB)", result.User, result.Month, result.Files, result.Bytes>>20, result.SavedBytes>>20)
+			compressSaved += result.SavedBytes
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sc.server.compressDryRun {
+		log.Printf("Compression would save about %d MiB", compressSaved>>20)
+	}
+
+	var retentionFreed int64
+	err = sc.server.ApplyRetentionEach(now, sc.retention, func(result RetentionResult) {
+		switch {
+		case result.DryRun:
+			log.Printf("Retention would delete %s/%s (%d MiB)", result.User, result.Month, result.Bytes>>20)
+			retentionFreed += result.Bytes
+		case result.Err != nil:
+			log.Printf("Retention couldn't delete %s/%s: %v", result.User, result.Month, result.Err)
+		default:
+			log.Printf("Retention deleted %s/%s", result.User, result.Month)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sc.retention.DryRun {
+		log.Printf("Retention would free %d MiB", retentionFreed>>20)
+	}
+
+	// a compression dry run changes nothing, tarballs and blobs included
+	if sc.server.compressDryRun {
+		tierable, err := sc.server.tierable(now)
+		for _, month := range tierable {
+			log.Printf("Would move %s to cold storage", month)
+		}
+		return err
+	}
+
+	expired, err := sc.server.PruneUploads(now)
+	for _, upload := range expired {
+		log.Printf("Removed expired upload %s", upload)
+	}
+	if err != nil {
+		return err
+	}
+
+	tiered, err := sc.server.TierArchives(now)
+	for _, month := range tiered {
+		log.Printf("Moved %s to cold storage", month)
+	}
+	if err != nil {
+		return err
+	}
+
+	if pruner, ok := sc.server.store.(BlobPruner); ok {
+		pruned, err := pruner.PruneBlobs()
+		if pruned > 0 {
+			log.Printf("Pruned %d unused blobs", pruned)
+		}
+		return err
+	}
+	return nil
+}
+
+// Start runs in the background at each scheduled time, until Stop
+func (sc *Scheduler) Start() {
+	sc.start(false)
+}
+
+// StartNow is Start, with a run right away as well. Runs happen one at a
+// time in the background, so a long first run over years of data doesn't
+// hold up the caller or overlap the next scheduled one.
+func (sc *Scheduler) StartNow() {
+	sc.start(true)
+}
+
+func (sc *Scheduler) start(now bool) {
+	if sc.schedule == nil {
+		return
+	}
+	sc.stop = make(chan struct{})
+	sc.done = make(chan struct{})
+	go func() {
+		defer close(sc.done)
+		if now {
+			if err := sc.Run(time.Now()); err != nil {
+				log.Printf("Schedule error: %v", err)
+			}
+		}
+		for {
+			next := sc.schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-sc.stop:
+				timer.Stop()
+				return
+			case now := <-timer.C:
+				if err := sc.Run(now); err != nil {
+					log.Printf("Schedule error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the background loop, waiting for a run in progress to finish
+func (sc *Scheduler) Stop() {
+	if sc.stop == nil {
+		return
+	}
+	close(sc.stop)
+	<-sc.done
+}
@@ -0,0 +1,296 @@
+package logapi
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/paperos-labs/logapi/tarfs"
+	"github.com/paperos-labs/logapi/textindex"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	// up to this many archived files are opened one by one (through the
+	// entry cache); more, and the tarball is read through once
+	seekCandidates = 4
+)
+
+// SearchMatch is a line of a file that contains the query
+type SearchMatch struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"` // of the start of the line
+	Line   string `json:"line"`   // cut to 1 KiB
+}
+
+var (
+	_ Searcher = (*FSStorage)(nil)
+	_ Indexer  = (*FSStorage)(nil)
+)
+
+// writeSearch handles GET /api/logs/{user}/{date}?q=, listing the lines of
+// the month's (or day's) files containing q
+func (s *Server) writeSearch(w http.ResponseWriter, r *http.Request, user, date string) {
+	searcher, ok := s.store.(Searcher)
+	if !ok {
+		s.jsonError(w, http.StatusNotImplemented, "search_unsupported", "Search not supported", "This server's storage can't search files")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			s.jsonError(w, http.StatusBadRequest, "invalid_limit", "Invalid limit", fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+
+	month, prefix, _ := splitDate(date)
+	matches, more, err := searcher.Search(user, month, prefix, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrRestoreRequired) {
+			s.fileNotFound(w, user, month, err)
+			return
+		}
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results":   matches,
+		"truncated": more,
+	})
+}
+
+// IndexArchives builds the search index of every archived month that
+// doesn't have an up-to-date one, and returns the months indexed
+func (s *Server) IndexArchives() ([]string, error) {
+	indexer, ok := s.store.(Indexer)
+	if !ok {
+		return nil, fmt.Errorf("storage can't index archives: %w", errors.ErrUnsupported)
+	}
+
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, user := range users {
+		months, err := s.store.Months(user)
+		if err != nil {
+			return written, err
+		}
+		for _, month := range months {
+			if !month.Archived {
+				continue
+			}
+			s.commitLock.RLock()
+			err := indexer.IndexArchive(user, month.Name)
+			s.commitLock.RUnlock()
+			if errors.Is(err, errIndexCurrent) {
+				continue
+			}
+			if err != nil {
+				return written, fmt.Errorf("%s/%s: %w", user, month.Name, err)
+			}
+			written = append(written, user+"/"+month.Name)
+		}
+	}
+	return written, nil
+}
+
+// SetTextIndex has Archive write a search index of each month next to its
+// tarball, as {YYYY-MM}.idx. Months encrypted with SetArchiveKey aren't
+// indexed, since the index would reveal what they contain. Call it before
+// use.
+func (fsys *FSStorage) SetTextIndex(index bool) {
+	fsys.textIndex = index
+}
+
+var errIndexCurrent = errors.New("index is up to date")
+
+// indexPath is where a month's search index is kept
+func (fsys *FSStorage) indexPath(user, date string) string {
+	return filepath.Join(fsys.userDir(user), date+".idx")
+}
+
+// IndexArchive writes a month's search index from its tarball, unless it
+// already has one newer than the tarball
+func (fsys *FSStorage) IndexArchive(user, date string) error {
+	if fsys.archiveKey != nil {
+		return errIndexCurrent
+	}
+	tarPath, ok := fsys.tarPath(user, date)
+	if !ok {
+		return fmt.Errorf("%w: %s/%s has no tarball", os.ErrNotExist, user, date)
+	}
+	if _, err := fsys.loadIndex(user, date, tarPath); err == nil {
+		return errIndexCurrent
+	}
+	return fsys.writeIndex(user, date, tarPath)
+}
+
+// writeIndex reads the tarball through once and writes its index via a
+// temp file and rename
+func (fsys *FSStorage) writeIndex(user, date, tarPath string) error {
+	opts, err := fsys.archiveOptions(user)
+	if err != nil {
+		return err
+	}
+	b := textindex.NewBuilder()
+	err = tarfs.Walk(tarPath, func(hdr *tar.Header, r io.Reader) error {
+		return b.Add(strings.TrimPrefix(hdr.Name, date+"/"), r)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	idxPath := fsys.indexPath(user, date)
+	tmpPath := idxPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, idxPath)
+}
+
+// loadIndex reads a month's search index, if it has one at least as new as
+// its tarball; an older one may be missing files added since
+func (fsys *FSStorage) loadIndex(user, date, tarPath string) (*textindex.Index, error) {
+	f, err := os.Open(fsys.indexPath(user, date))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	idxInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tarInfo, err := os.Stat(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	if idxInfo.ModTime().Before(tarInfo.ModTime()) {
+		return nil, fmt.Errorf("%w: index of %s/%s is out of date", os.ErrNotExist, user, date)
+	}
+	return textindex.Read(f)
+}
+
+// Search scans live files and, of the archived ones, only those the
+// month's index allows for (all of them without an index)
+func (fsys *FSStorage) Search(user, date, prefix, query string, limit int) ([]SearchMatch, bool, error) {
+	files, err := fsys.List(user, date)
+	if err != nil {
+		return nil, false, err
+	}
+	byName := make(map[string][]SearchMatch)
+	grep := func(name string, r io.Reader) error {
+		var found []SearchMatch
+		err := textindex.Grep(r, query, func(offset int64, line []byte) bool {
+			found = append(found, SearchMatch{Name: name, Offset: offset, Line: string(line)})
+			return len(found) <= limit
+		})
+		byName[name] = found
+		return err
+	}
+
+	archived := make(map[string]bool)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name, prefix) {
+			continue
+		}
+		if file.Archived {
+			archived[file.Name] = true
+			continue
+		}
+		f, err := os.Open(filepath.Join(fsys.userDir(user), date, filepath.FromSlash(file.Name)))
+		if err != nil {
+			return nil, false, err
+		}
+		err = grep(file.Name, f)
+		_ = f.Close()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	tarPath, ok := fsys.tarPath(user, date)
+	if ok && len(archived) > 0 {
+		if ix, err := fsys.loadIndex(user, date, tarPath); err == nil {
+			if candidates, ok := ix.Candidates(query); ok {
+				wanted := make(map[string]bool)
+				for _, name := range candidates {
+					if archived[name] {
+						wanted[name] = true
+					}
+				}
+				archived = wanted
+			}
+		}
+	}
+	switch {
+	case !ok || len(archived) == 0:
+	case len(archived) <= seekCandidates:
+		for name := range archived {
+			r, err := fsys.Open(user, date, name)
+			if err != nil {
+				return nil, false, err
+			}
+			err = grep(name, r)
+			_ = r.Close()
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	default:
+		opts, err := fsys.archiveOptions(user)
+		if err != nil {
+			return nil, false, err
+		}
+		err = tarfs.Walk(tarPath, func(hdr *tar.Header, r io.Reader) error {
+			name := strings.TrimPrefix(hdr.Name, date+"/")
+			if !archived[name] {
+				return nil
+			}
+			return grep(name, r) // later entries of a name replace earlier ones
+		}, opts...)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	matches := []SearchMatch{}
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		matches = append(matches, byName[name]...)
+		if len(matches) > limit {
+			return matches[:limit], true, nil
+		}
+	}
+	return matches, false, nil
+}
@@ -1,14 +1,23 @@
 package logapi
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/paperos-labs/logapi/tarfs"
@@ -20,18 +29,122 @@ type BasicAuthVerifier interface {
 
 // Server holds application state
 type Server struct {
-	auth      BasicAuthVerifier
-	storage   string
-	compress  string
-	tarFS     map[string]*tarfs.TarFS // date -> TarFS
-	tarFSLock sync.RWMutex
+	auth           BasicAuthVerifier
+	storage        string
+	compress       string
+	store          Storage
+	shares         map[string]Share // token -> Share
+	sharesLock     sync.RWMutex
+	grants         map[string]Grant // id -> Grant
+	grantsLock     sync.RWMutex
+	realm          string
+	digest         bool
+	digestKey      []byte
+	tokens         []TokenVerifier
+	authFuncs      []AuthFunc
+	adminUsers     map[string]bool
+	commitLock     sync.RWMutex // held for writing while a staged upload is committed
+	uploadEncoding string
+	maxUpload      int64
+	maxAppendFile  int64
+	uploads        sync.WaitGroup // in-flight UploadLog calls
+	uploadSlots    chan struct{}  // bodies being written, if limited
+	auditLog       *slog.Logger
+	compressors    int
+	compressOpts   []tarfs.Option
+	compressDryRun bool
+	resets         ResetTokenIssuer
+	orgs           *Orgs
+	events         eventState
+	overwrite      string
+	userOverwrite  map[string]string // user -> overwrite policy
+	throttle       *loginThrottle    // failed logins, if limited
+	passwordPolicy func(password string) error
+	oidc           OIDCProvider // browser logins, if any
+	sessionKey     []byte
+	sessionTTL     time.Duration
+	allowlists     *IPAllowlists // per-user login addresses, if limited
+	bans           *ipBans       // addresses banned for failed logins, if any
+	authFailures   atomic.Int64
+	tierAfter      int           // months before tarballs move to cold storage, if any
+	uploadTTL      time.Duration // staged upload transactions expire after this long without files
+	jobLog         *slog.Logger
+	namePolicy     NamePolicy
+	nameChars      *regexp.Regexp // namePolicy.Charset, compiled
+}
+
+// Option configures optional Server behavior
+type Option func(*Server)
+
+// WithRealm sets the realm sent in WWW-Authenticate challenges
+func WithRealm(realm string) Option {
+	return func(s *Server) {
+		s.realm = realm
+	}
+}
+
+// WithTokens accepts "Authorization: Bearer <token>" on all endpoints.
+// It may be given more than once; verifiers are tried in order.
+func WithTokens(tokens TokenVerifier) Option {
+	return func(s *Server) {
+		s.tokens = append(s.tokens, tokens)
+	}
+}
+
+// WithAuthFunc adds an authentication method that is tried, in order,
+// before the Authorization header
+func WithAuthFunc(fn AuthFunc) Option {
+	return func(s *Server) {
+		s.authFuncs = append(s.authFuncs, fn)
+	}
+}
+
+// WithAdmins grants the admin role to the given users, in addition to
+// any roles from the credentials file
+func WithAdmins(usernames ...string) Option {
+	return func(s *Server) {
+		if s.adminUsers == nil {
+			s.adminUsers = make(map[string]bool)
+		}
+		for _, username := range usernames {
+			s.adminUsers[username] = true
+		}
+	}
+}
+
+// WithMaxUploadBytes limits the (decoded) size of a POST /api/logs body;
+// larger uploads get a 413. Zero means no limit.
+func WithMaxUploadBytes(n int64) Option {
+	return func(s *Server) {
+		s.maxUpload = n
+	}
+}
+
+// WithMaxConcurrentUploads limits how many POST /api/logs bodies are
+// written at once; more get a 503 with Retry-After. Zero means no limit.
+func WithMaxConcurrentUploads(n int) Option {
+	return func(s *Server) {
+		s.uploadSlots = nil
+		if n > 0 {
+			s.uploadSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithDigestAuth accepts HTTP Digest (MD5, qop=auth) alongside Basic Auth.
+// The verifier must implement DigestVerifier.
+func WithDigestAuth() Option {
+	return func(s *Server) {
+		s.digest = true
+	}
 }
 
 // JSONError represents an API error response
 type JSONError struct {
-	Error  string `json:"error"`
-	Code   string `json:"code"`
-	Detail string `json:"detail"`
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // Request represents the POST /api/logs JSON body
@@ -42,16 +155,64 @@ type Request struct {
 }
 
 // New initializes the server
-func New(auth BasicAuthVerifier, storage string, compress string) (*Server, error) {
-	if compress != "zst" && compress != "gz" && compress != "xz" {
+func New(auth BasicAuthVerifier, storage string, compress string, opts ...Option) (*Server, error) {
+	if !slices.Contains([]string{"zst", "gz", "xz", "br", "lz4"}, compress) {
 		return nil, fmt.Errorf("unsupported compression format: %s", compress)
 	}
 
+	shares, err := loadShares(filepath.Join(storage, sharesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := loadGrants(filepath.Join(storage, grantsFile))
+	if err != nil {
+		return nil, err
+	}
+
 	server := &Server{
-		auth:     auth,
-		storage:  storage,
-		compress: compress,
-		tarFS:    make(map[string]*tarfs.TarFS),
+		auth:           auth,
+		storage:        storage,
+		compress:       compress,
+		shares:         shares,
+		grants:         grants,
+		realm:          defaultRealm,
+		digestKey:      newDigestKey(),
+		uploadEncoding: UploadDecompress,
+		overwrite:      OverwriteAllow,
+		jobLog:         slog.Default(),
+		uploadTTL:      defaultUploadTTL,
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.store == nil {
+		fsStorage := NewFSStorage(storage, compress)
+		if err := fsStorage.SetCompressOptions(server.compressOpts...); err != nil {
+			return nil, err
+		}
+		fsStorage.SetKeepVersionsFor(server.KeepsVersions)
+		server.store = fsStorage
+	}
+	if server.uploadEncoding != UploadDecompress && server.uploadEncoding != UploadStore {
+		return nil, fmt.Errorf("unsupported upload encoding mode: %s", server.uploadEncoding)
+	}
+	if err := server.compileNamePolicy(); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(overwritePolicies, server.overwrite) {
+		return nil, fmt.Errorf("unsupported overwrite policy: %s", server.overwrite)
+	}
+	for user, policy := range server.userOverwrite {
+		if !slices.Contains(overwritePolicies, policy) {
+			return nil, fmt.Errorf("unsupported overwrite policy for %s: %s", user, policy)
+		}
+	}
+
+	if server.digest {
+		if _, ok := auth.(DigestVerifier); !ok {
+			return nil, fmt.Errorf("digest auth requires a verifier that implements DigestVerifier")
+		}
 	}
 	return server, nil
 }
@@ -62,32 +223,47 @@ func (s *Server) jsonError(w http.ResponseWriter, status int, code, errorMsg, de
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	_ = enc.Encode(JSONError{
-		Error:  errorMsg,
-		Code:   code,
-		Detail: detail,
+		Error:     errorMsg,
+		Code:      code,
+		Detail:    detail,
+		RequestID: w.Header().Get(requestIDHeader),
 	})
 }
 
 func (s *Server) UploadLog(w http.ResponseWriter, r *http.Request) {
-	username, password, ok := r.BasicAuth()
-	if !ok || !s.auth.Verify(username, password) {
-		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+	s.uploads.Add(1)
+	defer s.uploads.Done()
+
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleUpload) {
 		return
 	}
+	w.Header().Set(overwriteHeader, s.OverwritePolicy(username))
 
 	date := r.Header.Get("X-File-Date")
 	name := r.Header.Get("X-File-Name")
-	if date == "" || name == "" {
+	multi := isMultiUpload(r)
+	if date == "" || (name == "" && !multi) {
 		s.jsonError(w, http.StatusBadRequest, "missing_headers", "Missing headers", "X-File-Date and X-File-Name are required")
 		return
 	}
-
-	// Validate date (YYYY-MM, within 10 days, UTC)
-	dateTime, err := time.Parse("2006-01", date)
-	if err != nil {
-		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", "X-File-Date must be YYYY-MM")
+	if multi {
+		// each entry's name is checked as it's unpacked
+		name = ""
+	}
+	if !s.validPath(w, username, date, name) {
 		return
 	}
+	if name != "" && !s.allowedName(w, name) {
+		return
+	}
+
+	// Uploads must be for last month through tomorrow (UTC)
+	dateTime, _ := parseDate(date)
+	month, prefix, _ := splitDate(date)
 	now := time.Now().UTC()
 	firstOfCurrentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
 	firstOfLastMonth := firstOfCurrentMonth.AddDate(0, -1, 0)
@@ -109,75 +285,199 @@ func (s *Server) UploadLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dataDir := filepath.Join(s.storage, username, date)
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+	if s.maxUpload > 0 {
+		if r.ContentLength > s.maxUpload {
+			s.uploadTooLarge(w)
+			return
+		}
+	}
+	if !s.acquireUpload(w) {
+		return
+	}
+	defer s.releaseUpload()
+	appending := isAppend(r)
+	if appending && (multi || r.Header.Get("X-Upload-ID") != "") {
+		s.jsonError(w, http.StatusBadRequest, "invalid_append", "Invalid append", "X-File-Append can't be used with multi-file or staged uploads")
+		return
+	}
+	suffix, ok := s.decodeUpload(w, r, multi || appending)
+	if !ok {
+		return
+	}
+	if s.maxUpload > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	}
+	if appending {
+		s.writeAppend(w, r, username, month, prefix+name)
 		return
 	}
-	storagePath := filepath.Join(dataDir, name)
 
-	tmpPath := storagePath + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+	// a re-upload of what's stored already needn't be sent again
+	noOverwrite := s.noOverwrite(r, username)
+	sum := strings.ToLower(r.Header.Get("X-Content-SHA256"))
+	if sum != "" && !multi && !noOverwrite && r.Header.Get("X-Upload-ID") == "" && s.storedSHA256(username, month, prefix+name+suffix) == sum {
+		s.writeUnchanged(w, r)
 		return
 	}
-	defer func() { _ = tmpFile.Close() }()
 
-	if _, err := io.Copy(tmpFile, r.Body); err != nil {
-		s.jsonError(w, http.StatusInternalServerError, "write_failed", "Failed to write file", err.Error())
+	var stored storedFile
+	put := func(name string, body io.Reader) (string, error) {
+		var err error
+		stored, err = s.putFile(username, month, prefix+name, body, noOverwrite)
+		return strings.TrimPrefix(stored.name, prefix), err
+	}
+	if uploadID := r.Header.Get("X-Upload-ID"); uploadID != "" {
+		stagePath, ok := s.stagingPath(username, uploadID)
+		if !ok {
+			s.jsonError(w, http.StatusNotFound, "upload_not_found", "Upload not found", "No such upload transaction")
+			return
+		}
+		// each file staged keeps the transaction from expiring
+		now := time.Now()
+		_ = os.Chtimes(stagePath, now, now)
+		dataDir, otherDir := filepath.Join(stagePath, month), filepath.Join(stagePath, stagedCreateDir, month)
+		if noOverwrite {
+			dataDir, otherDir = otherDir, dataDir
+		}
+		put = func(name string, body io.Reader) (string, error) {
+			if noOverwrite {
+				// fail early; the commit checks again
+				if _, err := s.store.Stat(username, month, prefix+name); err == nil {
+					return name, fmt.Errorf("%w: %s/%s", ErrExists, month, prefix+name)
+				}
+			}
+			stored = storedFile{name: prefix + name, changed: true}
+			if err := saveUpload(dataDir, prefix+name, body); err != nil {
+				return name, err
+			}
+			// the last upload of a name decides whether it may overwrite
+			err := os.Remove(filepath.Join(otherDir, prefix+name))
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			return name, err
+		}
+	}
+	if multi {
+		s.writeMultiUpload(w, r, date, put)
 		return
 	}
 
-	if err := os.Rename(tmpPath, storagePath); err != nil {
-		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+	if _, err := put(name+suffix, r.Body); err != nil {
+		if isTooLarge(err) {
+			s.uploadTooLarge(w)
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.jsonError(w, http.StatusRequestTimeout, "upload_timeout", "Upload timed out", "The body wasn't received within the server's read timeout")
+			return
+		}
+		if errors.Is(err, ErrExists) {
+			s.fileExists(w, err)
+			return
+		}
+		s.jsonError(w, http.StatusInternalServerError, "write_failed", "Failed to write file", err.Error())
+		return
+	}
+	if !stored.changed {
+		s.writeUnchanged(w, r)
 		return
 	}
 
+	result := map[string]any{
+		"message": fmt.Sprintf("File uploaded: %s", r.URL.Path),
+	}
+	if stored.replaced {
+		result["message"] = fmt.Sprintf("File replaced: %s", r.URL.Path)
+		result["replaced"] = true
+	}
+	if stored.name != prefix+name+suffix {
+		result["name"] = month + "/" + stored.name
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	enc := json.NewEncoder(w)
-	_ = enc.Encode(map[string]string{
-		"message": fmt.Sprintf("File uploaded: %s", r.URL.Path),
-	})
+	_ = enc.Encode(result)
+}
+
+// WaitUploads blocks until in-flight uploads have finished, or failed and
+// removed their temp files. Call it after http.Server.Shutdown (or Close).
+func (s *Server) WaitUploads() {
+	s.uploads.Wait()
+}
+
+// uploadRetryAfter is the Retry-After, in seconds, of uploads turned away
+// by WithMaxConcurrentUploads
+const uploadRetryAfter = 5
+
+// acquireUpload takes a slot for writing an upload's body, or writes a 503
+// if they're all in use
+func (s *Server) acquireUpload(w http.ResponseWriter) bool {
+	if s.uploadSlots == nil {
+		return true
+	}
+	select {
+	case s.uploadSlots <- struct{}{}:
+		return true
+	default:
+		w.Header().Set("Retry-After", strconv.Itoa(uploadRetryAfter))
+		s.jsonError(w, http.StatusServiceUnavailable, "too_many_uploads", "Too many uploads", fmt.Sprintf("The server is writing %d uploads already, retry later", cap(s.uploadSlots)))
+		return false
+	}
 }
 
+// releaseUpload frees the slot taken by acquireUpload
+func (s *Server) releaseUpload() {
+	if s.uploadSlots != nil {
+		<-s.uploadSlots
+	}
+}
+
+// uploadTooLarge writes the 413 for bodies over --max-upload-bytes
+func (s *Server) uploadTooLarge(w http.ResponseWriter) {
+	s.jsonError(w, http.StatusRequestEntityTooLarge, "upload_too_large", "Upload too large", fmt.Sprintf("Uploads are limited to %d bytes", s.maxUpload))
+}
+
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// ListMonths handles GET /api/logs/{user}: the user's months, each with
+// whether it's live or archived (so slower to read), and its size
 func (s *Server) ListMonths(w http.ResponseWriter, r *http.Request) {
-	username, password, ok := r.BasicAuth()
-	if !ok || !s.auth.Verify(username, password) {
-		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
 		return
 	}
 
 	user := r.PathValue("user")
-	if username != user {
+	if !s.canRead(username, user) {
 		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files")
 		return
 	}
+	if !s.validUser(w, user) {
+		return
+	}
+
+	if r.URL.Query().Get("recursive") == "true" || r.URL.Query().Has("from") || r.URL.Query().Has("to") {
+		s.writeRecursiveList(w, r, user)
+		return
+	}
 
-	userDir := filepath.Join(s.storage, username)
-	monthEntries, err := os.ReadDir(userDir)
+	userMonths, err := s.store.Months(user)
 	if err != nil {
 		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
 		return
 	}
 
-	var months []string
-	for _, monthEntry := range monthEntries {
-		name := monthEntry.Name()
-		if !monthEntry.IsDir() {
-			// remove .tar.zstd
-			ext := filepath.Ext(name)
-			name = strings.TrimSuffix(name, ext)
-			ext = filepath.Ext(name)
-			name = strings.TrimSuffix(name, ext)
-		}
-
-		if _, err := time.Parse("2006-01", name); err != nil {
-			continue
-		}
-
-		months = append(months, name)
+	months, err := s.monthInfos(user, userMonths)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -189,47 +489,65 @@ func (s *Server) ListMonths(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ListFiles(w http.ResponseWriter, r *http.Request) {
-	username, password, ok := r.BasicAuth()
-	if !ok || !s.auth.Verify(username, password) {
-		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
 		return
 	}
 
 	user := r.PathValue("user")
-	if username != user {
-		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files")
+	date := r.PathValue("date")
+	var prefixes []string // what a grant allows; nil for the owner and admins
+	if !s.canRead(username, user) {
+		if prefixes = s.grantedPrefixes(username, user, date); prefixes == nil {
+			s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files, or those granted to you")
+			return
+		}
+	}
+	if !s.validPath(w, user, date, "") {
 		return
 	}
-	date := r.PathValue("date")
 
-	var filenames []string
-	dateDir := filepath.Join(s.storage, user, date)
-	entries, err := os.ReadDir(dateDir)
-	if err != nil {
-		s.tarFSLock.RLock()
-		tfs, ok := s.tarFS[date]
-		s.tarFSLock.RUnlock()
-		if !ok {
-			tarPath := filepath.Join(s.storage, user, date+".tar."+s.compress)
-			var err error
-			tfs, err = tarfs.NewTarFS(tarPath)
-			if err != nil {
-				s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", err.Error())
-				return
-			}
-			s.tarFSLock.Lock()
-			s.tarFS[date] = tfs
-			s.tarFSLock.Unlock()
+	if r.URL.Query().Has("q") {
+		if r.URL.Query().Get("q") == "" {
+			s.jsonError(w, http.StatusBadRequest, "invalid_query", "Invalid query", "q must not be empty")
+			return
 		}
-
-		paths := tfs.EntryPaths()
-		for _, path := range paths {
-			filenames = append(filenames, strings.TrimPrefix(path, date+"/"))
+		if prefixes != nil && !slices.Contains(prefixes, "") {
+			s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "Searching needs a grant of the whole month")
+			return
 		}
+		s.writeSearch(w, r, user, date)
+		return
+	}
+	s.writeFileList(w, user, date, "", prefixes)
+}
+
+// writeFileList writes the names of a month's (or day's) files in dir ("" or
+// "sub/"), live or archived, with subdirectories such as days listed as "DD/".
+// If prefixes isn't nil, only files whose names start with one are listed.
+func (s *Server) writeFileList(w http.ResponseWriter, user, date, dir string, prefixes []string) {
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+
+	month, prefix, ok := splitDate(date)
+	if !ok {
+		s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", fmt.Sprintf("%s is not a YYYY-MM or YYYY-MM-DD date", date))
+		return
 	}
-	for _, entry := range entries {
-		filenames = append(filenames, entry.Name())
+	files, err := s.store.List(user, month)
+	if err != nil {
+		s.fileNotFound(w, user, month, err)
+		return
 	}
+	if prefixes != nil {
+		files = slices.DeleteFunc(files, func(file FileInfo) bool {
+			return !granted(file.Name, prefixes)
+		})
+	}
+	filenames := dirEntries(files, prefix+dir)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -240,101 +558,157 @@ func (s *Server) ListFiles(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) GetFile(w http.ResponseWriter, r *http.Request) {
-	username, password, ok := r.BasicAuth()
-	if !ok || !s.auth.Verify(username, password) {
-		s.jsonError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", "Invalid credentials")
+	username, ok := s.authenticate(w, r)
+	if !ok {
 		return
 	}
-
-	user := r.PathValue("user")
-	if username != user {
-		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files")
+	if !s.authorize(w, username, RoleRead) {
 		return
 	}
+
+	user := r.PathValue("user")
 	date := r.PathValue("date")
 	name := r.PathValue("name")
-
-	// Validate date format
-	if _, err := time.Parse("2006-01", date); err != nil {
-		s.jsonError(w, http.StatusBadRequest, "invalid_date", "Invalid date format", "Date must be YYYY-MM")
+	var prefixes []string // what a grant allows; nil for the owner and admins
+	if !s.canRead(username, user) {
+		if prefixes = s.grantedPrefixes(username, user, date); prefixes == nil {
+			s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files, or those granted to you")
+			return
+		}
+	}
+	if !s.validPath(w, user, date, strings.TrimSuffix(name, "/")) {
 		return
 	}
 
-	// Check filesystem first
-	filePath := filepath.Join(s.storage, user, date, name)
-	if f, err := os.Open(filePath); err == nil {
-		_, _ = io.Copy(w, f)
+	// a trailing slash lists a subdirectory
+	if name == "" || strings.HasSuffix(name, "/") {
+		s.writeFileList(w, user, date, name, prefixes)
 		return
 	}
-
-	// Try streaming from tarball
-	s.tarFSLock.RLock()
-	tfs, ok := s.tarFS[date]
-	s.tarFSLock.RUnlock()
-	if !ok {
-		tarPath := filepath.Join(s.storage, user, date+".tar."+s.compress)
-		var err error
-		tfs, err = tarfs.NewTarFS(tarPath)
+	if _, day, _ := splitDate(date); prefixes != nil && !granted(day+name, prefixes) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "Your grant does not include that file")
+		return
+	}
+	if r.Method == http.MethodHead {
+		s.writeFileHead(w, r, user, date, name)
+		return
+	}
+	if q := r.URL.Query(); q.Get("versions") == "true" || q.Has("version") {
+		s.writeVersions(w, r, user, date, name)
+		return
+	}
+	if isFiltered(r.URL.Query()) {
+		filter, err := parseFilter(r.URL.Query())
 		if err != nil {
-			s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", err.Error())
+			s.jsonError(w, http.StatusBadRequest, "invalid_filter", "Invalid filter", err.Error())
 			return
 		}
-		s.tarFSLock.Lock()
-		s.tarFS[date] = tfs
-		s.tarFSLock.Unlock()
+		s.writeFiltered(w, user, date, name, filter)
+		return
+	}
+	s.writeFile(w, r, user, date, name)
+}
+
+// writeFile streams a single file from the live directory or the month's tarball
+func (s *Server) writeFile(w http.ResponseWriter, r *http.Request, user, date, name string) {
+	date, prefix, _ := splitDate(date)
+	name = prefix + name
+	info, err := s.store.Stat(user, date, name)
+	if err != nil {
+		s.fileNotFound(w, user, date, err)
+		return
+	}
+	if encoding := storedEncodings[strings.ToLower(path.Ext(name))]; encoding != "" && decompressRequested(r) {
+		s.writeDecompressed(w, r, user, date, name, info, encoding)
+		return
+	}
+	if info.Archived && s.writeStoredZstd(w, r, user, date, name, info) {
+		return
+	}
+	if notModified(w, r, fileETag(info.Size, info.ModTime)) {
+		return
 	}
 
-	f, err := tfs.Get(filepath.Join(date, name))
+	f, err := s.store.Open(user, date, name)
 	if err != nil {
-		s.jsonError(w, http.StatusNotFound, "file_not_found", "File not found", err.Error())
+		s.fileNotFound(w, user, date, err)
 		return
 	}
+	defer func() { _ = f.Close() }()
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
 	_, _ = io.Copy(w, f)
 }
 
-func (s *Server) CompressAll(now time.Time, stale time.Duration) ([]string, error) {
-	var tarballs []string
-
-	then := now.Add(-stale)
-	thenName := then.Format("2006-01")
+// writeFileHead answers HEAD with a file's size, modification time and
+// SHA-256, from the live directory or the month's tarball
+func (s *Server) writeFileHead(w http.ResponseWriter, r *http.Request, user, date, name string) {
+	date, prefix, _ := splitDate(date)
+	name = prefix + name
+	info, err := s.store.Stat(user, date, name)
+	if err != nil {
+		s.fileNotFound(w, user, date, err)
+		return
+	}
+	if notModified(w, r, fileETag(info.Size, info.ModTime)) {
+		return
+	}
 
-	userDirs, err := os.ReadDir(s.storage)
+	sum, err := s.fileSHA256(user, date, name)
 	if err != nil {
-		return nil, err
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
 	}
-	for _, userDir := range userDirs {
-		if !userDir.IsDir() {
-			continue
-		}
+	setFileHeaders(w, info.Size, info.ModTime, sum)
+	w.WriteHeader(http.StatusOK)
+}
 
-		userPath := filepath.Join(s.storage, userDir.Name())
-		dateDirs, err := os.ReadDir(userPath)
-		if err != nil {
-			continue
-		}
-		for _, dateDir := range dateDirs {
-			if !dateDir.IsDir() {
-				continue
-			}
+// fileSHA256 asks the storage for a file's digest, or reads the file
+func (s *Server) fileSHA256(user, date, name string) (string, error) {
+	if hasher, ok := s.store.(Hasher); ok {
+		return hasher.SHA256(user, date, name)
+	}
 
-			dateName := dateDir.Name()
-			if _, err := time.Parse("2006-01", dateName); err != nil {
-				continue
-			}
+	f, err := s.store.Open(user, date, name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-			if dateName >= thenName {
-				continue
-			}
+// fileETag derives a validator from size and modification time, which
+// change whenever a file is re-uploaded, without hashing its contents
+func fileETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`"%x-%x"`, size, modTime.UnixNano())
+}
 
-			// TODO Compress(root, dirs, format)
-			if err := tarfs.CompressAndRemove(userPath, dateName, s.compress); err != nil {
-				return nil, err
-			}
+// notModified sets the ETag and, if the request's If-None-Match matches it,
+// writes a 304 and returns true
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
 
-			tarball := filepath.Join(userPath, dateName+".tar."+s.compress)
-			tarballs = append(tarballs, tarball)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
 		}
 	}
+	return false
+}
 
-	return tarballs, nil
+func setFileHeaders(w http.ResponseWriter, size int64, modTime time.Time, sum string) {
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("X-Content-SHA256", sum)
 }
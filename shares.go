@@ -0,0 +1,235 @@
+package logapi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	sharesFile      = ".shares.json"
+	defaultShareTTL = 7 * 24 * time.Hour
+)
+
+// Share is a revocable read-only grant for a month, or a single file in it
+type Share struct {
+	Token   string    `json:"token"`
+	User    string    `json:"user"`
+	Date    string    `json:"date"`
+	Name    string    `json:"name,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// ShareRequest represents the POST /api/logs/{user}/{date}/shares JSON body
+type ShareRequest struct {
+	Name      string `json:"name"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// loadShares reads persisted share tokens, if any
+func loadShares(path string) (map[string]Share, error) {
+	shares := make(map[string]Share)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return shares, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Share
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid %q format: %w", path, err)
+	}
+	for _, share := range list {
+		shares[share.Token] = share
+	}
+	return shares, nil
+}
+
+// saveShares persists the unexpired share tokens; sharesLock must be held
+func (s *Server) saveShares() error {
+	now := time.Now()
+	list := []Share{}
+	for token, share := range s.shares {
+		if now.After(share.Expires) {
+			delete(s.shares, token)
+			continue
+		}
+		list = append(list, share)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.storage, sharesFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// lookupShare returns the share for a token if it exists and has not expired
+func (s *Server) lookupShare(token string) (Share, bool) {
+	s.sharesLock.RLock()
+	share, ok := s.shares[token]
+	s.sharesLock.RUnlock()
+	if !ok || time.Now().After(share.Expires) {
+		return Share{}, false
+	}
+	return share, true
+}
+
+func generateShareToken() string {
+	bytes := make([]byte, 24)
+	_, _ = rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func (s *Server) CreateShare(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if username != user {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only share your own files")
+		return
+	}
+	date := r.PathValue("date")
+
+	var req ShareRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, http.StatusBadRequest, "invalid_body", "Invalid request body", err.Error())
+			return
+		}
+	}
+	if !s.validPath(w, user, date, req.Name) {
+		return
+	}
+
+	ttl := defaultShareTTL
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			s.jsonError(w, http.StatusBadRequest, "invalid_expires_in", "Invalid expiration", "expires_in must be a positive duration such as 72h")
+			return
+		}
+	}
+
+	share := Share{
+		Token:   generateShareToken(),
+		User:    user,
+		Date:    date,
+		Name:    req.Name,
+		Expires: time.Now().UTC().Add(ttl).Truncate(time.Second),
+	}
+
+	s.sharesLock.Lock()
+	s.shares[share.Token] = share
+	err := s.saveShares()
+	s.sharesLock.Unlock()
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(share)
+}
+
+func (s *Server) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	token := r.PathValue("token")
+	s.sharesLock.Lock()
+	defer s.sharesLock.Unlock()
+	share, ok := s.shares[token]
+	if !ok || share.User != username {
+		s.jsonError(w, http.StatusNotFound, "share_not_found", "Share not found", "No such share token")
+		return
+	}
+
+	delete(s.shares, token)
+	if err := s.saveShares(); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"message": fmt.Sprintf("Share revoked: %s/%s", share.User, share.Date),
+	})
+}
+
+// ListSharedFiles lists the files a share token grants access to, without credentials
+func (s *Server) ListSharedFiles(w http.ResponseWriter, r *http.Request) {
+	share, ok := s.lookupShare(r.PathValue("token"))
+	if !ok {
+		s.jsonError(w, http.StatusNotFound, "share_not_found", "Share not found", "Share token is invalid, revoked or expired")
+		return
+	}
+
+	if share.Name != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		enc := json.NewEncoder(w)
+		_ = enc.Encode(map[string]any{
+			"results": []string{share.Name},
+		})
+		return
+	}
+
+	s.writeFileList(w, share.User, share.Date, "", nil)
+}
+
+// GetSharedFile downloads a file a share token grants access to, without credentials
+func (s *Server) GetSharedFile(w http.ResponseWriter, r *http.Request) {
+	share, ok := s.lookupShare(r.PathValue("token"))
+	if !ok {
+		s.jsonError(w, http.StatusNotFound, "share_not_found", "Share not found", "Share token is invalid, revoked or expired")
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := checkName(name); err != nil {
+		s.jsonError(w, http.StatusBadRequest, "invalid_name", "Invalid file name", err.Error())
+		return
+	}
+	if share.Name != "" && share.Name != name {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "This share does not include that file")
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		s.writeFileHead(w, r, share.User, share.Date, name)
+		return
+	}
+	s.writeFile(w, r, share.User, share.Date, name)
+}
@@ -0,0 +1,358 @@
+package logapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const stagingDir = ".staging"
+
+// stagedCreateDir holds a transaction's files that may only be created, as
+// {month}/{name} under it, where others are {month}/{name} under the
+// transaction's directory
+const stagedCreateDir = ".create"
+
+// stagedBackupDir holds copies of the files a commit is replacing, while it
+// runs, as {month}/{name} under the transaction's directory
+const stagedBackupDir = ".backup"
+
+// defaultUploadTTL is how long an upload transaction may go without files
+// before it expires
+const defaultUploadTTL = 24 * time.Hour
+
+// WithUploadTTL sets how long a staged upload transaction may go without a
+// file POSTed to it before it expires and its files are removed, 24 hours
+// by default. Zero keeps transactions until they're committed or aborted.
+func WithUploadTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.uploadTTL = ttl
+	}
+}
+
+// stagingPath returns the pending directory for a user's upload transaction,
+// or false if the id is malformed or the transaction does not exist or has
+// expired
+func (s *Server) stagingPath(user, id string) (string, bool) {
+	if len(id) != 32 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+
+	path := filepath.Join(s.userDir(user), stagingDir, id)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() || s.uploadExpired(info, time.Now()) {
+		return "", false
+	}
+	return path, true
+}
+
+// uploadExpired reports whether a transaction, by its directory, has gone
+// without files for longer than the upload TTL. Each file staged touches
+// the directory.
+func (s *Server) uploadExpired(info fs.FileInfo, now time.Time) bool {
+	return s.uploadTTL > 0 && now.Sub(info.ModTime()) > s.uploadTTL
+}
+
+// PruneUploads removes every expired upload transaction and its files,
+// returning "user/id" for each
+func (s *Server) PruneUploads(now time.Time) ([]string, error) {
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, user := range users {
+		ids, err := s.pruneUploads(user, now)
+		for _, id := range ids {
+			pruned = append(pruned, user+"/"+id)
+		}
+		if err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// pruneUploads removes a user's expired upload transactions, returning
+// their ids
+func (s *Server) pruneUploads(user string, now time.Time) ([]string, error) {
+	if s.uploadTTL <= 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(s.userDir(user), stagingDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// not in the middle of a commit
+	s.commitLock.Lock()
+	defer s.commitLock.Unlock()
+
+	var pruned []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || !s.uploadExpired(info, now) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, entry.Name())
+	}
+	return pruned, nil
+}
+
+// BeginUpload starts a staged upload transaction. Files POSTed to /api/logs
+// with a matching X-Upload-ID header stay hidden until the transaction is committed.
+func (s *Server) BeginUpload(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleUpload) {
+		return
+	}
+
+	// clean up after clients that never committed or aborted
+	if _, err := s.pruneUploads(username, time.Now()); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	_, _ = rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+
+	path := filepath.Join(s.userDir(username), stagingDir, id)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"id":      id,
+		"created": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// CommitUpload moves every staged file into place. Listings are blocked for
+// the duration, so readers see either none or all of the transaction's
+// files. Files that may not be replaced are checked before any is moved,
+// and if one still fails, those moved already are put back as they were
+// and the transaction is kept, to retry or abort.
+func (s *Server) CommitUpload(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleUpload) {
+		return
+	}
+	policy := s.OverwritePolicy(username)
+	w.Header().Set(overwriteHeader, policy)
+
+	id := r.PathValue("id")
+	stagePath, ok := s.stagingPath(username, id)
+	if !ok {
+		s.jsonError(w, http.StatusNotFound, "upload_not_found", "Upload not found", "No such upload transaction")
+		return
+	}
+
+	s.commitLock.Lock()
+	defer s.commitLock.Unlock()
+
+	files, err := stagedFiles(stagePath)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	for _, file := range files {
+		if !file.noOverwrite && policy != OverwriteDeny {
+			continue
+		}
+		if _, err := s.store.Stat(username, file.date, file.name); err == nil {
+			s.fileExists(w, existsError(file.date, file.name, policy))
+			return
+		}
+	}
+
+	backupDir := filepath.Join(stagePath, stagedBackupDir)
+	defer func() { _ = os.RemoveAll(backupDir) }()
+	var committed []string
+	var undo []func() error
+	for _, file := range files {
+		name, undoFile, err := s.commitStaged(username, file, backupDir)
+		if err != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				if undoErr := undo[i](); undoErr != nil {
+					err = fmt.Errorf("%w (and undoing the commit: %v)", err, undoErr)
+				}
+			}
+			if errors.Is(err, ErrExists) {
+				s.fileExists(w, err)
+				return
+			}
+			s.jsonError(w, http.StatusInternalServerError, "commit_failed", "Commit failed", err.Error())
+			return
+		}
+		committed = append(committed, file.date+"/"+name)
+		undo = append(undo, undoFile)
+	}
+
+	if err := os.RemoveAll(stagePath); err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"message": fmt.Sprintf("Upload committed: %s", id),
+		"results": committed,
+	})
+}
+
+// stagedFile is a file of an upload transaction
+type stagedFile struct {
+	date, name  string
+	path        string // in the staging directory
+	noOverwrite bool   // staged under stagedCreateDir
+}
+
+// stagedFiles returns the files of the transaction at stagePath
+func stagedFiles(stagePath string) ([]stagedFile, error) {
+	var files []stagedFile
+	for _, root := range []string{stagePath, filepath.Join(stagePath, stagedCreateDir)} {
+		dateDirs, err := os.ReadDir(root)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, dateDir := range dateDirs {
+			date := dateDir.Name()
+			if !dateDir.IsDir() || strings.HasPrefix(date, ".") {
+				continue
+			}
+
+			srcDir := filepath.Join(root, date)
+			err := filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() || filepath.Ext(path) == ".tmp" {
+					return err
+				}
+				rel, err := filepath.Rel(srcDir, path)
+				if err != nil {
+					return err
+				}
+				files = append(files, stagedFile{
+					date:        date,
+					name:        filepath.ToSlash(rel),
+					path:        path,
+					noOverwrite: root != stagePath,
+				})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// commitStaged copies one staged file into storage, returning the name it
+// was stored as and a func that undoes it: removing the file, or putting
+// back what it replaced, a copy of which is kept under backupDir
+func (s *Server) commitStaged(user string, file stagedFile, backupDir string) (string, func() error, error) {
+	policy := s.OverwritePolicy(user)
+	replaces := !file.noOverwrite && (policy == OverwriteAllow || policy == OverwriteVersion)
+	backup := ""
+	if info, err := s.store.Stat(user, file.date, file.name); err == nil && replaces && !info.Archived {
+		rc, err := s.store.Open(user, file.date, file.name)
+		if err != nil {
+			return "", nil, err
+		}
+		err = saveUpload(filepath.Join(backupDir, file.date), file.name, rc)
+		_ = rc.Close()
+		if err != nil {
+			return "", nil, err
+		}
+		backup = filepath.Join(backupDir, file.date, filepath.FromSlash(file.name))
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = f.Close() }()
+	stored, err := s.putFile(user, file.date, file.name, f, file.noOverwrite)
+	if err != nil {
+		return "", nil, err
+	}
+
+	undo := func() error {
+		switch {
+		case !stored.changed:
+			return nil
+		case backup != "" && stored.name == file.name:
+			f, err := os.Open(backup)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			return s.store.Put(user, file.date, file.name, f)
+		default:
+			return s.store.Remove(user, file.date, stored.name)
+		}
+	}
+	return stored.name, undo, nil
+}
+
+// AbortUpload discards a staged upload transaction and its files
+func (s *Server) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleUpload) {
+		return
+	}
+
+	id := r.PathValue("id")
+	stagePath, ok := s.stagingPath(username, id)
+	if !ok {
+		s.jsonError(w, http.StatusNotFound, "upload_not_found", "Upload not found", "No such upload transaction")
+		return
+	}
+
+	if err := os.RemoveAll(stagePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]string{
+		"message": fmt.Sprintf("Upload aborted: %s", id),
+	})
+}
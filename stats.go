@@ -0,0 +1,197 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// Stats sums up what a user (or, for the admin-wide total, everyone) has
+// stored
+type Stats struct {
+	User          string `json:"user,omitempty"`
+	Months        int    `json:"months"`
+	OldestMonth   string `json:"oldest_month,omitempty"`
+	NewestMonth   string `json:"newest_month,omitempty"`
+	LiveFiles     int    `json:"live_files"`
+	LiveBytes     int64  `json:"live_bytes"`
+	ArchivedFiles int    `json:"archived_files"`
+	// ArchivedBytes is the archived files' size uncompressed, and
+	// ArchiveBytes what their tarballs take up on disk
+	ArchivedBytes int64 `json:"archived_bytes"`
+	ArchiveBytes  int64 `json:"archive_bytes"`
+	// CompressionRatio is ArchivedBytes / ArchiveBytes, or 0 when the
+	// storage can't tell how large its archives are
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+var _ ArchiveSizer = (*FSStorage)(nil)
+
+// add counts other's files into st, widening its month range
+func (st *Stats) add(other Stats) {
+	st.Months += other.Months
+	if other.OldestMonth != "" && (st.OldestMonth == "" || other.OldestMonth < st.OldestMonth) {
+		st.OldestMonth = other.OldestMonth
+	}
+	if other.NewestMonth > st.NewestMonth {
+		st.NewestMonth = other.NewestMonth
+	}
+	st.LiveFiles += other.LiveFiles
+	st.LiveBytes += other.LiveBytes
+	st.ArchivedFiles += other.ArchivedFiles
+	st.ArchivedBytes += other.ArchivedBytes
+	st.ArchiveBytes += other.ArchiveBytes
+	st.setRatio()
+}
+
+func (st *Stats) setRatio() {
+	st.CompressionRatio = 0
+	if st.ArchiveBytes > 0 {
+		st.CompressionRatio = float64(st.ArchivedBytes) / float64(st.ArchiveBytes)
+	}
+}
+
+// UserStats counts a user's files and bytes, month by month
+func (s *Server) UserStats(user string) (Stats, error) {
+	st := Stats{User: user}
+	months, err := s.store.Months(user)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return st, nil
+		}
+		return st, err
+	}
+	sizer, _ := s.store.(ArchiveSizer)
+
+	for _, month := range months {
+		s.commitLock.RLock()
+		files, err := s.store.List(user, month.Name)
+		if errors.Is(err, ErrRestoreRequired) {
+			files, err = nil, nil // only its tarball's size counts until it's restored
+		}
+		var archiveSize int64
+		if err == nil && month.Archived && sizer != nil {
+			archiveSize, err = sizer.ArchiveSize(user, month.Name)
+		}
+		s.commitLock.RUnlock()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed since Months
+		}
+		if err != nil {
+			return st, err
+		}
+
+		st.Months++
+		if st.OldestMonth == "" {
+			st.OldestMonth = month.Name
+		}
+		st.NewestMonth = month.Name
+		for _, file := range files {
+			if file.Archived {
+				st.ArchivedFiles++
+				st.ArchivedBytes += file.Size
+			} else {
+				st.LiveFiles++
+				st.LiveBytes += file.Size
+			}
+		}
+		st.ArchiveBytes += archiveSize
+	}
+	st.setRatio()
+	return st, nil
+}
+
+// AllStats counts every user's files, returning each user's and the total
+func (s *Server) AllStats() ([]Stats, Stats, error) {
+	var total Stats
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, total, err
+	}
+	results := []Stats{}
+	for _, user := range users {
+		st, err := s.UserStats(user)
+		if err != nil {
+			return nil, total, err
+		}
+		results = append(results, st)
+		total.add(st)
+	}
+	return results, total, nil
+}
+
+// GetUserStats handles GET /api/logs/{user}/stats
+func (s *Server) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleRead) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !s.canRead(username, user) {
+		s.jsonError(w, http.StatusForbidden, "forbidden", "Forbidden", "You can only access your own files")
+		return
+	}
+	if !s.validUser(w, user) {
+		return
+	}
+
+	st, err := s.UserStats(user)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(st)
+}
+
+// GetStats handles GET /api/stats, every user's stats and the total
+// (admin only)
+func (s *Server) GetStats(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, username, RoleAdmin) {
+		return
+	}
+
+	results, total, err := s.AllStats()
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"total":   total,
+		"results": results,
+	})
+}
+
+// ArchiveSize returns the size of a month's tarball, local or cold
+func (fsys *FSStorage) ArchiveSize(user, date string) (int64, error) {
+	tarPath, ok := fsys.tarPath(user, date)
+	if !ok {
+		if stubPath, cold := fsys.coldStubPath(user, date); cold {
+			stub, err := readColdStub(stubPath)
+			return stub.Size, err
+		}
+		return 0, fs.ErrNotExist
+	}
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
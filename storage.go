@@ -0,0 +1,180 @@
+package logapi
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrArchived is returned by Storage.Remove for a file that only exists
+// in an archived (read-only) month
+var ErrArchived = errors.New("file is archived")
+
+// ErrRestoreRequired is returned for reads of a month whose tarball is in
+// an archive tier of cold storage, until a Restorer has restored it
+var ErrRestoreRequired = errors.New("month must be restored from cold storage")
+
+// Storage holds each user's months ("YYYY-MM") of log files. A month is
+// live, and accepts new files, until Archive compresses it. Missing users,
+// months and files are reported with fs.ErrNotExist.
+type Storage interface {
+	// Put stores a file in a live month, replacing any file of the same
+	// name. Readers never see a partial file.
+	Put(user, date, name string, body io.Reader) error
+	// Open reads a file from a live month, or else from its archive
+	Open(user, date, name string) (io.ReadCloser, error)
+	// Stat describes a file the way Open would find it
+	Stat(user, date, name string) (FileInfo, error)
+	// List returns a month's files, sorted by name, with live files
+	// taking precedence over archived ones of the same name
+	List(user, date string) ([]FileInfo, error)
+	// Months returns a user's months, sorted
+	Months(user string) ([]Month, error)
+	// Users returns every user with stored files
+	Users() ([]string, error)
+	// Remove deletes a file from a live month, or returns ErrArchived
+	Remove(user, date, name string) error
+	// RemoveMonth deletes a month, live and archived, and returns what
+	// was removed
+	RemoveMonth(user, date string) ([]string, error)
+	// Archive compresses a live month and returns the archive's location
+	Archive(user, date string) (string, error)
+}
+
+// Hasher is implemented by storage that can return a file's hex SHA-256
+// without the caller reading it (e.g. from a cache or object metadata)
+type Hasher interface {
+	SHA256(user, date, name string) (string, error)
+}
+
+// Deduper is implemented by storage that can leave a file alone when a Put
+// has the content it already has, reporting whether it changed
+type Deduper interface {
+	PutIfChanged(user, date, name string, body io.Reader) (bool, error)
+}
+
+// Creator is implemented by storage that can store a file only if there's
+// none of that name, checking and storing in one step
+type Creator interface {
+	// Create is Put, failing with fs.ErrExist (before reading body) if the
+	// file exists, live or archived
+	Create(user, date, name string, body io.Reader) error
+}
+
+// FirstCreator is implemented by storage that can store a file under the
+// first of several names that's free, reading the body once
+type FirstCreator interface {
+	// CreateFirst is Create under names(0), names(1) and so on until one
+	// is free, returning it
+	CreateFirst(user, date string, names func(n int) string, body io.Reader) (string, error)
+}
+
+// ZstdOpener is implemented by storage that can read an archived file as
+// it's stored, zstd-compressed, for clients that accept zstd
+type ZstdOpener interface {
+	// OpenZstd returns the file's zstd frames and their size, failing
+	// with errors.ErrUnsupported if it isn't stored that way
+	OpenZstd(user, date, name string) (io.ReadCloser, int64, error)
+}
+
+// Versioner is implemented by storage that keeps what files had before
+// they were replaced
+type Versioner interface {
+	// Versions returns a file's kept versions, oldest first
+	Versions(user, date, name string) ([]FileVersion, error)
+	OpenVersion(user, date, name string, version int) (io.ReadCloser, error)
+}
+
+// BlobPruner is implemented by storage that shares content between files
+// and must now and then drop content no file uses
+type BlobPruner interface {
+	PruneBlobs() (int, error)
+}
+
+// Warmer is implemented by storage that can index archived months ahead of
+// the first request for them. progress, if not nil, is called (possibly
+// concurrently) as each month is done.
+type Warmer interface {
+	Warmup(workers int, progress func(done, total int)) error
+}
+
+// ArchiveVerifier is implemented by storage that can check an archived
+// month for corruption by reading all of it
+type ArchiveVerifier interface {
+	VerifyArchive(user, date string) error
+}
+
+// Repacker is implemented by storage that can rewrite an archived month
+// compactly, or split it into volumes of about maxVolume bytes
+type Repacker interface {
+	Repack(user, date string, maxVolume int64) ([]string, error)
+}
+
+// Converter is implemented by storage that can rewrite a user's archives
+// written in another compression format into its own
+type Converter interface {
+	ConvertArchives(user string) ([]string, error)
+}
+
+// Searcher is implemented by storage that can find the lines of a month's
+// files (those whose names start with prefix, such as a day's "15/")
+// containing query. It returns up to limit matches, in name and offset
+// order, and whether there were more.
+type Searcher interface {
+	Search(user, date, prefix, query string, limit int) ([]SearchMatch, bool, error)
+}
+
+// Indexer is implemented by storage that can build a month's search index
+// ahead of the first search, as it does when archiving with an index
+type Indexer interface {
+	IndexArchive(user, date string) error
+}
+
+// ArchiveSizer is implemented by storage that can tell how much space an
+// archived month takes up, for compression ratios in stats
+type ArchiveSizer interface {
+	ArchiveSize(user, date string) (int64, error)
+}
+
+// Tierer is implemented by storage that can move an archived month's
+// tarball to cold storage, fetching it back when it's read. Tier reports
+// whether it uploaded the tarball, rather than dropping a fetched copy.
+type Tierer interface {
+	Tier(user, date string) (bool, error)
+}
+
+// Restorer is implemented by storage whose cold months may be in an
+// archive tier, such as S3 Glacier, and need restoring before they're
+// read. Both return objstore.Available, objstore.Archived or
+// objstore.Restoring.
+type Restorer interface {
+	RestoreStatus(user, date string) (string, error)
+	// Restore starts restoring an Archived month
+	Restore(user, date string) (string, error)
+}
+
+// Month is a month of a user's files, which may be live, archived, or
+// (briefly, while being archived) both. A cold month is archived with its
+// tarball only in cold storage.
+type Month struct {
+	Name     string
+	Live     bool
+	Archived bool
+	Cold     bool
+}
+
+// Warmup indexes archived months with up to workers at a time, if the
+// storage supports it, so first reads don't pay the indexing cost
+func (s *Server) Warmup(workers int, progress func(done, total int)) error {
+	warmer, ok := s.store.(Warmer)
+	if !ok {
+		return nil
+	}
+	return warmer.Warmup(workers, progress)
+}
+
+// WithStorage replaces the default filesystem storage
+func WithStorage(storage Storage) Option {
+	return func(s *Server) {
+		s.store = storage
+	}
+}
@@ -0,0 +1,118 @@
+package logapi
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/paperos-labs/logapi/tarfs"
+)
+
+const (
+	defaultTarCacheEntries = 64
+	defaultTarCacheTTL     = 10 * time.Minute
+	defaultEntryCacheBytes = 32 << 20
+	defaultEntryCacheMax   = 1 << 20 // largest file kept in the entry cache
+)
+
+// tarCacheKey identifies a month's tarball; months are only unique per user
+type tarCacheKey struct {
+	user, date string
+}
+
+type tarCacheEntry struct {
+	key     tarCacheKey
+	tfs     *tarfs.TarFS
+	modTime time.Time // of the tarball when indexed
+	size    int64
+	loaded  time.Time
+}
+
+// tarCache keeps the indexes of recently read tarballs. It drops the least
+// recently used past maxEntries, entries older than ttl, and entries whose
+// tarball has changed on disk since it was indexed.
+type tarCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[tarCacheKey]*list.Element
+	order      *list.List // most recently used first
+	// extracted holds small files read from the cached tarballs
+	extracted *tarfs.EntryCache
+}
+
+func newTarCache(maxEntries int, ttl time.Duration, extracted *tarfs.EntryCache) *tarCache {
+	return &tarCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		extracted:  extracted,
+		entries:    make(map[tarCacheKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the index of the tarball at tarPath, indexing it with opts if
+// it isn't cached or is stale
+func (c *tarCache) get(key tarCacheKey, tarPath string, opts ...tarfs.Option) (*tarfs.TarFS, error) {
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		c.remove(key)
+		return nil, err
+	}
+
+	now := time.Now()
+	c.lock.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*tarCacheEntry)
+		fresh := c.ttl <= 0 || now.Sub(entry.loaded) < c.ttl
+		if fresh && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			c.order.MoveToFront(elem)
+			c.lock.Unlock()
+			return entry.tfs, nil
+		}
+		c.removeElement(elem)
+	}
+	c.lock.Unlock()
+
+	// index without holding the lock; a concurrent miss on the same month
+	// indexes it twice, and the last one wins
+	tfs, err := tarfs.NewTarFS(tarPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tfs.SetEntryCache(c.extracted)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.order.PushFront(&tarCacheEntry{
+		key:     key,
+		tfs:     tfs,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		loaded:  now,
+	})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+	return tfs, nil
+}
+
+// remove drops a month's index, e.g. when its tarball is deleted
+func (c *tarCache) remove(key tarCacheKey) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *tarCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*tarCacheEntry)
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.extracted.Drop(entry.tfs)
+}
@@ -3,93 +3,349 @@ package tarfs
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/ulikunitz/xz"
 )
 
-func CompressAndRemove(dataDir, date, format string) error {
-	if err := CompressDir(dataDir, date, format); err != nil {
+// CompressAndRemove archives dataDir/date and removes the directory. If the
+// month was already archived, its files are appended to the tarball instead.
+// The directory is only removed once the tarball has been read back and
+// matches it (see CompressDir).
+func CompressAndRemove(dataDir, date, format string, opts ...Option) error {
+	tarPath := filepath.Join(dataDir, date+".tar."+format)
+	if _, err := os.Stat(tarPath); err == nil {
+		files, err := dirFiles(filepath.Join(dataDir, date))
+		if err != nil {
+			return err
+		}
+		if err := Append(dataDir, date, files, format, opts...); err != nil {
+			return err
+		}
+	} else if err := CompressDir(dataDir, date, format, opts...); err != nil {
 		return err
 	}
 	return os.RemoveAll(filepath.Join(dataDir, date))
 }
 
-func CompressDir(dataDir, date, format string) error {
+// CompressDir archives dataDir/date as dataDir/date.tar.{format}, unless
+// that exists already. The tarball is written to a temp file and, unless
+// SkipVerify is given, read back in full and compared with the directory
+// before it's renamed into place; on a mismatch it's discarded.
+func CompressDir(dataDir, date, format string, opts ...Option) error {
 	tarPath := filepath.Join(dataDir, date+".tar."+format)
 	if _, err := os.Stat(tarPath); !os.IsNotExist(err) {
 		return nil // Skip if tarball already exists
 	}
-
-	f, err := os.Create(tarPath)
+	o, err := newOptions(opts)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
 
-	var tw *tar.Writer
-	switch format {
-	case "gz":
-		gw, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+	files, err := dirFiles(filepath.Join(dataDir, date))
+	if err != nil {
+		return err
+	}
+	return writeArchive(tarPath, format, o, dataDir, date, files, func(dst *os.File) error {
+		tw, closeWriter, err := newTarWriter(dst, format, o)
 		if err != nil {
 			return err
 		}
-		defer func() { _ = gw.Close() }()
-		tw = tar.NewWriter(gw)
-	case "bz2":
-		panic(fmt.Errorf("bzip2 has no writer"))
-	case "zst":
-		zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		defer func() { _ = closeWriter() }()
+		for _, name := range files {
+			if err := addFile(tw, dataDir, filepath.Join(date, name)); err != nil {
+				return err
+			}
+		}
+		return closeWriter()
+	})
+}
+
+// Append adds late-arriving files, named relative to dataDir/date, to an
+// already-compressed month. The tarball is rewritten through a temp file,
+// replacing entries of the same name, checked like CompressDir's, and
+// renamed into place; cached indexes see the new modification time and
+// re-index.
+func Append(dataDir, date string, files []string, format string, opts ...Option) error {
+	o, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+	tarPath := filepath.Join(dataDir, date+".tar."+format)
+	src, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	return writeArchive(tarPath, format, o, dataDir, date, files, func(dst *os.File) error {
+		return appendTo(dst, src, dataDir, date, files, format, o)
+	})
+}
+
+// writeArchive writes tarPath through a temp file with write, checks it
+// against files with verifySource unless verification is off, and renames
+// it into place. The temp file is removed if anything fails.
+func writeArchive(tarPath, format string, o options, dataDir, date string, files []string, write func(dst *os.File) error) error {
+	tmpPath := tarPath + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := write(dst); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := syncFile(dst, o); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if !o.skipVerify {
+		if err := verifySource(tmpPath, format, o, dataDir, date, files); err != nil {
+			_ = os.Remove(tmpPath)
+			return fmt.Errorf("verify %s: %w", tarPath, err)
+		}
+	}
+	if err := os.Rename(tmpPath, tarPath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(tarPath), o)
+}
+
+// appendTo copies the archive in src to dst, minus entries being replaced,
+// then adds the new files
+func appendTo(dst, src *os.File, dataDir, date string, files []string, format string, o options) error {
+	replaced := make(map[string]bool)
+	for _, name := range files {
+		replaced[filepath.Join(date, name)] = true
+	}
+
+	tw, closeWriter, err := newTarWriter(dst, format, o)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closeWriter() }()
+	tr, err := newTarReader(src, format, o)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tr.Close() }()
+
+	tarReader := tar.NewReader(tr)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return err
 		}
-		defer func() { _ = zw.Close() }()
-		tw = tar.NewWriter(zw)
-	case "xz":
-		xw, err := xz.NewWriter(f)
-		if err != nil {
+		if replaced[hdr.Name] {
+			continue
+		}
+		if err := tw.writeEntry(hdr, tarReader); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range files {
+		if err := addFile(tw, dataDir, filepath.Join(date, name)); err != nil {
 			return err
 		}
-		defer func() { _ = xw.Close() }()
-		tw = tar.NewWriter(xw)
 	}
-	defer func() { _ = tw.Close() }()
+	return closeWriter()
+}
 
-	root := filepath.Join(dataDir, date)
-	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// dirFiles lists the regular files under root, relative to it
+func dirFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
 			return nil
 		}
-		relPath, err := filepath.Rel(dataDir, path)
+		relPath, err := filepath.Rel(root, path)
 		if err != nil {
 			return err
 		}
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+		files = append(files, relPath)
+		return nil
+	})
+	return files, err
+}
+
+// addFile writes dataDir/relPath to the archive as relPath, with its
+// SHA-256 in a PAX record for Verify
+func addFile(tw *tarWriter, dataDir, relPath string) error {
+	file, err := os.Open(filepath.Join(dataDir, relPath))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = relPath
+	hdr.PAXRecords = map[string]string{SHA256Record: hex.EncodeToString(h.Sum(nil))}
+	return tw.writeEntry(hdr, io.LimitReader(file, hdr.Size))
+}
+
+// newTarWriter creates a tar writer for the specified compression format,
+// encrypting if a key is set. closeWriter flushes the tar, compression and
+// encryption streams, in that order; calling it again does nothing.
+func newTarWriter(w io.Writer, format string, o options) (tw *tarWriter, closeWriter func() error, err error) {
+	sealed, closeSeal, err := o.sealWriter(w)
+	if err != nil {
+		return nil, nil, err
+	}
+	cw, err := newCompressor(sealed, format, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tw = newFramedTarWriter(cw)
+	closed := false
+	closeWriter = func() error {
+		if closed {
+			return nil
 		}
-		hdr.Name = relPath
-		if err := tw.WriteHeader(hdr); err != nil {
+		closed = true
+		if err := tw.Close(); err != nil {
+			_ = cw.Close()
 			return err
 		}
-		file, err := os.Open(path)
-		if err != nil {
+		if err := cw.Close(); err != nil {
 			return err
 		}
-		defer func() { _ = file.Close() }()
-		_, err = io.Copy(tw, file)
+		return closeSeal()
+	}
+	return tw, closeWriter, nil
+}
+
+// WithLevel sets the compression level for the archives written, on the
+// format's own scale: 1-9 for gz and lz4, 1-11 for br, and zstd's 1-22
+// for zst (grouped into the encoder's four speeds). xz has no levels. Zero
+// keeps the default: the best gzip compression, zstd's better-compression
+// speed, brotli 9 and lz4's fast mode.
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithZstdConcurrency sets how many goroutines a zst archive is compressed
+// with (the default is GOMAXPROCS)
+func WithZstdConcurrency(n int) Option {
+	return func(o *options) {
+		o.zstdConcurrency = n
+	}
+}
+
+// WithZstdWindow sets zstd's window, a power of two from 1 KiB to 512 MiB;
+// bigger finds more repetition in large months at the cost of memory
+func WithZstdWindow(size int) Option {
+	return func(o *options) {
+		o.zstdWindow = size
+	}
+}
+
+// CheckOptions reports whether opts can be used to write format archives,
+// so bad settings fail at startup instead of at the first compression run
+func CheckOptions(format string, opts ...Option) error {
+	o, err := newOptions(opts)
+	if err != nil {
 		return err
-	})
+	}
+	cw, err := newCompressor(io.Discard, format, o)
 	if err != nil {
 		return err
 	}
+	return cw.Close()
+}
 
-	return nil
+// newCompressor creates a compressing writer for the specified format
+func newCompressor(w io.Writer, format string, o options) (io.WriteCloser, error) {
+	if o.level < 0 {
+		return nil, fmt.Errorf("invalid compression level %d", o.level)
+	}
+	switch format {
+	case "gz":
+		level := gzip.BestCompression
+		if o.level > 0 {
+			level = o.level
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "bz2":
+		panic(fmt.Errorf("bzip2 has no writer"))
+	case "zst":
+		zopts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBetterCompression)}
+		if o.level > 0 {
+			if o.level > 22 {
+				return nil, fmt.Errorf("invalid zst compression level %d", o.level)
+			}
+			zopts[0] = zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.level))
+		}
+		if o.zstdConcurrency > 0 {
+			zopts = append(zopts, zstd.WithEncoderConcurrency(o.zstdConcurrency))
+		}
+		if o.zstdWindow > 0 {
+			zopts = append(zopts, zstd.WithWindowSize(o.zstdWindow))
+		}
+		return newFrameWriter(w, zopts...)
+	case "xz":
+		return xz.NewWriter(w)
+	case "br":
+		// 11 is too slow for months of logs
+		level := 9
+		if o.level > 0 {
+			if o.level > brotli.BestCompression {
+				return nil, fmt.Errorf("invalid br compression level %d", o.level)
+			}
+			level = o.level
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	case "lz4":
+		zw := lz4.NewWriter(w)
+		if o.level > 0 {
+			if o.level > 9 {
+				return nil, fmt.Errorf("invalid lz4 compression level %d", o.level)
+			}
+			if err := zw.Apply(lz4.CompressionLevelOption(lz4.Level1 << (o.level - 1))); err != nil {
+				return nil, err
+			}
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
 }
@@ -0,0 +1,129 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Convert rewrites the archive at srcPath in dstPath's format (from its
+// extension, like 2025-07.tar.zst) and removes srcPath. If dstPath exists,
+// its entries are kept after srcPath's, so they still win. The result is
+// written through a temp file and read back in full, and must hold the same
+// files with the same contents, before it's renamed into place.
+func Convert(srcPath, dstPath string, opts ...Option) error {
+	srcFormat, dstFormat := detectFormat(srcPath), detectFormat(dstPath)
+	if srcFormat == "" {
+		return fmt.Errorf("unsupported file format: %s", srcPath)
+	}
+	if dstFormat == "" {
+		return fmt.Errorf("unsupported file format: %s", dstPath)
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	sources := []string{srcPath}
+	if _, err := os.Stat(dstPath); err == nil {
+		sources = append(sources, dstPath)
+	}
+
+	tmpPath := dstPath + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	want := make(map[string]string) // name -> hex SHA-256, last wins
+	if err := convertTo(dst, sources, dstFormat, o, want); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := syncFile(dst, o); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	got := make(map[string]string)
+	_, err = verifyArchive(tmpPath, dstFormat, o, func(hdr *tar.Header, sum string) {
+		if hdr.Typeflag == tar.TypeReg {
+			got[hdr.Name] = sum
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", dstPath, err)
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("verify %s: %d files, expected %d", dstPath, len(got), len(want))
+	}
+	for name, sum := range want {
+		if got[name] != sum {
+			return fmt.Errorf("verify %s: %s doesn't match the original", dstPath, name)
+		}
+	}
+
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dstPath), o); err != nil {
+		return err
+	}
+	return os.Remove(srcPath)
+}
+
+// convertTo copies every entry of the sources, in order, to dst, recording
+// the SHA-256 of each regular file in want
+func convertTo(dst io.Writer, sources []string, format string, o options, want map[string]string) error {
+	tw, closeWriter, err := newTarWriter(dst, format, o)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closeWriter() }()
+
+	for _, path := range sources {
+		if err := copyEntries(tw, path, o, want); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return closeWriter()
+}
+
+func copyEntries(tw *tarWriter, path string, o options, want map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	tr, err := newTarReader(f, detectFormat(path), o)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tr.Close() }()
+
+	tarReader := tar.NewReader(tr)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		if err := tw.writeEntry(hdr, io.TeeReader(tarReader, h)); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			want[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tarfs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// EntryCache keeps the contents of recently read small entries in memory, so
+// repeated reads of the same archived file don't decompress the archive
+// again. It holds up to maxBytes in total, evicting the least recently used.
+// One cache can be shared by many TarFS.
+type EntryCache struct {
+	lock          sync.Mutex
+	maxBytes      int64
+	maxEntryBytes int64
+	used          int64
+	entries       map[entryKey]*list.Element
+	order         *list.List // most recently used first
+}
+
+type entryKey struct {
+	tfs  *TarFS
+	name string
+}
+
+type cachedEntry struct {
+	key  entryKey
+	data []byte
+}
+
+// NewEntryCache caches entries of up to maxEntryBytes each, maxBytes in total
+func NewEntryCache(maxBytes, maxEntryBytes int64) *EntryCache {
+	return &EntryCache{
+		maxBytes:      maxBytes,
+		maxEntryBytes: min(maxEntryBytes, maxBytes),
+		entries:       make(map[entryKey]*list.Element),
+		order:         list.New(),
+	}
+}
+
+// SetEntryCache makes Get, Open and EntrySHA256 keep small entries in cache
+// (nil to disable)
+func (fs *TarFS) SetEntryCache(cache *EntryCache) {
+	fs.cache = cache
+}
+
+// fits reports whether an entry of size bytes would be cached
+func (c *EntryCache) fits(size int64) bool {
+	return c != nil && size <= c.maxEntryBytes
+}
+
+func (c *EntryCache) get(tfs *TarFS, name string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[entryKey{tfs, name}]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedEntry).data, true
+}
+
+func (c *EntryCache) put(tfs *TarFS, name string, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := entryKey{tfs, name}
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.order.PushFront(&cachedEntry{key: key, data: data})
+	c.used += int64(len(data))
+	for c.used > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Drop forgets every entry of an archive, e.g. once its index is discarded
+func (c *EntryCache) Drop(tfs *TarFS) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, elem := range c.entries {
+		if key.tfs == tfs {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *EntryCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cachedEntry)
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.used -= int64(len(entry.data))
+}
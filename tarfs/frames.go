@@ -0,0 +1,284 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zst archives are written in zstd's seekable format: each file's contents
+// get zstd frames of their own, and a skippable frame at the end holds a
+// seek table with every frame's compressed and decompressed size. zstd
+// decoders skip the table and read the archive as one stream as before,
+// while a TarFS can find a file's frames and send them as they are.
+const (
+	seekTableMagic  = 0x184D2A5E // skippable frame holding the seek table
+	seekFooterMagic = 0x8F92EAB1
+	seekFooterSize  = 9
+	// maxFrameSize splits bigger files, as the seek table sizes are 32 bits
+	maxFrameSize = 1 << 30
+)
+
+var zstdFrameMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// seekFrame is where a frame of a seekable archive is, compressed in the
+// file and decompressed in the tar stream
+type seekFrame struct {
+	offset, size           int64
+	plainOffset, plainSize int64
+}
+
+// frameWriter is a zstd writer that ends a frame at each endFrame, and
+// writes the seek table when closed
+type frameWriter struct {
+	enc     *zstd.Encoder
+	counter *countingWriter
+	start   int64 // compressed offset of the current frame
+	plain   int64 // bytes written to the current frame
+	sizes   [][2]uint32
+}
+
+func newFrameWriter(w io.Writer, opts ...zstd.EOption) (*frameWriter, error) {
+	counter := &countingWriter{w: w}
+	enc, err := zstd.NewWriter(counter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &frameWriter{enc: enc, counter: counter}, nil
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if fw.plain >= maxFrameSize {
+			if err := fw.endFrame(); err != nil {
+				return written, err
+			}
+		}
+		n, err := fw.enc.Write(p[:min(int64(len(p)), maxFrameSize-fw.plain)])
+		fw.plain += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// endFrame finishes the current frame, if anything has been written to it
+func (fw *frameWriter) endFrame() error {
+	if fw.plain == 0 {
+		return nil
+	}
+	if err := fw.enc.Close(); err != nil {
+		return err
+	}
+	fw.sizes = append(fw.sizes, [2]uint32{uint32(fw.counter.n - fw.start), uint32(fw.plain)})
+	fw.start, fw.plain = fw.counter.n, 0
+	fw.enc.Reset(fw.counter)
+	return nil
+}
+
+// Flush ends the current frame, pushing out everything written so far
+func (fw *frameWriter) Flush() error {
+	return fw.endFrame()
+}
+
+// Close ends the last frame and writes the seek table. It doesn't close the
+// underlying writer.
+func (fw *frameWriter) Close() error {
+	if err := fw.endFrame(); err != nil {
+		return err
+	}
+	tableSize := 8*len(fw.sizes) + seekFooterSize
+	table := make([]byte, 0, 8+tableSize)
+	table = binary.LittleEndian.AppendUint32(table, seekTableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(tableSize))
+	for _, size := range fw.sizes {
+		table = binary.LittleEndian.AppendUint32(table, size[0])
+		table = binary.LittleEndian.AppendUint32(table, size[1])
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(fw.sizes)))
+	table = append(table, 0) // no checksums
+	table = binary.LittleEndian.AppendUint32(table, seekFooterMagic)
+	_, err := fw.counter.Write(table)
+	return err
+}
+
+// tarWriter is a tar writer that, in zst archives, gives each file's
+// contents frames of their own
+type tarWriter struct {
+	*tar.Writer
+	frames *frameWriter // nil for other formats
+}
+
+func newFramedTarWriter(cw io.Writer) *tarWriter {
+	frames, _ := cw.(*frameWriter)
+	return &tarWriter{Writer: tar.NewWriter(cw), frames: frames}
+}
+
+// writeEntry writes hdr with body as its contents
+func (tw *tarWriter) writeEntry(hdr *tar.Header, body io.Reader) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if err := tw.endFrame(); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw.Writer, body); err != nil {
+		return err
+	}
+	return tw.endFrame()
+}
+
+func (tw *tarWriter) endFrame() error {
+	if tw.frames == nil {
+		return nil
+	}
+	return tw.frames.endFrame()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readSeekTable returns the frames of a seekable archive of the given size,
+// or nil if it has no seek table, as archives written before it don't
+func readSeekTable(f io.ReaderAt, size int64) []seekFrame {
+	footer := make([]byte, seekFooterSize)
+	if size < 8+seekFooterSize {
+		return nil
+	}
+	if _, err := f.ReadAt(footer, size-seekFooterSize); err != nil {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekFooterMagic || footer[4]&0x7c != 0 {
+		return nil
+	}
+	entrySize := int64(8)
+	if footer[4]&0x80 != 0 {
+		entrySize = 12 // with checksums, which aren't needed here
+	}
+	count := int64(binary.LittleEndian.Uint32(footer))
+	tableSize := count*entrySize + seekFooterSize
+	start := size - 8 - tableSize
+	if start < 0 {
+		return nil
+	}
+	table := make([]byte, 8+tableSize)
+	if _, err := f.ReadAt(table, start); err != nil {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(table) != seekTableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil
+	}
+
+	frames := make([]seekFrame, 0, count)
+	var offset, plainOffset int64
+	for entry := table[8 : 8+count*entrySize]; len(entry) > 0; entry = entry[entrySize:] {
+		frame := seekFrame{
+			offset:      offset,
+			size:        int64(binary.LittleEndian.Uint32(entry)),
+			plainOffset: plainOffset,
+			plainSize:   int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		frames = append(frames, frame)
+		offset += frame.size
+		plainOffset += frame.plainSize
+	}
+	// the frames have to fill the file up to the table
+	if offset != start {
+		return nil
+	}
+	return frames
+}
+
+// entryFrames returns where the frames holding exactly an archived file's
+// contents are in the archive
+func (fs *TarFS) entryFrames(path string) (offset, size int64, ok bool) {
+	plainOffset, ok := fs.offsets[path]
+	plainSize := fs.sizes[path]
+	if !ok || plainSize == 0 {
+		return 0, 0, false
+	}
+	i, found := slices.BinarySearchFunc(fs.frames, plainOffset, func(frame seekFrame, target int64) int {
+		return cmp.Compare(frame.plainOffset, target)
+	})
+	if !found {
+		return 0, 0, false
+	}
+	end := plainOffset + plainSize
+	for _, frame := range fs.frames[i:] {
+		if frame.plainOffset+frame.plainSize == end {
+			return fs.frames[i].offset, frame.offset + frame.size - fs.frames[i].offset, true
+		}
+		if frame.plainOffset+frame.plainSize > end {
+			break
+		}
+	}
+	return 0, 0, false
+}
+
+// GetZstd opens an archived file's contents as they're stored, zstd
+// frames that decompress to exactly the file, with their size. It fails
+// with errors.ErrUnsupported unless the file has frames of its own: only
+// zst archives written unencrypted since they gained a seek table do.
+func (fs *TarFS) GetZstd(path string) (io.ReadCloser, int64, error) {
+	if _, ok := fs.indices[path]; !ok {
+		return nil, 0, fmt.Errorf("file %s not found", path)
+	}
+	offset, size, ok := fs.entryFrames(path)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s isn't stored in frames of its own", errors.ErrUnsupported, path)
+	}
+
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the archive must still be the one indexed, not a newer one renamed
+	// over it, for the offsets to hold
+	info, err := f.Stat()
+	if err == nil && !os.SameFile(info, fs.info) {
+		err = fmt.Errorf("%s changed since it was indexed", fs.path)
+	}
+	magic := make([]byte, len(zstdFrameMagic))
+	if err == nil {
+		_, err = f.ReadAt(magic, offset)
+	}
+	if err == nil && !bytes.Equal(magic, zstdFrameMagic) {
+		err = fmt.Errorf("%s: no zstd frame at offset %d", fs.path, offset)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return &sectionFile{SectionReader: io.NewSectionReader(f, offset, size), f: f}, size, nil
+}
+
+// sectionFile reads part of a file, closing the file when done
+type sectionFile struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (s *sectionFile) Close() error {
+	return s.f.Close()
+}
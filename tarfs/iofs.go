@@ -0,0 +1,266 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	_ fs.FS        = (*TarFS)(nil)
+	_ fs.ReadDirFS = (*TarFS)(nil)
+	_ fs.StatFS    = (*TarFS)(nil)
+)
+
+// Open opens an archived file for streaming, or a directory implied by the
+// entry paths (such as "2025-07") for ReadDir. The file keeps the archive
+// open until it's closed.
+func (tfs *TarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := tfs.indices[name]; ok {
+		f, err := tfs.openEntry(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return f, nil
+	}
+
+	entries, ok := tfs.dirEntries(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &dirFile{info: tfs.dirInfo(name, entries), entries: entries}, nil
+}
+
+// Stat describes an archived file or implied directory without reading it
+func (tfs *TarFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if info, ok := tfs.fileInfo(name); ok {
+		return info, nil
+	}
+	entries, ok := tfs.dirEntries(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return tfs.dirInfo(name, entries), nil
+}
+
+// ReadDir lists a directory's files and subdirectories, sorted by name
+func (tfs *TarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := tfs.indices[name]; ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, ok := tfs.dirEntries(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+func (tfs *TarFS) fileInfo(name string) (fileInfo, bool) {
+	size, ok := tfs.sizes[name]
+	if !ok {
+		return fileInfo{}, false
+	}
+	return fileInfo{name: path.Base(name), size: size, modTime: tfs.modTimes[name]}, true
+}
+
+// dirEntries collects the immediate children of dir from the entry paths.
+// ok is false if no entry lives under dir.
+func (tfs *TarFS) dirEntries(dir string) ([]fs.DirEntry, bool) {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	children := make(map[string]fs.DirEntry)
+	for entryPath := range tfs.indices {
+		rest, ok := strings.CutPrefix(entryPath, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			subPath := path.Join(dir, sub)
+			if _, seen := children[sub]; !seen {
+				children[sub] = fs.FileInfoToDirEntry(fileInfo{name: sub, dir: true, modTime: tfs.dirModTime(subPath)})
+			}
+			continue
+		}
+		info, _ := tfs.fileInfo(entryPath)
+		children[rest] = fs.FileInfoToDirEntry(info)
+	}
+	if len(children) == 0 && dir != "." {
+		return nil, false
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, entry := range children {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, true
+}
+
+// dirModTime is the newest modification time of any file under dir
+func (tfs *TarFS) dirModTime(dir string) time.Time {
+	var newest time.Time
+	for entryPath, modTime := range tfs.modTimes {
+		if strings.HasPrefix(entryPath, dir+"/") && modTime.After(newest) {
+			newest = modTime
+		}
+	}
+	return newest
+}
+
+func (tfs *TarFS) dirInfo(name string, entries []fs.DirEntry) fileInfo {
+	var newest time.Time
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return fileInfo{name: path.Base(name), dir: true, modTime: newest}
+}
+
+// openEntry returns an archived file's contents, from the entry cache if
+// they're there, caching them if they're small enough
+func (tfs *TarFS) openEntry(name string) (*entryFile, error) {
+	info, _ := tfs.fileInfo(name)
+	if data, ok := tfs.cache.get(tfs, name); ok {
+		return &entryFile{info: info, reader: bytes.NewReader(data)}, nil
+	}
+
+	ef, err := tfs.openArchiveEntry(name)
+	if err != nil || !tfs.cache.fits(info.size) {
+		return ef, err
+	}
+	data, err := io.ReadAll(ef)
+	_ = ef.Close()
+	if err != nil {
+		return nil, err
+	}
+	tfs.cache.put(tfs, name, data)
+	return &entryFile{info: info, reader: bytes.NewReader(data)}, nil
+}
+
+// openArchiveEntry positions a reader at an archived file's contents
+func (tfs *TarFS) openArchiveEntry(name string) (*entryFile, error) {
+	index := tfs.indices[name]
+	info, _ := tfs.fileInfo(name)
+
+	f, err := os.Open(tfs.path)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := newTarReader(f, tfs.format, tfs.opts)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	tarReader := tar.NewReader(tr)
+	var hdr *tar.Header
+	for i := 0; i <= index; i++ {
+		hdr, err = tarReader.Next()
+		if err != nil {
+			_ = tr.Close()
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if hdr.Name != name {
+		_ = tr.Close()
+		_ = f.Close()
+		return nil, errors.New("archive changed since it was indexed: expected " + name + ", found " + hdr.Name)
+	}
+	return &entryFile{info: info, reader: tarReader, tr: tr, f: f}, nil
+}
+
+// fileInfo describes an archived file or an implied directory
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// entryFile streams one archived file, or reads it from the entry cache
+type entryFile struct {
+	info   fileInfo
+	reader io.Reader
+	tr     *tarReader // nil if cached
+	f      *os.File   // nil if cached
+}
+
+func (ef *entryFile) Stat() (fs.FileInfo, error) { return ef.info, nil }
+
+func (ef *entryFile) Read(p []byte) (int, error) { return ef.reader.Read(p) }
+
+func (ef *entryFile) Close() error {
+	// cached entries have no archive open
+	if ef.f == nil {
+		return nil
+	}
+	_ = ef.tr.Close()
+	return ef.f.Close()
+}
+
+// dirFile is an implied directory opened with Open
+type dirFile struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (df *dirFile) Stat() (fs.FileInfo, error) { return df.info, nil }
+
+func (df *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: df.info.name, Err: errors.New("is a directory")}
+}
+
+func (df *dirFile) Close() error { return nil }
+
+// ReadDir follows fs.ReadDirFile: n > 0 returns at most n entries and
+// io.EOF at the end, n <= 0 returns the rest
+func (df *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := df.entries[df.offset:]
+	if n <= 0 {
+		df.offset = len(df.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	df.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,206 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repack rewrites an archive with only the entries a TarFS reads: the last
+// regular file of each name. With maxVolume > 0 it leaves the archive alone
+// and instead splits those entries into volumes of at most about maxVolume
+// compressed bytes (one large file may exceed it), named like
+// 2025-07.001.tar.zst, each a complete archive. It returns the files written.
+func Repack(path string, maxVolume int64, opts ...Option) ([]string, error) {
+	tfs, err := NewTarFS(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[int]bool, len(tfs.indices))
+	for _, index := range tfs.indices {
+		keep[index] = true
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = src.Close() }()
+	tr, err := newTarReader(src, tfs.format, tfs.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tr.Close() }()
+
+	out := &volumeWriter{path: path, format: tfs.format, opts: tfs.opts, maxVolume: maxVolume}
+	defer out.abort()
+
+	tarReader := tar.NewReader(tr)
+	for i := 0; true; i++ {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !keep[i] {
+			continue
+		}
+		if err := out.add(hdr, tarReader); err != nil {
+			return nil, err
+		}
+	}
+	return out.finish()
+}
+
+// volumeWriter writes Repack's output: one temp file renamed over the
+// archive, or a series of volumes
+type volumeWriter struct {
+	path      string
+	format    string
+	opts      options
+	maxVolume int64
+
+	f           *os.File
+	counter     *countingWriter
+	closeSeal   func() error
+	cw          io.WriteCloser
+	tw          *tarWriter
+	entries     int // in the current volume
+	tmpPaths    []string
+	volumePaths []string
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (vw *volumeWriter) add(hdr *tar.Header, body io.Reader) error {
+	if vw.tw == nil || (vw.maxVolume > 0 && vw.entries > 0 && vw.counter.n >= vw.maxVolume-hdr.Size) {
+		if err := vw.next(); err != nil {
+			return err
+		}
+	}
+	if err := vw.tw.writeEntry(hdr, body); err != nil {
+		return err
+	}
+	vw.entries++
+
+	// volumes are sized by bytes on disk, so push out what the
+	// compressor is holding (xz can't, and runs a little over)
+	if vw.maxVolume > 0 {
+		if err := vw.tw.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := vw.cw.(interface{ Flush() error }); ok {
+			return flusher.Flush()
+		}
+	}
+	return nil
+}
+
+// next closes the current volume, if any, and starts another
+func (vw *volumeWriter) next() error {
+	if err := vw.closeVolume(); err != nil {
+		return err
+	}
+
+	target := vw.path
+	if vw.maxVolume > 0 {
+		suffix := ".tar." + vw.format
+		target = fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(vw.path, suffix), len(vw.volumePaths)+1, suffix)
+	}
+	tmpPath := target + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	vw.tmpPaths = append(vw.tmpPaths, tmpPath)
+	vw.volumePaths = append(vw.volumePaths, target)
+
+	vw.f = f
+	vw.counter = &countingWriter{w: f}
+	var sealed io.Writer
+	sealed, vw.closeSeal, err = vw.opts.sealWriter(vw.counter)
+	if err != nil {
+		return err
+	}
+	vw.cw, err = newCompressor(sealed, vw.format, vw.opts)
+	if err != nil {
+		return err
+	}
+	vw.tw = newFramedTarWriter(vw.cw)
+	vw.entries = 0
+	return nil
+}
+
+func (vw *volumeWriter) closeVolume() error {
+	if vw.f == nil {
+		return nil
+	}
+	f := vw.f
+	vw.f = nil
+	if err := vw.tw.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := vw.cw.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := vw.closeSeal(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := syncFile(f, vw.opts); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// finish closes the last volume and renames every temp file into place
+func (vw *volumeWriter) finish() ([]string, error) {
+	// an archive with no files still repacks to an empty one
+	if vw.tw == nil {
+		if err := vw.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := vw.closeVolume(); err != nil {
+		return nil, err
+	}
+	for i, tmpPath := range vw.tmpPaths {
+		if err := os.Rename(tmpPath, vw.volumePaths[i]); err != nil {
+			return vw.volumePaths[:i], err
+		}
+	}
+	vw.tmpPaths = nil
+	if len(vw.volumePaths) > 0 {
+		if err := syncDir(filepath.Dir(vw.volumePaths[0]), vw.opts); err != nil {
+			return vw.volumePaths, err
+		}
+	}
+	return vw.volumePaths, nil
+}
+
+// abort removes temp files left by a failed Repack
+func (vw *volumeWriter) abort() {
+	if vw.f != nil {
+		_ = vw.f.Close()
+	}
+	for _, tmpPath := range vw.tmpPaths {
+		_ = os.Remove(tmpPath)
+	}
+}
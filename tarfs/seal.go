@@ -0,0 +1,83 @@
+package tarfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/paperos-labs/logapi/internal/aeadstream"
+)
+
+// Encrypted archives are the compressed tarball sealed with AES-256-GCM
+// (see aeadstream), under sealMagic
+const sealMagic = "LAT1"
+
+// Option configures how archives are written and read
+type Option func(*options)
+
+type options struct {
+	key             []byte
+	sealer          *aeadstream.Sealer
+	skipVerify      bool
+	level           int
+	zstdConcurrency int
+	zstdWindow      int
+	sync            bool
+}
+
+// WithKey encrypts the archives written with a 32-byte key, and decrypts
+// the ones read (unencrypted archives are still read as they are)
+func WithKey(key []byte) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+func newOptions(opts []Option) (options, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.key != nil {
+		if len(o.key) != aeadstream.KeySize {
+			return o, fmt.Errorf("archive key must be %d bytes, not %d", aeadstream.KeySize, len(o.key))
+		}
+		var err error
+		if o.sealer, err = aeadstream.New(sealMagic, o.key); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// isSealed reports whether an archive file starts with sealMagic
+func isSealed(f *os.File) bool {
+	magic := make([]byte, len(sealMagic))
+	_, err := f.ReadAt(magic, 0)
+	return err == nil && string(magic) == sealMagic
+}
+
+// openSealed returns a reader of f's compressed tarball, decrypting it if
+// it's sealed
+func (o options) openSealed(f *os.File) (io.Reader, error) {
+	if !isSealed(f) {
+		return f, nil
+	}
+	if o.sealer == nil {
+		return nil, fmt.Errorf("%s is encrypted and no archive key is set", f.Name())
+	}
+	return o.sealer.Open(f)
+}
+
+// sealWriter returns w, or a writer encrypting to it if a key is set.
+// closeSeal writes the final chunk; it doesn't close w.
+func (o options) sealWriter(w io.Writer) (sealed io.Writer, closeSeal func() error, err error) {
+	if o.sealer == nil {
+		return w, func() error { return nil }, nil
+	}
+	sw, err := o.sealer.NewWriter(w)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sw, sw.Close, nil
+}
@@ -4,51 +4,90 @@ import (
 	"archive/tar"
 	"compress/bzip2"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"sync"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/ulikunitz/xz"
 )
 
 // TarFS is a streaming virtual filesystem for tar archives
 type TarFS struct {
-	path    string
-	indices map[string]int // last wins
-	sizes   map[string]int64
-	format  string
+	path     string
+	indices  map[string]int // last wins
+	sizes    map[string]int64
+	modTimes map[string]time.Time
+	format   string
+	opts     options
+
+	// offsets has where each file's contents start in the tar stream, and
+	// frames the archive's zstd frames, if it's a seekable zst one (see
+	// GetZstd); info is the archive they were read from
+	offsets map[string]int64
+	frames  []seekFrame
+	info    os.FileInfo
+
+	hashLock sync.Mutex
+	hashes   map[string]string // path -> hex SHA-256, recorded or computed on demand
+
+	cache *EntryCache
 }
 
 // NewTarFS scans a tar archive to index file offsets and sizes
-func NewTarFS(path string) (*TarFS, error) {
+func NewTarFS(path string, opts ...Option) (*TarFS, error) {
 	format := detectFormat(path)
 	if format == "" {
 		return nil, fmt.Errorf("unsupported file format: %s", path)
 	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var frames []seekFrame
+	if format == "zst" && !isSealed(f) {
+		frames = readSeekTable(f, info.Size())
+	}
 
-	tr, err := newTarReader(f, format)
+	tr, err := newTarReader(f, format, o)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = tr.Close() }()
 
 	fs := &TarFS{
-		path:    path,
-		indices: make(map[string]int),
-		sizes:   make(map[string]int64),
-		format:  format,
+		path:     path,
+		indices:  make(map[string]int),
+		sizes:    make(map[string]int64),
+		modTimes: make(map[string]time.Time),
+		format:   format,
+		opts:     o,
+		hashes:   make(map[string]string),
+		offsets:  make(map[string]int64),
+		frames:   frames,
+		info:     info,
 	}
-	tarReader := tar.NewReader(tr)
+	counter := &countingReader{r: tr}
+	tarReader := tar.NewReader(counter)
 
 	for i := 0; true; i++ {
 		hdr, err := tarReader.Next()
@@ -64,6 +103,13 @@ func NewTarFS(path string) (*TarFS, error) {
 			// fmt.Println("[tarfs] CACHE", hdr.Name)
 			fs.indices[hdr.Name] = i
 			fs.sizes[hdr.Name] = hdr.Size
+			fs.modTimes[hdr.Name] = hdr.ModTime
+			fs.offsets[hdr.Name] = counter.n
+			if sum, ok := hdr.PAXRecords[SHA256Record]; ok {
+				fs.hashes[hdr.Name] = sum
+			} else {
+				delete(fs.hashes, hdr.Name)
+			}
 			_, err = io.CopyN(io.Discard, tarReader, hdr.Size)
 			if err != nil {
 				return nil, err
@@ -74,39 +120,19 @@ func NewTarFS(path string) (*TarFS, error) {
 	return fs, nil
 }
 
-// Get fetches a specific file's contents from the tar archive
-func (fs *TarFS) Get(path string) (io.Reader, error) {
-	index, ok := fs.indices[path]
-	if !ok {
-		return nil, fmt.Errorf("file %s not found", path)
+// Get opens a specific file's contents from the tar archive, with its size.
+// The archive stays open until the caller closes the reader.
+func (fs *TarFS) Get(path string) (io.ReadCloser, int64, error) {
+	if _, ok := fs.indices[path]; !ok {
+		return nil, 0, fmt.Errorf("file %s not found", path)
 	}
 	fmt.Printf("[tarfs] GET %s (%s)\n", path, fs.path)
 
-	f, err := os.Open(fs.path)
+	f, err := fs.openEntry(path)
 	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = f.Close() }()
-
-	tr, err := newTarReader(f, fs.format)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = tr.Close() }()
-
-	tarReader := tar.NewReader(tr)
-	var hdr *tar.Header
-	for i := 0; i <= index; i++ {
-		hdr, err = tarReader.Next()
-		if err != nil {
-			return nil, err
-		}
+		return nil, 0, err
 	}
-	if hdr.Name != path {
-		return nil, fmt.Errorf("expected file %s, found %s", path, hdr.Name)
-	}
-
-	return tarReader, nil
+	return f, f.info.size, nil
 }
 
 func (fs *TarFS) EntryPaths() []string {
@@ -122,6 +148,59 @@ func (fs *TarFS) EntryPaths() []string {
 	return paths
 }
 
+// EntrySize returns the size of an archived file
+func (fs *TarFS) EntrySize(path string) (int64, bool) {
+	size, ok := fs.sizes[path]
+	return size, ok
+}
+
+// EntryModTime returns the modification time recorded for an archived file
+func (fs *TarFS) EntryModTime(path string) (time.Time, bool) {
+	modTime, ok := fs.modTimes[path]
+	return modTime, ok
+}
+
+// EntrySHA256 returns the hex SHA-256 of an archived file's contents, as
+// recorded in the archive, or else by decompressing it on the first call.
+func (fs *TarFS) EntrySHA256(path string) (string, error) {
+	fs.hashLock.Lock()
+	sum, ok := fs.hashes[path]
+	fs.hashLock.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	h := sha256.New()
+	err := fs.readEntry(path, func(r io.Reader) error {
+		_, err := io.Copy(h, r)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+
+	fs.hashLock.Lock()
+	fs.hashes[path] = sum
+	fs.hashLock.Unlock()
+	return sum, nil
+}
+
+// readEntry calls fn with the contents of an archived file, keeping the
+// archive open until fn returns
+func (fs *TarFS) readEntry(path string, fn func(io.Reader) error) error {
+	if _, ok := fs.indices[path]; !ok {
+		return fmt.Errorf("file %s not found", path)
+	}
+
+	f, err := fs.openEntry(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return fn(f)
+}
+
 // detectFormat infers compression format from file extension
 func detectFormat(path string) string {
 	switch filepath.Ext(path) {
@@ -135,6 +214,8 @@ func detectFormat(path string) string {
 		return "bz2"
 	case ".xz":
 		return "xz"
+	case ".lz4":
+		return "lz4"
 	default:
 		return ""
 	}
@@ -157,29 +238,38 @@ func (tr *tarReader) Close() error {
 	return nil
 }
 
-// newTarReader creates a reader for the specified compression format
-func newTarReader(f *os.File, format string) (*tarReader, error) {
+// newTarReader creates a reader for the specified compression format,
+// decrypting the archive first if it's encrypted
+func newTarReader(f *os.File, format string, o options) (*tarReader, error) {
+	src, err := o.openSealed(f)
+	if err != nil {
+		return nil, err
+	}
 	switch format {
 	case "gz":
-		gr, err := gzip.NewReader(f)
+		gr, err := gzip.NewReader(src)
 		if err != nil {
 			return nil, err
 		}
 		return &tarReader{reader: gr, closer: gr}, nil
 	case "bz2":
-		return &tarReader{reader: bzip2.NewReader(f), closer: nil}, nil
+		return &tarReader{reader: bzip2.NewReader(src), closer: nil}, nil
 	case "zst":
-		zr, err := zstd.NewReader(f)
+		zr, err := zstd.NewReader(src)
 		if err != nil {
 			return nil, err
 		}
 		return &tarReader{reader: zr, closer: nil}, nil
 	case "xz":
-		xr, err := xz.NewReader(f)
+		xr, err := xz.NewReader(src)
 		if err != nil {
 			return nil, err
 		}
 		return &tarReader{reader: xr, closer: nil}, nil
+	case "br":
+		return &tarReader{reader: brotli.NewReader(src), closer: nil}, nil
+	case "lz4":
+		return &tarReader{reader: lz4.NewReader(src), closer: nil}, nil
 	default:
 		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
@@ -0,0 +1,174 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SHA256Record is the PAX record CompressDir and Append use to store each
+// entry's hex SHA-256, so Verify can detect corrupted contents
+const SHA256Record = "LOGAPI.sha256"
+
+// Verify reads a whole archive, checking the compression stream, the tar
+// structure, each entry's size and, where recorded, its SHA-256. It
+// returns the number of entries checked.
+func Verify(path string, opts ...Option) (int, error) {
+	format := detectFormat(path)
+	if format == "" {
+		return 0, fmt.Errorf("unsupported file format: %s", path)
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	return verifyArchive(path, format, o, nil)
+}
+
+// SkipVerify has CompressDir, Append and CompressAndRemove trust the
+// tarballs they write instead of reading them back first
+func SkipVerify() Option {
+	return func(o *options) {
+		o.skipVerify = true
+	}
+}
+
+// WithSync has the archives written fsynced, with the directory they're
+// renamed into, before CompressDir, Append, Convert or Repack return, and
+// before CompressAndRemove removes the month's directory
+func WithSync() Option {
+	return func(o *options) {
+		o.sync = true
+	}
+}
+
+// syncFile fsyncs f if WithSync was given
+func syncFile(f *os.File, o options) error {
+	if !o.sync {
+		return nil
+	}
+	return f.Sync()
+}
+
+// syncDir fsyncs dir, so a rename into it is durable, if WithSync was given
+func syncDir(dir string, o options) error {
+	if !o.sync {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
+
+// verifyArchive does Verify's checks on an archive in format, calling fn,
+// if set, with each entry's header and hex SHA-256
+func verifyArchive(path, format string, o options, fn func(hdr *tar.Header, sum string)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	tr, err := newTarReader(f, format, o)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tr.Close() }()
+
+	tarReader := tar.NewReader(tr)
+	entries := 0
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, fmt.Errorf("after %d entries: %w", entries, err)
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, tarReader)
+		if err != nil {
+			return entries, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		if n != hdr.Size {
+			return entries, fmt.Errorf("%s: read %d of %d bytes", hdr.Name, n, hdr.Size)
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		if want, ok := hdr.PAXRecords[SHA256Record]; ok && sum != want {
+			return entries, fmt.Errorf("%s: SHA-256 is %s, expected %s", hdr.Name, sum, want)
+		}
+		if fn != nil {
+			fn(hdr, sum)
+		}
+		entries++
+	}
+
+	// the compression stream may carry its own checksum after the tar
+	// trailer (gzip, xz), and encryption its final chunk, which only a read
+	// to the end checks
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// verifySource checks a freshly written archive against the files it was
+// written from, named relative to dataDir/date: each must be there, as the
+// last regular file of its name, with the size and SHA-256 it has on disk
+// now
+func verifySource(path, format string, o options, dataDir, date string, files []string) error {
+	type entry struct {
+		size int64
+		sum  string
+	}
+	entries := make(map[string]entry)
+	_, err := verifyArchive(path, format, o, func(hdr *tar.Header, sum string) {
+		if hdr.Typeflag == tar.TypeReg {
+			entries[hdr.Name] = entry{hdr.Size, sum}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		relPath := filepath.Join(date, name)
+		got, ok := entries[relPath]
+		if !ok {
+			return fmt.Errorf("%s is missing", relPath)
+		}
+		size, sum, err := fileSHA256(filepath.Join(dataDir, relPath))
+		if err != nil {
+			return err
+		}
+		if got.size != size {
+			return fmt.Errorf("%s: archived %d bytes, %d on disk", relPath, got.size, size)
+		}
+		if got.sum != sum {
+			return fmt.Errorf("%s: archived SHA-256 is %s, %s on disk", relPath, got.sum, sum)
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,52 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Walk reads an archive once, from start to end, calling fn with each
+// regular file's header and contents. A name may come more than once, when
+// files were appended to the archive; the last one is the current file.
+// An error from fn stops the walk and is returned.
+func Walk(path string, fn func(hdr *tar.Header, r io.Reader) error, opts ...Option) error {
+	format := detectFormat(path)
+	if format == "" {
+		return fmt.Errorf("unsupported file format: %s", path)
+	}
+	o, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	tr, err := newTarReader(f, format, o)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tr.Close() }()
+
+	tarReader := tar.NewReader(tr)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr, tarReader); err != nil {
+			return err
+		}
+	}
+}
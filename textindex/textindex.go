@@ -0,0 +1,281 @@
+// Package textindex is a trigram index for substring searches over a set
+// of files, such as an archived month. For each three-byte sequence it
+// records which files contain it; a query's trigrams narrow the files down
+// to the few that may contain it, which Grep then confirms and locates.
+package textindex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+const magic = "LTX1"
+
+// maxLine is how much of a line Grep returns; longer lines are still
+// searched in full
+const maxLine = 1024
+
+// Builder collects the trigrams of files to index
+type Builder struct {
+	names    map[string]int // name -> index into trigrams
+	trigrams []map[uint32]struct{}
+}
+
+// NewBuilder returns an empty index builder
+func NewBuilder() *Builder {
+	return &Builder{names: make(map[string]int)}
+}
+
+// Add reads a file's contents into the index. Adding a name again replaces
+// what was read for it before.
+func (b *Builder) Add(name string, r io.Reader) error {
+	set := make(map[uint32]struct{})
+	br := bufio.NewReaderSize(r, 64<<10)
+	var t uint32
+	n := 0
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		t = (t<<8 | uint32(c)) & 0xffffff
+		if n++; n >= 3 {
+			set[t] = struct{}{}
+		}
+	}
+
+	if i, ok := b.names[name]; ok {
+		b.trigrams[i] = set
+		return nil
+	}
+	b.names[name] = len(b.trigrams)
+	b.trigrams = append(b.trigrams, set)
+	return nil
+}
+
+// WriteTo writes the index, gzipped
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	names := slices.Sorted(maps.Keys(b.names))
+	postings := make(map[uint32][]uint32)
+	for id, name := range names {
+		for t := range b.trigrams[b.names[name]] {
+			postings[t] = append(postings[t], uint32(id))
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	zw := gzip.NewWriter(cw)
+	bw := bufio.NewWriter(zw)
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	_, _ = bw.WriteString(magic)
+	writeUvarint(uint64(len(names)))
+	for _, name := range names {
+		writeUvarint(uint64(len(name)))
+		_, _ = bw.WriteString(name)
+	}
+	writeUvarint(uint64(len(postings)))
+	for _, t := range slices.Sorted(maps.Keys(postings)) {
+		_, _ = bw.Write([]byte{byte(t >> 16), byte(t >> 8), byte(t)})
+		ids := postings[t] // already ascending
+		writeUvarint(uint64(len(ids)))
+		prev := uint32(0)
+		for _, id := range ids {
+			writeUvarint(uint64(id - prev))
+			prev = id
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	if err := zw.Close(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// Index is a loaded index
+type Index struct {
+	names    []string
+	postings map[uint32][]uint32
+}
+
+// Read loads an index written by Builder.WriteTo
+func Read(r io.Reader) (*Index, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(zr)
+
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if string(head) != magic {
+		return nil, errors.New("not a text index")
+	}
+
+	nNames, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	ix := &Index{postings: make(map[uint32][]uint32)}
+	for range nNames {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		ix.names = append(ix.names, string(name))
+	}
+
+	nTrigrams, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	var tb [3]byte
+	for range nTrigrams {
+		if _, err := io.ReadFull(br, tb[:]); err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if count > nNames {
+			return nil, fmt.Errorf("corrupt text index: %d files for a trigram of %d", count, nNames)
+		}
+		ids := make([]uint32, count)
+		prev := uint64(0)
+		for i := range ids {
+			delta, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, err
+			}
+			prev += delta
+			if prev >= nNames {
+				return nil, fmt.Errorf("corrupt text index: file %d of %d", prev, nNames)
+			}
+			ids[i] = uint32(prev)
+		}
+		ix.postings[uint32(tb[0])<<16|uint32(tb[1])<<8|uint32(tb[2])] = ids
+	}
+	return ix, nil
+}
+
+// Names returns every indexed file, sorted
+func (ix *Index) Names() []string {
+	return ix.names
+}
+
+// Candidates returns the files that have every trigram of query, sorted,
+// which is a superset of the files that contain it. Queries shorter than
+// three bytes have no trigrams, so for them it returns false.
+func (ix *Index) Candidates(query string) ([]string, bool) {
+	if len(query) < 3 {
+		return nil, false
+	}
+	var ids []uint32
+	for i := 0; i+3 <= len(query); i++ {
+		t := uint32(query[i])<<16 | uint32(query[i+1])<<8 | uint32(query[i+2])
+		posting := ix.postings[t]
+		if i == 0 {
+			ids = slices.Clone(posting)
+		} else {
+			ids = intersect(ids, posting)
+		}
+		if len(ids) == 0 {
+			return nil, true
+		}
+	}
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = ix.names[id]
+	}
+	return names, true
+}
+
+// intersect keeps the ids of a that are in b; both are ascending
+func intersect(a, b []uint32) []uint32 {
+	out := a[:0]
+	j := 0
+	for _, id := range a {
+		for j < len(b) && b[j] < id {
+			j++
+		}
+		if j < len(b) && b[j] == id {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Grep calls fn with the byte offset and text (cut to 1 KiB) of each line
+// of r containing query, until fn returns false. line is only valid until
+// fn returns.
+func Grep(r io.Reader, query string, fn func(offset int64, line []byte) bool) error {
+	q := []byte(query)
+	br := bufio.NewReaderSize(r, 64<<10)
+	var offset int64
+	for {
+		line, err := readLine(br)
+		if len(line) > 0 && bytes.Contains(line, q) {
+			text := bytes.TrimSuffix(line, []byte("\n"))
+			if len(text) > maxLine {
+				text = text[:maxLine]
+			}
+			if !fn(offset, text) {
+				return nil
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads through the next newline, however long the line is
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	long := slices.Clone(line)
+	for err == bufio.ErrBufferFull {
+		line, err = br.ReadSlice('\n')
+		long = append(long, line...)
+	}
+	return long, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
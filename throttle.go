@@ -0,0 +1,188 @@
+package logapi
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// freeFailures is how many failed logins in a row a username or
+	// address gets before it has to wait between attempts
+	freeFailures = 3
+	// backoffBase is the first wait, which doubles with each failure
+	backoffBase = time.Second
+	// throttlePruneEvery is how often forgotten failures are dropped
+	throttlePruneEvery = time.Minute
+)
+
+// throttleKey is a username or client address whose failures are counted
+type throttleKey struct {
+	kind  string // "user" or "remote"
+	value string
+}
+
+// loginFailures counts a key's failed logins in a row
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time // no attempts before this
+}
+
+// loginThrottle tracks failed logins per username and per client address
+type loginThrottle struct {
+	maxFailures int
+	lockout     time.Duration
+
+	mu        sync.Mutex
+	failures  map[throttleKey]*loginFailures
+	lastPrune time.Time
+}
+
+// WithLoginThrottle slows down password guessing. After 3 failed logins in
+// a row for a username, or from an address, each further attempt must wait
+// twice as long as the one before (from 1s), and after maxFailures they're
+// locked out for lockout. Attempts that come too soon get a 429 with
+// Retry-After, even with the right password. A successful login clears the
+// count. Zero maxFailures turns it off.
+func WithLoginThrottle(maxFailures int, lockout time.Duration) Option {
+	return func(s *Server) {
+		s.throttle = nil
+		if maxFailures > 0 {
+			s.throttle = &loginThrottle{
+				maxFailures: maxFailures,
+				lockout:     lockout,
+				failures:    make(map[throttleKey]*loginFailures),
+			}
+		}
+	}
+}
+
+// throttleKeys returns the username a request tries to log in as, if any,
+// and its client address
+func throttleKeys(r *http.Request) []throttleKey {
+	keys := []throttleKey{{kind: "remote", value: remoteIP(r)}}
+	if username := loginUsername(r); username != "" {
+		keys = append(keys, throttleKey{kind: "user", value: username})
+	}
+	return keys
+}
+
+// loginUsername returns the username of a Basic or Digest Authorization
+// header, before its password is checked
+func loginUsername(r *http.Request) string {
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		if params, found := strings.CutPrefix(r.Header.Get("Authorization"), "Digest "); found {
+			username = parseDigestParams(params)["username"]
+		}
+	}
+	return username
+}
+
+// remoteIP is the address a request came from, without its port
+func remoteIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return remote
+}
+
+// wait returns how long until any of the keys may try again, or 0
+func (t *loginThrottle) wait(keys []throttleKey, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if f, ok := t.failures[key]; ok && f.until.After(now) {
+			wait = max(wait, f.until.Sub(now))
+		}
+	}
+	return wait
+}
+
+// fail counts a failed login for each key, returning those it locked out
+func (t *loginThrottle) fail(keys []throttleKey, now time.Time) []throttleKey {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+
+	var locked []throttleKey
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			t.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		switch {
+		case f.count >= t.maxFailures:
+			f.until = now.Add(t.lockout)
+			if f.count == t.maxFailures {
+				locked = append(locked, key)
+			}
+		case f.count >= freeFailures:
+			shift := min(f.count-freeFailures, 30)
+			f.until = now.Add(min(backoffBase<<shift, t.lockout))
+		}
+	}
+	return locked
+}
+
+// succeed clears the keys' failures
+func (t *loginThrottle) succeed(keys []throttleKey) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+// prune forgets failures from more than a lockout ago. The caller holds mu.
+func (t *loginThrottle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < throttlePruneEvery {
+		return
+	}
+	t.lastPrune = now
+	for key, f := range t.failures {
+		if now.Sub(f.last) > t.lockout && !f.until.After(now) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// tooManyAttempts writes the 429 for a login attempt that came too soon
+func (s *Server) tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	s.jsonError(w, http.StatusTooManyRequests, "too_many_attempts", "Too many failed logins", fmt.Sprintf("Try again in %d seconds", seconds))
+}
+
+// auditLockouts records the usernames and addresses a failure locked out
+func (s *Server) auditLockouts(keys []throttleKey, now time.Time) {
+	for _, key := range keys {
+		s.audit("login_lockout",
+			slog.String(key.kind, key.value),
+			slog.Int("failures", s.throttle.maxFailures),
+			slog.Time("until", now.Add(s.throttle.lockout)),
+		)
+	}
+}
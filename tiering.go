@@ -0,0 +1,312 @@
+package logapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/paperos-labs/logapi/objstore"
+)
+
+// coldSuffix marks the stub left in place of a tarball moved to cold
+// storage: {YYYY-MM}.tar.{format}.cold
+const coldSuffix = ".cold"
+
+// ColdStore keeps archived months' tarballs off the local disk, e.g. an
+// *objstore.Bucket. Get fails with fs.ErrNotExist for a missing key.
+type ColdStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, sum string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ColdRestorer is implemented by cold stores with archive tiers, such as
+// *objstore.Bucket with S3 Glacier, whose Get fails with
+// objstore.ErrArchived until the object is restored. Both return
+// objstore.Available, objstore.Archived or objstore.Restoring.
+type ColdRestorer interface {
+	RestoreStatus(ctx context.Context, key string) (string, error)
+	Restore(ctx context.Context, key string) (string, error)
+}
+
+// coldStub describes a tarball in cold storage, for fetching it back
+type coldStub struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// SetColdStore lets Tier move tarballs to cold, which reads then fetch
+// back as needed. Call it before use.
+func (fsys *FSStorage) SetColdStore(cold ColdStore) {
+	fsys.cold = cold
+}
+
+// Tier moves a month's tarball to cold storage, leaving a stub, and
+// reports whether it was uploaded. A tarball fetched back since, and not
+// changed, is only removed again.
+func (fsys *FSStorage) Tier(user, date string) (bool, error) {
+	if fsys.cold == nil {
+		return false, fmt.Errorf("no cold store: %w", errors.ErrUnsupported)
+	}
+	tarPath, ok := fsys.tarPath(user, date)
+	if !ok {
+		return false, fmt.Errorf("%w: %s/%s has no local tarball", fs.ErrNotExist, user, date)
+	}
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		return false, err
+	}
+
+	stubPath := tarPath + coldSuffix
+	stub, err := readColdStub(stubPath)
+	uploaded := err != nil || stub.Size != info.Size() || !stub.ModTime.Equal(info.ModTime())
+	if uploaded {
+		rel, err := filepath.Rel(fsys.root, tarPath)
+		if err != nil {
+			return false, err
+		}
+		stub = coldStub{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()}
+		if stub.SHA256, err = fileSHA256(tarPath); err != nil {
+			return false, err
+		}
+		f, err := os.Open(tarPath)
+		if err != nil {
+			return false, err
+		}
+		err = fsys.cold.Put(context.Background(), stub.Key, f, stub.Size, stub.SHA256)
+		_ = f.Close()
+		if err != nil {
+			return false, err
+		}
+		if err := writeColdStub(stubPath, stub); err != nil {
+			return false, err
+		}
+		// the stub must outlast the tarball
+		if fsys.sync {
+			if err := fsys.syncDirs(filepath.Dir(stubPath), false); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	fsys.tars.remove(tarCacheKey{user, date})
+	fsys.tarPaths.Delete(tarCacheKey{user, date})
+	return uploaded, os.Remove(tarPath)
+}
+
+// thaw fetches a cold month's tarball back to where it was, checking it
+// against the stub, and returns its path. It stays until Tier removes it
+// again.
+func (fsys *FSStorage) thaw(user, date string) (string, error) {
+	stubPath, ok := fsys.coldStubPath(user, date)
+	if !ok {
+		return "", fmt.Errorf("%w: %s/%s has no tarball", fs.ErrNotExist, user, date)
+	}
+	fsys.thawLock.Lock()
+	defer fsys.thawLock.Unlock()
+	if tarPath, ok := fsys.tarPath(user, date); ok {
+		return tarPath, nil // thawed while waiting
+	}
+
+	stub, err := readColdStub(stubPath)
+	if err != nil {
+		return "", err
+	}
+	body, err := fsys.cold.Get(context.Background(), stub.Key)
+	if errors.Is(err, objstore.ErrArchived) {
+		return "", fmt.Errorf("%w: %s/%s", ErrRestoreRequired, user, date)
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching %s/%s: %w", user, date, err)
+	}
+	defer func() { _ = body.Close() }()
+
+	tarPath := stubPath[:len(stubPath)-len(coldSuffix)]
+	tmpPath := tarPath + ".thaw"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching %s/%s: %w", user, date, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != stub.SHA256 {
+		return "", fmt.Errorf("fetching %s/%s: sha256 %s, want %s", user, date, sum, stub.SHA256)
+	}
+	// Tier knows an unchanged copy by its modification time
+	if err := os.Chtimes(tmpPath, stub.ModTime, stub.ModTime); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, tarPath); err != nil {
+		return "", err
+	}
+	return tarPath, nil
+}
+
+// localTarPath finds a month's tarball like tarPath, fetching it from
+// cold storage if that's where it is
+func (fsys *FSStorage) localTarPath(user, date string) (string, error) {
+	if tarPath, ok := fsys.tarPath(user, date); ok {
+		return tarPath, nil
+	}
+	if fsys.cold == nil {
+		return "", fmt.Errorf("%w: %s/%s has no tarball", fs.ErrNotExist, user, date)
+	}
+	return fsys.thaw(user, date)
+}
+
+// coldStubPath finds a month's cold stub, in any archive format
+func (fsys *FSStorage) coldStubPath(user, date string) (string, bool) {
+	for _, format := range archiveFormats {
+		stubPath := filepath.Join(fsys.userDir(user), date+".tar."+format+coldSuffix)
+		if _, err := os.Stat(stubPath); err == nil {
+			return stubPath, true
+		}
+	}
+	return "", false
+}
+
+// removeCold deletes a stub and the tarball it points to
+func (fsys *FSStorage) removeCold(stubPath string) error {
+	stub, err := readColdStub(stubPath)
+	if err != nil {
+		return err
+	}
+	if fsys.cold == nil {
+		return fmt.Errorf("%s is in cold storage, which isn't configured", stub.Key)
+	}
+	if err := fsys.cold.Delete(context.Background(), stub.Key); err != nil {
+		return err
+	}
+	return os.Remove(stubPath)
+}
+
+// removeColdCopy deletes the cold copy of a tarball that's been rewritten
+// in another format, if it has one
+func (fsys *FSStorage) removeColdCopy(tarPath string) error {
+	err := fsys.removeCold(tarPath + coldSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func readColdStub(stubPath string) (coldStub, error) {
+	var stub coldStub
+	data, err := os.ReadFile(stubPath)
+	if err != nil {
+		return stub, err
+	}
+	if err := json.Unmarshal(data, &stub); err != nil {
+		return stub, fmt.Errorf("%s: %w", stubPath, err)
+	}
+	return stub, nil
+}
+
+func writeColdStub(stubPath string, stub coldStub) error {
+	data, err := json.MarshalIndent(stub, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := stubPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, stubPath)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WithTierAfter moves archived months older than months to the storage's
+// cold store when TierArchives runs, so the local disk only holds the
+// recent ones. Zero keeps every tarball local.
+func WithTierAfter(months int) Option {
+	return func(s *Server) {
+		s.tierAfter = months
+	}
+}
+
+// TierArchives moves each archived month past the tiering window that
+// still has a local tarball to cold storage, returning "user/YYYY-MM" for
+// each. Months fetched back for reading since are removed again. Each is
+// recorded in the audit log.
+func (s *Server) TierArchives(now time.Time) ([]string, error) {
+	months, err := s.tierable(now)
+	if err != nil {
+		return nil, err
+	}
+	tierer, _ := s.store.(Tierer)
+	var tiered []string
+	for _, userMonth := range months {
+		user, month, _ := strings.Cut(userMonth, "/")
+		s.commitLock.Lock()
+		uploaded, err := tierer.Tier(user, month)
+		s.commitLock.Unlock()
+		if err != nil {
+			return tiered, fmt.Errorf("%s: %w", userMonth, err)
+		}
+		s.audit("tier_archive",
+			slog.String("user", user),
+			slog.String("month", month),
+			slog.Bool("uploaded", uploaded),
+		)
+		tiered = append(tiered, userMonth)
+	}
+	return tiered, nil
+}
+
+// tierable returns the months TierArchives would move, as "user/YYYY-MM"
+func (s *Server) tierable(now time.Time) ([]string, error) {
+	if _, ok := s.store.(Tierer); s.tierAfter <= 0 || !ok {
+		return nil, nil
+	}
+	cutoff := now.UTC().AddDate(0, -s.tierAfter, 0).Format("2006-01")
+
+	users, err := s.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	var tierable []string
+	for _, user := range users {
+		months, err := s.store.Months(user)
+		if err != nil {
+			continue
+		}
+		for _, month := range months {
+			if !month.Archived || month.Live || month.Cold || month.Name >= cutoff {
+				continue
+			}
+			tierable = append(tierable, user+"/"+month.Name)
+		}
+	}
+	return tierable, nil
+}
@@ -0,0 +1,112 @@
+package logapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileVersion is a prior version of a file, kept when an upload replaced it
+type FileVersion struct {
+	Version int       `json:"version"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Versions returns the kept versions of a file, oldest first
+func (fsys *FSStorage) Versions(user, date, name string) ([]FileVersion, error) {
+	dir, base, err := fsys.versionDir(user, date, name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	versions := []FileVersion{}
+	for _, entry := range entries {
+		n, ok := strings.CutPrefix(entry.Name(), base+"@")
+		version, err := strconv.Atoi(n)
+		if !ok || err != nil || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, FileVersion{Version: version, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	slices.SortFunc(versions, func(a, b FileVersion) int {
+		return a.Version - b.Version
+	})
+	return versions, nil
+}
+
+// OpenVersion reads a kept version of a file
+func (fsys *FSStorage) OpenVersion(user, date, name string, version int) (io.ReadCloser, error) {
+	dir, base, err := fsys.versionDir(user, date, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(dir, fmt.Sprintf("%s@%d", base, version)))
+}
+
+// versionDir checks a file's path like filePath, and returns the directory
+// its versions are kept in and their base name
+func (fsys *FSStorage) versionDir(user, date, name string) (string, string, error) {
+	if _, err := fsys.filePath(user, date, name); err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(fsys.userDir(user), versionsDir, date, filepath.Dir(filepath.FromSlash(name)))
+	return dir, filepath.Base(name), nil
+}
+
+// writeVersions answers ?versions=true with a file's kept versions, and
+// ?version=N with one of them
+func (s *Server) writeVersions(w http.ResponseWriter, r *http.Request, user, date, name string) {
+	versioner, ok := s.store.(Versioner)
+	if !ok {
+		s.jsonError(w, http.StatusNotImplemented, "versions_unsupported", "Versions not supported", "This server's storage doesn't keep file versions")
+		return
+	}
+	date, prefix, _ := splitDate(date)
+	name = prefix + name
+
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			s.jsonError(w, http.StatusBadRequest, "invalid_version", "Invalid version", fmt.Sprintf("%q is not a version number", v))
+			return
+		}
+		f, err := versioner.OpenVersion(user, date, name, version)
+		if err != nil {
+			s.jsonError(w, http.StatusNotFound, "version_not_found", "Version not found", fmt.Sprintf("%s/%s has no version %d", date, name, version))
+			return
+		}
+		defer func() { _ = f.Close() }()
+		_, _ = io.Copy(w, f)
+		return
+	}
+
+	versions, err := versioner.Versions(user, date, name)
+	if err != nil {
+		s.jsonError(w, http.StatusInternalServerError, "server_error", "Server error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(map[string]any{
+		"results": versions,
+	})
+}
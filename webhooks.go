@@ -0,0 +1,193 @@
+package logapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const webhookAttempts = 3
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhook is a URL that events are POSTed to as JSON, signed with an
+// HMAC-SHA256 of the body in X-Logapi-Signature when Secret is set
+type Webhook struct {
+	URL    string
+	Secret string
+	// Events to send; empty for all of them
+	Events []string
+	// QuotaBytes, if not 0, sends a quota event when an upload takes a
+	// user's stored bytes (live plus archived) from below it to at least it
+	QuotaBytes int64
+}
+
+// LoadWebhooks reads a url, secret, events, quota_bytes TSV. events is a
+// comma-separated list of upload, delete, compress, quota and
+// auth_failure, or empty for all;
+// secret and quota_bytes may be empty.
+func LoadWebhooks(f *os.File) ([]Webhook, error) {
+	var hooks []Webhook
+
+	csvr := csv.NewReader(f)
+	csvr.Comma = '\t'
+	_, _ = csvr.Read() // strip header row
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || (len(record) == 1 && len(record[0]) == 0) {
+			continue
+		}
+
+		if len(record) != 4 {
+			return nil, fmt.Errorf("invalid %q format: %#v (%d)", f.Name(), record, len(record))
+		}
+		hook := Webhook{URL: record[0], Secret: record[1]}
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", hook.URL)
+		}
+		if record[2] != "" {
+			for _, event := range strings.Split(record[2], ",") {
+				if !slices.Contains(eventNames, event) {
+					return nil, fmt.Errorf("invalid event %q for %s: must be one of %s", event, hook.URL, strings.Join(eventNames, ", "))
+				}
+				hook.Events = append(hook.Events, event)
+			}
+		}
+		if record[3] != "" {
+			hook.QuotaBytes, err = strconv.ParseInt(record[3], 10, 64)
+			if err != nil || hook.QuotaBytes < 0 {
+				return nil, fmt.Errorf("invalid quota_bytes %q for %s", record[3], hook.URL)
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// wants reports whether the webhook subscribes to an event
+func (hook Webhook) wants(event string) bool {
+	return len(hook.Events) == 0 || slices.Contains(hook.Events, event)
+}
+
+// WithWebhooks sends the events each webhook wants to it, in the
+// background, retrying failed deliveries a few times
+func WithWebhooks(hooks ...Webhook) Option {
+	return func(s *Server) {
+		s.events.hooks = append(s.events.hooks, hooks...)
+	}
+}
+
+// notifyUpload sends an upload event for a stored file, and a quota event
+// to any webhook whose threshold the upload crossed
+func (s *Server) notifyUpload(user, date, name string) {
+	s.notify(Event{Event: EventUpload, User: user, Date: date, Name: name})
+
+	if slices.ContainsFunc(s.events.hooks, func(hook Webhook) bool { return hook.QuotaBytes > 0 }) {
+		s.events.deliveries.Add(1)
+		go func() {
+			defer s.events.deliveries.Done()
+			s.checkQuota(user)
+		}()
+	}
+}
+
+// checkQuota sends quota events for the thresholds a user's stored bytes
+// have crossed since the last check. The first check after startup only
+// records them.
+func (s *Server) checkQuota(user string) {
+	s.events.usageLock.Lock()
+	defer s.events.usageLock.Unlock()
+
+	st, err := s.UserStats(user)
+	if err != nil {
+		log.Printf("Checking %s's quota failed: %v", user, err)
+		return
+	}
+	used := st.LiveBytes + st.ArchiveBytes
+	if s.events.usage == nil {
+		s.events.usage = make(map[string]int64)
+	}
+	prev, known := s.events.usage[user]
+	s.events.usage[user] = used
+	if !known {
+		return
+	}
+
+	for _, hook := range s.events.hooks {
+		if hook.QuotaBytes > 0 && hook.wants(EventQuota) && prev < hook.QuotaBytes && used >= hook.QuotaBytes {
+			s.send(hook, Event{Event: EventQuota, Time: time.Now().UTC(), User: user, Bytes: used, Threshold: hook.QuotaBytes})
+		}
+	}
+}
+
+// send delivers an event to a webhook in the background
+func (s *Server) send(hook Webhook, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Encoding %s webhook failed: %v", event.Event, err)
+		return
+	}
+
+	s.events.deliveries.Add(1)
+	go func() {
+		defer s.events.deliveries.Done()
+		for attempt := 1; ; attempt++ {
+			err := deliverWebhook(hook, event.Event, body)
+			if err == nil {
+				return
+			}
+			if attempt == webhookAttempts {
+				log.Printf("Webhook %s to %s failed: %v", event.Event, hook.URL, err)
+				return
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}()
+}
+
+// deliverWebhook POSTs one event, failing on a non-2xx response
+func deliverWebhook(hook Webhook, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Logapi-Event", event)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		_, _ = mac.Write(body)
+		req.Header.Set("X-Logapi-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}